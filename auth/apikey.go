@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// APIKeyAuthenticator authenticates static API keys loaded from a file
+type APIKeyAuthenticator struct {
+	keys map[[sha256.Size]byte]*Principal // Keyed by the hash of the key
+}
+
+// apiKeyFile is the format of AUTH_API_KEYS_FILE:
+//
+//	{"keys": [{"key": "s3cret", "principal": "billing", "roles": ["publisher"]}]}
+type apiKeyFile struct {
+	Keys []struct {
+		Key       string   `json:"key"`
+		Principal string   `json:"principal"`
+		Roles     []string `json:"roles"`
+	} `json:"keys"`
+}
+
+// LoadAPIKeys reads API keys from a JSON file
+func LoadAPIKeys(path string) (*APIKeyAuthenticator, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read API keys: %w", err)
+	}
+
+	var file apiKeyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse API keys: %w", err)
+	}
+
+	a := &APIKeyAuthenticator{keys: make(map[[sha256.Size]byte]*Principal)}
+	for i, entry := range file.Keys {
+		if entry.Key == "" || entry.Principal == "" {
+			return nil, fmt.Errorf("API key %d: key and principal are required", i)
+		}
+		a.keys[sha256.Sum256([]byte(entry.Key))] = &Principal{
+			Name:   entry.Principal,
+			Roles:  entry.Roles,
+			Method: "api_key",
+		}
+	}
+	return a, nil
+}
+
+// Lookup returns the principal an API key belongs to. Keys are compared by
+// hash so lookups do not leak key prefixes through timing.
+func (a *APIKeyAuthenticator) Lookup(key string) (*Principal, error) {
+	p, exists := a.keys[sha256.Sum256([]byte(key))]
+	if !exists {
+		return nil, fmt.Errorf("invalid API key")
+	}
+	return p, nil
+}
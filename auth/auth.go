@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Principal is an authenticated client identity
+type Principal struct {
+	Name   string   `json:"name"`
+	Roles  []string `json:"roles,omitempty"`
+	Method string   `json:"method"` // "api_key" or "jwt"
+}
+
+// HasRole reports whether the principal has been granted a role
+func (p *Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator identifies the client making a request
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// ErrNoCredentials is returned when a request carries no credentials
+var ErrNoCredentials = fmt.Errorf("no credentials provided")
+
+// Chain authenticates requests with API keys and JWTs. Bearer tokens that
+// look like JWTs are verified as JWTs; any other credential is treated as an
+// API key.
+type Chain struct {
+	APIKeys *APIKeyAuthenticator // nil when API keys are disabled
+	JWT     *JWTAuthenticator    // nil when JWTs are disabled
+}
+
+// FromEnv builds an authenticator from AUTH_API_KEYS_FILE,
+// AUTH_JWT_HS256_SECRET_FILE and AUTH_JWT_RS256_PUBLIC_KEY_FILE. It returns
+// nil when none of them is set, which leaves authentication disabled.
+func FromEnv() (Authenticator, error) {
+	chain := &Chain{}
+
+	if path := os.Getenv("AUTH_API_KEYS_FILE"); path != "" {
+		keys, err := LoadAPIKeys(path)
+		if err != nil {
+			return nil, err
+		}
+		chain.APIKeys = keys
+	}
+
+	hsPath := os.Getenv("AUTH_JWT_HS256_SECRET_FILE")
+	rsPath := os.Getenv("AUTH_JWT_RS256_PUBLIC_KEY_FILE")
+	if hsPath != "" || rsPath != "" {
+		jwt, err := LoadJWT(hsPath, rsPath)
+		if err != nil {
+			return nil, err
+		}
+		jwt.Issuer = os.Getenv("AUTH_JWT_ISSUER")
+		jwt.Audience = os.Getenv("AUTH_JWT_AUDIENCE")
+		chain.JWT = jwt
+	}
+
+	if chain.APIKeys == nil && chain.JWT == nil {
+		return nil, nil
+	}
+	return chain, nil
+}
+
+// Authenticate implements Authenticator
+func (c *Chain) Authenticate(r *http.Request) (*Principal, error) {
+	credential := credentialFrom(r)
+	if credential == "" {
+		return nil, ErrNoCredentials
+	}
+
+	if c.JWT != nil && strings.Count(credential, ".") == 2 {
+		return c.JWT.Verify(credential)
+	}
+	if c.APIKeys != nil {
+		return c.APIKeys.Lookup(credential)
+	}
+	return nil, fmt.Errorf("invalid credentials")
+}
+
+// credentialFrom extracts the credential from the Authorization or X-API-Key
+// header, falling back to the access_token query parameter for browser
+// WebSocket and EventSource clients that cannot set headers
+func credentialFrom(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); header != "" {
+		scheme, value, found := strings.Cut(header, " ")
+		if found && (strings.EqualFold(scheme, "Bearer") || strings.EqualFold(scheme, "ApiKey")) {
+			return strings.TrimSpace(value)
+		}
+	}
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	return r.URL.Query().Get("access_token")
+}
+
+type contextKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying the principal
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, p)
+}
+
+// FromContext returns the principal bound to ctx, or nil for anonymous
+// requests
+func FromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(contextKey{}).(*Principal)
+	return p
+}
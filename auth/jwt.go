@@ -0,0 +1,198 @@
+package auth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// clockSkew is the leeway allowed when checking exp and nbf
+const clockSkew = 30 * time.Second
+
+// JWTAuthenticator verifies HS256 and RS256 JSON Web Tokens against local
+// keys. The sub claim becomes the principal name and the roles claim its
+// roles.
+type JWTAuthenticator struct {
+	HMACSecret []byte         // nil disables HS256
+	PublicKey  *rsa.PublicKey // nil disables RS256
+	Issuer     string         // Required iss claim when set
+	Audience   string         // Required aud entry when set
+}
+
+// jwtClaims are the registered and custom claims the server understands
+type jwtClaims struct {
+	Subject   string          `json:"sub"`
+	Issuer    string          `json:"iss"`
+	Audience  json.RawMessage `json:"aud"`
+	ExpiresAt *float64        `json:"exp"`
+	NotBefore *float64        `json:"nbf"`
+	Roles     []string        `json:"roles"`
+}
+
+// LoadJWT reads an HS256 secret and/or an RS256 public key in PEM format.
+// Either path may be empty.
+func LoadJWT(secretPath, publicKeyPath string) (*JWTAuthenticator, error) {
+	j := &JWTAuthenticator{}
+
+	if secretPath != "" {
+		secret, err := os.ReadFile(secretPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read JWT secret: %w", err)
+		}
+		secret = bytes.TrimSpace(secret)
+		if len(secret) == 0 {
+			return nil, fmt.Errorf("JWT secret is empty")
+		}
+		j.HMACSecret = secret
+	}
+
+	if publicKeyPath != "" {
+		data, err := os.ReadFile(publicKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read JWT public key: %w", err)
+		}
+		key, err := parseRSAPublicKey(data)
+		if err != nil {
+			return nil, err
+		}
+		j.PublicKey = key
+	}
+	return j, nil
+}
+
+// parseRSAPublicKey decodes a PKIX or PKCS#1 PEM-encoded RSA public key
+func parseRSAPublicKey(data []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("JWT public key is not PEM encoded")
+	}
+
+	if block.Type == "RSA PUBLIC KEY" {
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse JWT public key: %w", err)
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("JWT public key is not an RSA key")
+	}
+	return rsaKey, nil
+}
+
+// Verify checks a token's signature and claims and returns its principal
+func (j *JWTAuthenticator) Verify(token string) (*Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed token header")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token signature")
+	}
+
+	// The algorithm must match a configured key, which rules out "none" and
+	// HS256 tokens signed with the RSA public key
+	signed := []byte(parts[0] + "." + parts[1])
+	switch header.Alg {
+	case "HS256":
+		if j.HMACSecret == nil {
+			return nil, fmt.Errorf("HS256 tokens are not accepted")
+		}
+		mac := hmac.New(sha256.New, j.HMACSecret)
+		mac.Write(signed)
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return nil, fmt.Errorf("invalid token signature")
+		}
+	case "RS256":
+		if j.PublicKey == nil {
+			return nil, fmt.Errorf("RS256 tokens are not accepted")
+		}
+		digest := sha256.Sum256(signed)
+		if err := rsa.VerifyPKCS1v15(j.PublicKey, crypto.SHA256, digest[:], signature); err != nil {
+			return nil, fmt.Errorf("invalid token signature")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported token algorithm %q", header.Alg)
+	}
+
+	var claims jwtClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed token claims")
+	}
+	if err := j.checkClaims(&claims, time.Now()); err != nil {
+		return nil, err
+	}
+
+	return &Principal{Name: claims.Subject, Roles: claims.Roles, Method: "jwt"}, nil
+}
+
+// checkClaims validates the time window, issuer, audience and subject
+func (j *JWTAuthenticator) checkClaims(claims *jwtClaims, now time.Time) error {
+	if claims.ExpiresAt != nil && now.After(unixTime(*claims.ExpiresAt).Add(clockSkew)) {
+		return fmt.Errorf("token has expired")
+	}
+	if claims.NotBefore != nil && now.Add(clockSkew).Before(unixTime(*claims.NotBefore)) {
+		return fmt.Errorf("token is not valid yet")
+	}
+	if j.Issuer != "" && claims.Issuer != j.Issuer {
+		return fmt.Errorf("token issuer is not accepted")
+	}
+	if j.Audience != "" && !hasAudience(claims.Audience, j.Audience) {
+		return fmt.Errorf("token audience is not accepted")
+	}
+	if claims.Subject == "" {
+		return fmt.Errorf("token has no subject")
+	}
+	return nil
+}
+
+// hasAudience reports whether an aud claim, a string or an array of strings,
+// contains the expected audience
+func hasAudience(raw json.RawMessage, expected string) bool {
+	var single string
+	if json.Unmarshal(raw, &single) == nil {
+		return single == expected
+	}
+	var list []string
+	if json.Unmarshal(raw, &list) == nil {
+		for _, aud := range list {
+			if aud == expected {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// decodeSegment decodes a base64url-encoded JSON token segment
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// unixTime converts a NumericDate claim to a time
+func unixTime(seconds float64) time.Time {
+	return time.Unix(int64(seconds), 0)
+}
@@ -0,0 +1,252 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testSecret = []byte("test-secret")
+
+// encodeSegment base64url-encodes a JSON token segment
+func encodeSegment(t *testing.T, v interface{}) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// signHS256 builds an HS256 token over claims
+func signHS256(t *testing.T, secret []byte, claims map[string]interface{}) string {
+	t.Helper()
+	signed := encodeSegment(t, map[string]string{"alg": "HS256", "typ": "JWT"}) + "." + encodeSegment(t, claims)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// signRS256 builds an RS256 token over claims
+func signRS256(t *testing.T, key *rsa.PrivateKey, claims map[string]interface{}) string {
+	t.Helper()
+	signed := encodeSegment(t, map[string]string{"alg": "RS256", "typ": "JWT"}) + "." + encodeSegment(t, claims)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("rsa.SignPKCS1v15() error = %v", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func generateKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey() error = %v", err)
+	}
+	return key
+}
+
+// writeFile writes a file in a test directory and returns its path
+func writeFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("os.WriteFile() error = %v", err)
+	}
+	return path
+}
+
+func TestVerifySignatures(t *testing.T) {
+	key := generateKey(t)
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&key.PublicKey)})
+	j, err := LoadJWT(writeFile(t, "secret", append(testSecret, '\n')), writeFile(t, "key.pem", publicPEM))
+	if err != nil {
+		t.Fatalf("LoadJWT() error = %v", err)
+	}
+	claims := map[string]interface{}{"sub": "alice", "roles": []string{"publisher"}}
+
+	for name, token := range map[string]string{
+		"HS256": signHS256(t, testSecret, claims),
+		"RS256": signRS256(t, key, claims),
+	} {
+		p, err := j.Verify(token)
+		if err != nil {
+			t.Errorf("%s: Verify() error = %v", name, err)
+			continue
+		}
+		if p.Name != "alice" || !p.HasRole("publisher") || p.Method != "jwt" {
+			t.Errorf("%s: Verify() = %+v", name, p)
+		}
+	}
+
+	hs := signHS256(t, testSecret, claims)
+	tampered := strings.Split(hs, ".")
+	tampered[1] = encodeSegment(t, map[string]interface{}{"sub": "mallory"})
+
+	tests := []struct {
+		name  string
+		token string
+		err   string
+	}{
+		{"wrong HS256 secret", signHS256(t, []byte("other"), claims), "invalid token signature"},
+		{"wrong RS256 key", signRS256(t, generateKey(t), claims), "invalid token signature"},
+		{"tampered claims", strings.Join(tampered, "."), "invalid token signature"},
+		{"two segments", "a.b", "malformed token"},
+		{"bad header", "!!." + tampered[1] + "." + tampered[2], "malformed token header"},
+		{"bad signature encoding", tampered[0] + "." + tampered[1] + ".!!", "malformed token signature"},
+	}
+	for _, tt := range tests {
+		if _, err := j.Verify(tt.token); err == nil || err.Error() != tt.err {
+			t.Errorf("%s: Verify() error = %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestVerifyRejectsAlgorithmMismatch(t *testing.T) {
+	key := generateKey(t)
+	publicDER, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("x509.MarshalPKIXPublicKey() error = %v", err)
+	}
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+	claims := map[string]interface{}{"sub": "alice"}
+
+	rsOnly := &JWTAuthenticator{PublicKey: &key.PublicKey}
+	hsOnly := &JWTAuthenticator{HMACSecret: testSecret}
+	none := encodeSegment(t, map[string]string{"alg": "none"}) + "." + encodeSegment(t, claims) + "."
+
+	tests := []struct {
+		name  string
+		j     *JWTAuthenticator
+		token string
+		err   string
+	}{
+		// An HS256 token keyed with the public key must not pass as RS256
+		{"HS256 against RS256 only", rsOnly, signHS256(t, publicPEM, claims), "HS256 tokens are not accepted"},
+		{"RS256 against HS256 only", hsOnly, signRS256(t, key, claims), "RS256 tokens are not accepted"},
+		{"none against RS256", rsOnly, none, `unsupported token algorithm "none"`},
+		{"none against HS256", hsOnly, none, `unsupported token algorithm "none"`},
+	}
+	for _, tt := range tests {
+		if _, err := tt.j.Verify(tt.token); err == nil || err.Error() != tt.err {
+			t.Errorf("%s: Verify() error = %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestCheckClaims(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	at := func(d time.Duration) *float64 {
+		seconds := float64(now.Add(d).Unix())
+		return &seconds
+	}
+	j := &JWTAuthenticator{Issuer: "issuer", Audience: "pubsub"}
+	base := func() jwtClaims {
+		return jwtClaims{Subject: "alice", Issuer: "issuer", Audience: json.RawMessage(`"pubsub"`)}
+	}
+
+	tests := []struct {
+		name   string
+		modify func(c *jwtClaims)
+		err    string // Empty when the claims are valid
+	}{
+		{"valid", func(c *jwtClaims) {}, ""},
+		{"expired within skew", func(c *jwtClaims) { c.ExpiresAt = at(-clockSkew + time.Second) }, ""},
+		{"expired beyond skew", func(c *jwtClaims) { c.ExpiresAt = at(-clockSkew - time.Second) }, "token has expired"},
+		{"not before within skew", func(c *jwtClaims) { c.NotBefore = at(clockSkew - time.Second) }, ""},
+		{"not before beyond skew", func(c *jwtClaims) { c.NotBefore = at(clockSkew + time.Second) }, "token is not valid yet"},
+		{"wrong issuer", func(c *jwtClaims) { c.Issuer = "other" }, "token issuer is not accepted"},
+		{"audience array", func(c *jwtClaims) { c.Audience = json.RawMessage(`["other", "pubsub"]`) }, ""},
+		{"audience array without match", func(c *jwtClaims) { c.Audience = json.RawMessage(`["other"]`) }, "token audience is not accepted"},
+		{"wrong audience", func(c *jwtClaims) { c.Audience = json.RawMessage(`"other"`) }, "token audience is not accepted"},
+		{"missing audience", func(c *jwtClaims) { c.Audience = nil }, "token audience is not accepted"},
+		{"non-string audience", func(c *jwtClaims) { c.Audience = json.RawMessage(`[1]`) }, "token audience is not accepted"},
+		{"no subject", func(c *jwtClaims) { c.Subject = "" }, "token has no subject"},
+	}
+	for _, tt := range tests {
+		claims := base()
+		tt.modify(&claims)
+		err := j.checkClaims(&claims, now)
+		if (tt.err == "" && err != nil) || (tt.err != "" && (err == nil || err.Error() != tt.err)) {
+			t.Errorf("%s: checkClaims() error = %v, want %q", tt.name, err, tt.err)
+		}
+	}
+
+	// Issuer and audience are only checked when configured
+	claims := jwtClaims{Subject: "alice"}
+	if err := (&JWTAuthenticator{}).checkClaims(&claims, now); err != nil {
+		t.Errorf("checkClaims() without issuer or audience error = %v", err)
+	}
+}
+
+func TestChainAuthenticate(t *testing.T) {
+	keys, err := LoadAPIKeys(writeFile(t, "keys.json", []byte(`{"keys": [{"key": "s3cret", "principal": "billing", "roles": ["publisher"]}]}`)))
+	if err != nil {
+		t.Fatalf("LoadAPIKeys() error = %v", err)
+	}
+	token := signHS256(t, testSecret, map[string]interface{}{"sub": "alice"})
+	both := &Chain{APIKeys: keys, JWT: &JWTAuthenticator{HMACSecret: testSecret}}
+	keysOnly := &Chain{APIKeys: keys}
+
+	tests := []struct {
+		name      string
+		chain     *Chain
+		header    string // Header name and value separated by ": "
+		query     string
+		principal string // Empty when authentication fails
+		method    string
+	}{
+		{"bearer API key", both, "Authorization: Bearer s3cret", "", "billing", "api_key"},
+		{"ApiKey scheme", both, "Authorization: ApiKey s3cret", "", "billing", "api_key"},
+		{"X-API-Key header", both, "X-API-Key: s3cret", "", "billing", "api_key"},
+		{"access_token query", both, "", "s3cret", "billing", "api_key"},
+		{"bearer JWT", both, "Authorization: Bearer " + token, "", "alice", "jwt"},
+		{"JWT in query", both, "", token, "alice", "jwt"},
+		{"JWT without JWT support", keysOnly, "Authorization: Bearer " + token, "", "", ""},
+		{"unknown API key", both, "Authorization: Bearer wrong", "", "", ""},
+		{"unsupported scheme", both, "Authorization: Basic s3cret", "", "", ""},
+		{"API key without API key support", &Chain{JWT: both.JWT}, "X-API-Key: s3cret", "", "", ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/topics?access_token="+tt.query, nil)
+		if name, value, found := strings.Cut(tt.header, ": "); found {
+			r.Header.Set(name, value)
+		}
+		p, err := tt.chain.Authenticate(r)
+		if tt.principal == "" {
+			if err == nil {
+				t.Errorf("%s: Authenticate() = %+v, want an error", tt.name, p)
+			}
+			continue
+		}
+		if err != nil || p.Name != tt.principal || p.Method != tt.method {
+			t.Errorf("%s: Authenticate() = %+v, %v, want %s via %s", tt.name, p, err, tt.principal, tt.method)
+		}
+	}
+
+	r := httptest.NewRequest("GET", "/topics", nil)
+	if _, err := both.Authenticate(r); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("Authenticate() without credentials error = %v, want ErrNoCredentials", err)
+	}
+}
+
+func TestLoadAPIKeysRequiresKeyAndPrincipal(t *testing.T) {
+	if _, err := LoadAPIKeys(writeFile(t, "keys.json", []byte(`{"keys": [{"key": "s3cret"}]}`))); err == nil {
+		t.Error("LoadAPIKeys() accepted a key without a principal")
+	}
+}
@@ -0,0 +1,205 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"pub-sub-system/pubsub"
+)
+
+// Actions a policy can grant on a topic
+const (
+	ActionPublish   = "publish"
+	ActionSubscribe = "subscribe"
+	ActionCreate    = "create"
+	ActionDelete    = "delete"
+	ActionReadStats = "read-stats"
+)
+
+// anyone matches every principal, including anonymous ones, and every action
+const anyone = "*"
+
+// Rule grants actions on topics to principals or roles. Topics are topic
+// names or wildcard patterns; ">" alone matches every topic.
+type Rule struct {
+	Principals []string `json:"principals,omitempty"`
+	Roles      []string `json:"roles,omitempty"`
+	Actions    []string `json:"actions"`
+	Topics     []string `json:"topics"`
+}
+
+// Policy is the content of a policy file. Anything not granted by a rule is
+// denied.
+type Policy struct {
+	Rules []Rule `json:"rules"`
+}
+
+// Authorizer decides whether principals may perform actions on topics
+// according to a policy file that can be reloaded while the server runs. A
+// nil Authorizer allows everything.
+type Authorizer struct {
+	path    string
+	mu      sync.RWMutex
+	policy  *Policy
+	modTime time.Time
+}
+
+// AuthorizerFromEnv loads the policy file named by AUTH_POLICY_FILE. It
+// returns nil when the variable is unset, which leaves authorization
+// disabled.
+func AuthorizerFromEnv() (*Authorizer, error) {
+	path := os.Getenv("AUTH_POLICY_FILE")
+	if path == "" {
+		return nil, nil
+	}
+
+	a := &Authorizer{path: path}
+	if err := a.Reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Reload reads the policy file again. The current policy stays in effect
+// if the file cannot be read or is invalid.
+func (a *Authorizer) Reload() error {
+	info, err := os.Stat(a.path)
+	if err != nil {
+		return fmt.Errorf("failed to read policy: %w", err)
+	}
+	data, err := os.ReadFile(a.path)
+	if err != nil {
+		return fmt.Errorf("failed to read policy: %w", err)
+	}
+
+	var policy Policy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return fmt.Errorf("failed to parse policy: %w", err)
+	}
+	if err := validatePolicy(&policy); err != nil {
+		return fmt.Errorf("invalid policy: %w", err)
+	}
+
+	a.mu.Lock()
+	a.policy = &policy
+	a.modTime = info.ModTime()
+	a.mu.Unlock()
+	return nil
+}
+
+// Watch reloads the policy whenever the file's modification time changes,
+// until stop is closed. A broken file is reported once per change. An
+// interval that is not positive checks every five seconds.
+func (a *Authorizer) Watch(interval time.Duration, stop <-chan struct{}) {
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	a.mu.RLock()
+	seen := a.modTime
+	a.mu.RUnlock()
+
+	for {
+		select {
+		case <-ticker.C:
+			info, err := os.Stat(a.path)
+			if err != nil || info.ModTime().Equal(seen) {
+				continue
+			}
+			seen = info.ModTime()
+			if err := a.Reload(); err != nil {
+				log.Printf("Keeping previous authorization policy: %v", err)
+			} else {
+				log.Printf("Reloaded authorization policy from %s", a.path)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// validatePolicy checks the actions and topic patterns of every rule
+func validatePolicy(policy *Policy) error {
+	for i, rule := range policy.Rules {
+		if len(rule.Principals) == 0 && len(rule.Roles) == 0 {
+			return fmt.Errorf("rule %d: principals or roles are required", i)
+		}
+		for _, action := range rule.Actions {
+			switch action {
+			case ActionPublish, ActionSubscribe, ActionCreate, ActionDelete, ActionReadStats, anyone:
+			default:
+				return fmt.Errorf("rule %d: unknown action %q", i, action)
+			}
+		}
+		for _, topic := range rule.Topics {
+			if err := pubsub.ValidatePattern(topic); err != nil {
+				return fmt.Errorf("rule %d: topic %q: %v", i, topic, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Allowed reports whether a principal may perform an action on a topic. The
+// topic may be a wildcard subscription pattern, in which case a rule must
+// cover every topic the pattern can match. A nil principal is anonymous.
+func (a *Authorizer) Allowed(p *Principal, action, topic string) bool {
+	if a == nil {
+		return true
+	}
+
+	a.mu.RLock()
+	policy := a.policy
+	a.mu.RUnlock()
+
+	for _, rule := range policy.Rules {
+		if rule.appliesTo(p) && contains(rule.Actions, action) && rule.coversTopic(topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// appliesTo reports whether a rule names the principal or one of its roles
+func (r *Rule) appliesTo(p *Principal) bool {
+	for _, name := range r.Principals {
+		if name == anyone || (p != nil && name == p.Name) {
+			return true
+		}
+	}
+	if p == nil {
+		return false
+	}
+	for _, role := range r.Roles {
+		if p.HasRole(role) {
+			return true
+		}
+	}
+	return false
+}
+
+// coversTopic reports whether one of the rule's patterns covers a topic
+func (r *Rule) coversTopic(topic string) bool {
+	for _, pattern := range r.Topics {
+		if pubsub.PatternCovers(pattern, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// contains reports whether list holds value or the "*" wildcard
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value || v == anyone {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,369 @@
+package cluster
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"pub-sub-system/env"
+	"pub-sub-system/models"
+	"pub-sub-system/pubsub"
+)
+
+// secretHeader carries the shared cluster secret on node-to-node requests
+const secretHeader = "X-Cluster-Secret"
+
+// maxSendAttempts bounds retries of a replicated operation to one peer
+const maxSendAttempts = 3
+
+// Options configures a cluster node
+type Options struct {
+	NodeID        string
+	Peers         []string      // Base URLs of the other nodes, e.g. http://10.0.0.2:8080
+	Secret        string        // Shared secret required on /cluster requests; empty disables the check
+	RequireSecret bool          // Refuse to start without a Secret, set when clients must authenticate
+	SyncInterval  time.Duration // How often peers' subscriptions are fetched
+	QueueSize     int           // Operations buffered per peer before new ones are dropped
+}
+
+// OptionsFromEnv reads CLUSTER_PEERS, CLUSTER_NODE_ID, CLUSTER_SECRET,
+// CLUSTER_SYNC_INTERVAL_MS and CLUSTER_QUEUE_SIZE. The second result is
+// false when CLUSTER_PEERS is unset, which leaves cluster mode disabled.
+func OptionsFromEnv() (Options, bool) {
+	peers := make([]string, 0)
+	for _, peer := range strings.Split(os.Getenv("CLUSTER_PEERS"), ",") {
+		if peer = strings.TrimSpace(peer); peer != "" {
+			peers = append(peers, strings.TrimRight(peer, "/"))
+		}
+	}
+	if len(peers) == 0 {
+		return Options{}, false
+	}
+
+	nodeID := os.Getenv("CLUSTER_NODE_ID")
+	if nodeID == "" {
+		nodeID, _ = os.Hostname()
+	}
+	return Options{
+		NodeID:       nodeID,
+		Peers:        peers,
+		Secret:       os.Getenv("CLUSTER_SECRET"),
+		SyncInterval: env.Interval("CLUSTER_SYNC_INTERVAL_MS", 250),
+		QueueSize:    env.Int("CLUSTER_QUEUE_SIZE", 10000),
+	}, true
+}
+
+// Node connects a PubSubSystem to statically configured peers. Topic
+// creation, updates and deletion are replicated to every peer, and publishes
+// are forwarded to the peers that have matching subscribers.
+type Node struct {
+	opts   Options
+	ps     *pubsub.PubSubSystem
+	client *http.Client
+	peers  []*peer
+	stop   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// peer is another node and what its clients are subscribed to
+type peer struct {
+	url   string
+	queue chan operation // Replicated operations, sent in order
+
+	mu         sync.RWMutex
+	topics     map[string]bool
+	patterns   []string
+	reconciled bool // Topics were copied from the peer after startup
+}
+
+// operation is a replicated request waiting to be sent to a peer
+type operation struct {
+	method string
+	path   string
+	body   []byte
+}
+
+// topicState is a topic's configuration as exchanged between nodes
+type topicState struct {
+	Name      string                 `json:"name"`
+	Settings  models.TopicSettings   `json:"settings"`
+	Retention models.RetentionPolicy `json:"retention"`
+}
+
+// stateResponse is returned by GET /cluster/state
+type stateResponse struct {
+	NodeID   string       `json:"node_id"`
+	Topics   []topicState `json:"topics"`
+	Interest struct {
+		Topics   []string `json:"topics"`
+		Patterns []string `json:"patterns"`
+	} `json:"interest"`
+}
+
+// New starts a cluster node and installs it as the system's replicator. It
+// fails without a secret when opts.RequireSecret is set, since /cluster
+// requests bypass client authentication and authorization.
+func New(ps *pubsub.PubSubSystem, opts Options) (*Node, error) {
+	if opts.RequireSecret && opts.Secret == "" {
+		return nil, fmt.Errorf("CLUSTER_SECRET is required when authentication or authorization is enabled")
+	}
+	if opts.SyncInterval <= 0 {
+		opts.SyncInterval = 250 * time.Millisecond
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 10000
+	}
+
+	n := &Node{
+		opts:   opts,
+		ps:     ps,
+		client: &http.Client{Timeout: 5 * time.Second},
+		stop:   make(chan struct{}),
+	}
+	for _, peerURL := range opts.Peers {
+		p := &peer{
+			url:    peerURL,
+			queue:  make(chan operation, opts.QueueSize),
+			topics: make(map[string]bool),
+		}
+		n.peers = append(n.peers, p)
+		n.wg.Add(1)
+		go n.sendLoop(p)
+	}
+
+	ps.SetReplicator(n)
+	n.wg.Add(1)
+	go n.syncLoop()
+	return n, nil
+}
+
+// Close stops replication. Operations still queued are discarded.
+func (n *Node) Close() {
+	n.ps.SetReplicator(nil)
+	close(n.stop)
+	n.wg.Wait()
+}
+
+// TopicCreated implements pubsub.Replicator
+func (n *Node) TopicCreated(name string, settings models.TopicSettings, retention models.RetentionPolicy) {
+	n.broadcast(http.MethodPost, "/cluster/topics", topicState{Name: name, Settings: settings, Retention: retention})
+}
+
+// TopicUpdated implements pubsub.Replicator
+func (n *Node) TopicUpdated(name string, settings models.TopicSettings, retention models.RetentionPolicy) {
+	n.broadcast(http.MethodPost, "/cluster/topics", topicState{Name: name, Settings: settings, Retention: retention})
+}
+
+// TopicDeleted implements pubsub.Replicator
+func (n *Node) TopicDeleted(name string) {
+	n.broadcast(http.MethodDelete, "/cluster/topics/"+url.PathEscape(name), nil)
+}
+
+// Published implements pubsub.Replicator by forwarding the message to the
+// peers that have subscribers for the topic
+func (n *Node) Published(topic string, msg *models.Message) {
+	var body []byte
+	for _, p := range n.peers {
+		if !p.interestedIn(topic) {
+			continue
+		}
+		if body == nil {
+			var err error
+			if body, err = json.Marshal(msg); err != nil {
+				log.Printf("Failed to encode message %s for forwarding: %v", msg.ID, err)
+				return
+			}
+		}
+		n.enqueue(p, operation{
+			method: http.MethodPost,
+			path:   "/cluster/topics/" + url.PathEscape(topic) + "/messages",
+			body:   body,
+		})
+	}
+}
+
+// broadcast queues an operation for every peer
+func (n *Node) broadcast(method, path string, v interface{}) {
+	var body []byte
+	if v != nil {
+		var err error
+		if body, err = json.Marshal(v); err != nil {
+			log.Printf("Failed to encode cluster request %s %s: %v", method, path, err)
+			return
+		}
+	}
+	for _, p := range n.peers {
+		n.enqueue(p, operation{method: method, path: path, body: body})
+	}
+}
+
+// enqueue queues an operation for a peer without blocking the caller
+func (n *Node) enqueue(p *peer, op operation) {
+	select {
+	case p.queue <- op:
+	default:
+		log.Printf("Cluster queue for %s is full, dropping %s %s", p.url, op.method, op.path)
+	}
+}
+
+// sendLoop sends a peer's operations in order, retrying failed ones
+func (n *Node) sendLoop(p *peer) {
+	defer n.wg.Done()
+
+	for {
+		select {
+		case op := <-p.queue:
+			var err error
+			for attempt := 0; attempt < maxSendAttempts; attempt++ {
+				if attempt > 0 {
+					time.Sleep(time.Duration(100<<attempt) * time.Millisecond)
+				}
+				if err = n.send(p, op); err == nil {
+					break
+				}
+			}
+			if err != nil {
+				log.Printf("Failed to replicate %s %s to %s: %v", op.method, op.path, p.url, err)
+			}
+		case <-n.stop:
+			return
+		}
+	}
+}
+
+// send performs one request against a peer
+func (n *Node) send(p *peer, op operation) error {
+	req, err := http.NewRequest(op.method, p.url+op.path, bytes.NewReader(op.body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(secretHeader, n.opts.Secret)
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("peer returned %s", resp.Status)
+	}
+	return nil
+}
+
+// syncLoop periodically fetches every peer's subscriptions
+func (n *Node) syncLoop() {
+	defer n.wg.Done()
+
+	ticker := time.NewTicker(n.opts.SyncInterval)
+	defer ticker.Stop()
+
+	n.Sync()
+	for {
+		select {
+		case <-ticker.C:
+			n.Sync()
+		case <-n.stop:
+			return
+		}
+	}
+}
+
+// Sync fetches every peer's subscriptions now. The first successful fetch
+// from a peer also creates the topics this node is missing, so a node that
+// joins late catches up with topics created before it started.
+func (n *Node) Sync() {
+	for _, p := range n.peers {
+		state, err := n.fetchState(p)
+		if err != nil {
+			continue // Keep the last known subscriptions
+		}
+
+		p.mu.Lock()
+		p.topics = make(map[string]bool, len(state.Interest.Topics))
+		for _, name := range state.Interest.Topics {
+			p.topics[name] = true
+		}
+		p.patterns = state.Interest.Patterns
+		reconcile := !p.reconciled
+		p.reconciled = true
+		p.mu.Unlock()
+
+		if reconcile {
+			for _, topic := range state.Topics {
+				if _, exists := n.ps.GetTopic(topic.Name); !exists {
+					if err := n.ps.ApplyRemoteTopic(topic.Name, topic.Settings, topic.Retention); err != nil {
+						log.Printf("Failed to copy topic %s from %s: %v", topic.Name, p.url, err)
+					}
+				}
+			}
+		}
+	}
+}
+
+// fetchState reads a peer's topics and subscriptions
+func (n *Node) fetchState(p *peer) (*stateResponse, error) {
+	req, err := http.NewRequest(http.MethodGet, p.url+"/cluster/state", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(secretHeader, n.opts.Secret)
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("peer returned %s", resp.Status)
+	}
+	var state stateResponse
+	if err := json.NewDecoder(resp.Body).Decode(&state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// interestedIn reports whether a peer has subscribers for a topic
+func (p *peer) interestedIn(topic string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.topics[topic] {
+		return true
+	}
+	for _, pattern := range p.patterns {
+		if pubsub.MatchTopic(pattern, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// Peers describes each peer's known subscriptions for /stats
+func (n *Node) Peers() map[string]interface{} {
+	peers := make(map[string]interface{}, len(n.peers))
+	for _, p := range n.peers {
+		p.mu.RLock()
+		topics := make([]string, 0, len(p.topics))
+		for name := range p.topics {
+			topics = append(topics, name)
+		}
+		peers[p.url] = map[string]interface{}{
+			"topics":   topics,
+			"patterns": p.patterns,
+			"queued":   len(p.queue),
+		}
+		p.mu.RUnlock()
+	}
+	return peers
+}
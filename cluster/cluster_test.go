@@ -0,0 +1,204 @@
+package cluster
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"pub-sub-system/models"
+	"pub-sub-system/pubsub"
+)
+
+const testSecret = "test-secret"
+
+// testNode is a cluster node served on a localhost port
+type testNode struct {
+	ps     *pubsub.PubSubSystem
+	node   *Node
+	server *httptest.Server
+}
+
+// startCluster runs count nodes on localhost ports, each peered with all
+// the others
+func startCluster(t *testing.T, count int) []*testNode {
+	t.Helper()
+
+	nodes := make([]*testNode, count)
+	handlers := make([]http.Handler, count)
+	var mu sync.RWMutex
+	for i := range nodes {
+		i := i
+		nodes[i] = &testNode{ps: pubsub.NewPubSubSystem()}
+		nodes[i].server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.RLock()
+			handler := handlers[i]
+			mu.RUnlock()
+			if handler == nil {
+				http.Error(w, "Starting", http.StatusServiceUnavailable)
+				return
+			}
+			handler.ServeHTTP(w, r)
+		}))
+	}
+
+	for i, n := range nodes {
+		peers := make([]string, 0, count-1)
+		for j, other := range nodes {
+			if j != i {
+				peers = append(peers, other.server.URL)
+			}
+		}
+		node, err := New(n.ps, Options{
+			NodeID:        n.server.URL,
+			Peers:         peers,
+			Secret:        testSecret,
+			RequireSecret: true,
+			SyncInterval:  20 * time.Millisecond,
+		})
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		n.node = node
+
+		mu.Lock()
+		handlers[i] = node.Handler()
+		mu.Unlock()
+	}
+
+	t.Cleanup(func() {
+		for _, n := range nodes {
+			n.server.Close()
+			n.node.Close()
+			n.ps.Close()
+		}
+	})
+	return nodes
+}
+
+// eventually polls cond until it holds or a deadline passes
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// recordingConn collects the events delivered to a subscriber
+type recordingConn struct {
+	events chan *models.Message
+}
+
+func (c *recordingConn) WriteJSON(v interface{}) error {
+	if msg, ok := v.(*models.ServerMessage); ok && msg.Type == "event" {
+		c.events <- msg.Message
+	}
+	return nil
+}
+
+func (c *recordingConn) Close() error { return nil }
+
+// subscribe attaches a subscriber for a topic on one node
+func subscribe(t *testing.T, n *testNode, topicName string) *recordingConn {
+	t.Helper()
+	topic, exists := n.ps.GetTopic(topicName)
+	if !exists {
+		t.Fatalf("topic %s missing on %s", topicName, n.server.URL)
+	}
+
+	conn := &recordingConn{events: make(chan *models.Message, 10)}
+	subManager := pubsub.NewSubscriberManager()
+	sub := subManager.NewSubscriber("client", topicName, conn)
+	if err := pubsub.NewTopicManager().AddSubscriber(topic, sub); err != nil {
+		t.Fatalf("AddSubscriber() error = %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	subManager.StartMessageProcessor(sub, ctx)
+	return conn
+}
+
+func TestClusterReplicatesTopicsAndForwardsPublishes(t *testing.T) {
+	nodes := startCluster(t, 3)
+	a, b, c := nodes[0], nodes[1], nodes[2]
+
+	// Topic creation on one node reaches every peer
+	if _, err := a.ps.NewTopic("orders"); err != nil {
+		t.Fatalf("NewTopic() error = %v", err)
+	}
+	for _, n := range []*testNode{b, c} {
+		n := n
+		eventually(t, "topic creation on "+n.server.URL, func() bool {
+			_, exists := n.ps.GetTopic("orders")
+			return exists
+		})
+	}
+
+	// A publish on one node reaches a subscriber on a peer once the peer's
+	// interest is known
+	conn := subscribe(t, b, "orders")
+	eventually(t, "subscription sync", func() bool {
+		return a.node.peers[0].interestedIn("orders") // peers[0] is b
+	})
+
+	msg := &models.Message{Payload: map[string]interface{}{"id": float64(1)}}
+	if err := pubsub.PrepareMessage(msg); err != nil {
+		t.Fatalf("PrepareMessage() error = %v", err)
+	}
+	if err := a.ps.Publish("orders", msg); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	select {
+	case got := <-conn.events:
+		if got.ID != msg.ID {
+			t.Errorf("forwarded message ID = %s, want %s", got.ID, msg.ID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the forwarded message")
+	}
+
+	// Topic deletion on one node reaches every peer
+	if err := c.ps.DeleteTopic("orders"); err != nil {
+		t.Fatalf("DeleteTopic() error = %v", err)
+	}
+	for _, n := range []*testNode{a, b} {
+		n := n
+		eventually(t, "topic deletion on "+n.server.URL, func() bool {
+			_, exists := n.ps.GetTopic("orders")
+			return !exists
+		})
+	}
+}
+
+func TestClusterRequiresSecret(t *testing.T) {
+	ps := pubsub.NewPubSubSystem()
+	defer ps.Close()
+
+	if _, err := New(ps, Options{Peers: []string{"http://127.0.0.1:1"}, RequireSecret: true}); err == nil {
+		t.Fatal("New() without a secret succeeded, want an error")
+	}
+	if ps.Replicator() != nil {
+		t.Error("replicator installed by a node that failed to start")
+	}
+}
+
+func TestClusterHandlerRejectsWrongSecret(t *testing.T) {
+	nodes := startCluster(t, 2)
+
+	req, _ := http.NewRequest(http.MethodDelete, nodes[0].server.URL+"/cluster/topics/orders", nil)
+	req.Header.Set(secretHeader, "wrong")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request error = %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,110 @@
+package cluster
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"pub-sub-system/models"
+)
+
+// Handler serves the node-to-node API under /cluster/. Requests must carry
+// the shared cluster secret when one is configured.
+func (n *Node) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if n.opts.Secret != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get(secretHeader)), []byte(n.opts.Secret)) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		path := strings.TrimPrefix(r.URL.EscapedPath(), "/cluster")
+		switch {
+		case path == "/state" && r.Method == http.MethodGet:
+			n.handleState(w)
+		case path == "/peers" && r.Method == http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{"node_id": n.opts.NodeID, "peers": n.Peers()})
+		case path == "/topics" && r.Method == http.MethodPost:
+			n.handleTopic(w, r)
+		case strings.HasPrefix(path, "/topics/"):
+			n.handleTopicPath(w, r, strings.TrimPrefix(path, "/topics/"))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+// handleState reports this node's topics and local subscriptions
+func (n *Node) handleState(w http.ResponseWriter) {
+	state := stateResponse{NodeID: n.opts.NodeID, Topics: make([]topicState, 0)}
+	for _, topic := range n.ps.ListTopics() {
+		topic.Mu.RLock()
+		state.Topics = append(state.Topics, topicState{
+			Name:      topic.Name,
+			Settings:  topic.Settings,
+			Retention: topic.Retention,
+		})
+		topic.Mu.RUnlock()
+	}
+	state.Interest.Topics, state.Interest.Patterns = n.ps.LocalInterest()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(state)
+}
+
+// handleTopic creates or updates a topic replicated from a peer
+func (n *Node) handleTopic(w http.ResponseWriter, r *http.Request) {
+	var topic topicState
+	if err := json.NewDecoder(r.Body).Decode(&topic); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if err := n.ps.ApplyRemoteTopic(topic.Name, topic.Settings, topic.Retention); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleTopicPath handles DELETE /cluster/topics/{name} and
+// POST /cluster/topics/{name}/messages
+func (n *Node) handleTopicPath(w http.ResponseWriter, r *http.Request, rest string) {
+	escaped, resource, _ := strings.Cut(rest, "/")
+	name, err := url.PathUnescape(escaped)
+	if err != nil || name == "" {
+		http.Error(w, "Invalid topic name", http.StatusBadRequest)
+		return
+	}
+
+	switch {
+	case resource == "" && r.Method == http.MethodDelete:
+		if err := n.ps.ApplyRemoteDelete(name); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case resource == "messages" && r.Method == http.MethodPost:
+		var msg models.Message
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil || msg.ID == "" {
+			http.Error(w, "Invalid message", http.StatusBadRequest)
+			return
+		}
+		if err := n.ps.ApplyRemotePublish(name, &msg); err != nil {
+			// A topic deleted meanwhile is not worth retrying
+			if e, ok := err.(*models.Error); ok && e.Code == "TOPIC_NOT_FOUND" {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
@@ -0,0 +1,72 @@
+// Command mqtt-client publishes and subscribes over the MQTT listener, for
+// trying it out against a local server:
+//
+//	go run ./cmd/mqtt-client sub 'orders/#'
+//	go run ./cmd/mqtt-client pub orders/eu '{"id": 1}'
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"pub-sub-system/mqtt"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:1883", "broker address")
+	clientID := flag.String("id", "", "client ID (assigned by the server when empty)")
+	password := flag.String("password", "", "API key or JWT when authentication is enabled")
+	qos := flag.Int("qos", 0, "QoS level, 0 or 1")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage:\n  mqtt-client [flags] pub <topic> <payload>\n  mqtt-client [flags] sub <filter>...\n\nFlags:\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) < 2 || (args[0] == "pub" && len(args) != 3) || (args[0] != "pub" && args[0] != "sub") {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	client, err := mqtt.Dial(*addr, mqtt.Options{ClientID: *clientID, Password: *password, KeepAlive: 30 * time.Second})
+	if err != nil {
+		log.Fatalf("Failed to connect: %v", err)
+	}
+
+	if args[0] == "pub" {
+		if err := client.Publish(args[1], []byte(args[2]), byte(*qos)); err != nil {
+			log.Fatalf("Failed to publish: %v", err)
+		}
+		client.Disconnect()
+		return
+	}
+
+	for _, filter := range args[1:] {
+		granted, err := client.Subscribe(filter, byte(*qos))
+		if err != nil {
+			log.Fatalf("Failed to subscribe: %v", err)
+		}
+		log.Printf("Subscribed to %s with QoS %d", filter, granted)
+	}
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	for {
+		select {
+		case msg, ok := <-client.Messages():
+			if !ok {
+				log.Fatal("Connection closed")
+			}
+			fmt.Printf("%s %s\n", msg.Topic, msg.Payload)
+		case <-quit:
+			client.Disconnect()
+			return
+		}
+	}
+}
@@ -0,0 +1,31 @@
+// Package env reads the server's configuration from environment variables
+package env
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Int gets an environment variable as an integer with a default value
+func Int(key string, defaultValue int) int {
+	if val := os.Getenv(key); val != "" {
+		if intVal, err := strconv.Atoi(val); err == nil {
+			return intVal
+		}
+	}
+	return defaultValue
+}
+
+// Interval gets an environment variable as a duration in milliseconds.
+// Values that are not positive fall back to the default, since tickers and
+// timeouts cannot run at them.
+func Interval(key string, defaultMs int) time.Duration {
+	ms := Int(key, defaultMs)
+	if ms <= 0 {
+		log.Printf("Ignoring %s=%d: interval must be positive, using %dms", key, ms, defaultMs)
+		ms = defaultMs
+	}
+	return time.Duration(ms) * time.Millisecond
+}
@@ -0,0 +1,336 @@
+// Package filter implements subscription filters: boolean expressions over
+// a message's headers and top-level payload fields, such as
+//
+//	headers.region == 'eu' and (payload.amount >= 100 or payload.priority in ('high', 'urgent'))
+//
+// Fields are written headers.NAME or payload.NAME. Literals are quoted
+// strings, numbers, true, false and null. The operators are ==, !=, <, <=,
+// >, >=, in (...), and/&&, or/|| and not/!. A comparison with a missing field
+// is false.
+package filter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"pub-sub-system/models"
+)
+
+// maxLength bounds the size of a filter expression
+const maxLength = 4096
+
+// Filter is a parsed filter expression. It is safe for concurrent use.
+type Filter struct {
+	source string
+	root   node
+}
+
+// Parse compiles a filter expression
+func Parse(source string) (*Filter, error) {
+	if len(source) > maxLength {
+		return nil, fmt.Errorf("filter is longer than %d characters", maxLength)
+	}
+
+	tokens, err := lex(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %s at position %d", tok, tok.pos)
+	}
+	return &Filter{source: source, root: root}, nil
+}
+
+// Match reports whether a message satisfies the filter
+func (f *Filter) Match(msg *models.Message) bool {
+	return f.root.eval(msg)
+}
+
+// String returns the expression the filter was parsed from
+func (f *Filter) String() string {
+	return f.source
+}
+
+// node is an element of a parsed expression
+type node interface {
+	eval(msg *models.Message) bool
+}
+
+type andNode struct{ left, right node }
+type orNode struct{ left, right node }
+type notNode struct{ operand node }
+
+func (n andNode) eval(msg *models.Message) bool { return n.left.eval(msg) && n.right.eval(msg) }
+func (n orNode) eval(msg *models.Message) bool  { return n.left.eval(msg) || n.right.eval(msg) }
+func (n notNode) eval(msg *models.Message) bool { return !n.operand.eval(msg) }
+
+// field names a header or a top-level payload field
+type field struct {
+	header bool
+	name   string
+}
+
+// lookup returns the field's value in a message and whether it is present
+func (f field) lookup(msg *models.Message) (interface{}, bool) {
+	if f.header {
+		value, ok := msg.Headers[f.name]
+		return value, ok
+	}
+	payload, ok := msg.Payload.(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	value, ok := payload[f.name]
+	return value, ok
+}
+
+// compareNode compares a field with a literal
+type compareNode struct {
+	field field
+	op    string
+	value interface{}
+}
+
+func (n compareNode) eval(msg *models.Message) bool {
+	actual, ok := n.field.lookup(msg)
+	if !ok {
+		return false
+	}
+	return compare(actual, n.op, n.value, n.field.header)
+}
+
+// inNode tests a field against a list of literals
+type inNode struct {
+	field  field
+	values []interface{}
+}
+
+func (n inNode) eval(msg *models.Message) bool {
+	actual, ok := n.field.lookup(msg)
+	if !ok {
+		return false
+	}
+	for _, value := range n.values {
+		if compare(actual, "==", value, n.field.header) {
+			return true
+		}
+	}
+	return false
+}
+
+// compare applies an operator to a field value and a literal. Header values
+// are strings but compare numerically against number literals.
+func compare(actual interface{}, op string, literal interface{}, header bool) bool {
+	if number, isNumber := literal.(float64); isNumber && header {
+		parsed, err := strconv.ParseFloat(actual.(string), 64)
+		if err != nil {
+			return op == "!="
+		}
+		actual = parsed
+		literal = number
+	}
+
+	switch a := actual.(type) {
+	case float64:
+		if b, ok := literal.(float64); ok {
+			return ordered(op, a < b, a == b)
+		}
+	case string:
+		if b, ok := literal.(string); ok {
+			return ordered(op, a < b, a == b)
+		}
+	case bool:
+		if b, ok := literal.(bool); ok && (op == "==" || op == "!=") {
+			return (a == b) == (op == "==")
+		}
+		return op == "!="
+	case nil:
+		if op == "==" || op == "!=" {
+			return (literal == nil) == (op == "==")
+		}
+		return false
+	}
+	// Values of different types are never equal or ordered
+	return op == "!="
+}
+
+// ordered evaluates op given whether a < b and whether a == b
+func ordered(op string, less, equal bool) bool {
+	switch op {
+	case "==":
+		return equal
+	case "!=":
+		return !equal
+	case "<":
+		return less
+	case "<=":
+		return less || equal
+	case ">":
+		return !less && !equal
+	case ">=":
+		return !less
+	}
+	return false
+}
+
+// parser is a recursive descent parser over the token stream
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// parseOr parses: and { ("or" | "||") and }
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().is(tokOp, "or", "||") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+	return left, nil
+}
+
+// parseAnd parses: unary { ("and" | "&&") unary }
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().is(tokOp, "and", "&&") {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+	return left, nil
+}
+
+// parseUnary parses: ("not" | "!") unary | "(" or ")" | comparison
+func (p *parser) parseUnary() (node, error) {
+	tok := p.peek()
+	switch {
+	case tok.is(tokOp, "not", "!"):
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{operand}, nil
+
+	case tok.is(tokPunct, "("):
+		p.next()
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); !closing.is(tokPunct, ")") {
+			return nil, fmt.Errorf("expected ) at position %d, found %s", closing.pos, closing)
+		}
+		return inner, nil
+	}
+	return p.parseComparison()
+}
+
+// parseComparison parses: field op literal | field "in" "(" literal { "," literal } ")"
+func (p *parser) parseComparison() (node, error) {
+	tok := p.next()
+	if tok.kind != tokIdent {
+		return nil, fmt.Errorf("expected a field at position %d, found %s", tok.pos, tok)
+	}
+	f, err := parseField(tok)
+	if err != nil {
+		return nil, err
+	}
+
+	op := p.next()
+	switch {
+	case op.is(tokOp, "==", "!=", "<", "<=", ">", ">="):
+		value, err := p.parseLiteral()
+		if err != nil {
+			return nil, err
+		}
+		return compareNode{field: f, op: op.text, value: value}, nil
+
+	case op.is(tokOp, "in"):
+		if open := p.next(); !open.is(tokPunct, "(") {
+			return nil, fmt.Errorf("expected ( after in at position %d, found %s", open.pos, open)
+		}
+		values := make([]interface{}, 0)
+		for {
+			value, err := p.parseLiteral()
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+
+			sep := p.next()
+			if sep.is(tokPunct, ")") {
+				return inNode{field: f, values: values}, nil
+			}
+			if !sep.is(tokPunct, ",") {
+				return nil, fmt.Errorf("expected , or ) at position %d, found %s", sep.pos, sep)
+			}
+		}
+	}
+	return nil, fmt.Errorf("expected a comparison operator at position %d, found %s", op.pos, op)
+}
+
+// parseLiteral parses a string, number, true, false or null
+func (p *parser) parseLiteral() (interface{}, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokString:
+		return tok.text, nil
+	case tokNumber:
+		value, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at position %d", tok.text, tok.pos)
+		}
+		return value, nil
+	case tokIdent:
+		switch tok.text {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+	}
+	return nil, fmt.Errorf("expected a value at position %d, found %s", tok.pos, tok)
+}
+
+// parseField resolves headers.NAME or payload.NAME
+func parseField(tok token) (field, error) {
+	prefix, name, found := strings.Cut(tok.text, ".")
+	if !found || name == "" || (prefix != "headers" && prefix != "payload") {
+		return field{}, fmt.Errorf("unknown field %q at position %d: fields are headers.NAME or payload.NAME", tok.text, tok.pos)
+	}
+	return field{header: prefix == "headers", name: name}, nil
+}
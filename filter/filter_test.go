@@ -0,0 +1,193 @@
+package filter
+
+import (
+	"strings"
+	"testing"
+
+	"pub-sub-system/models"
+)
+
+func TestMatch(t *testing.T) {
+	msg := &models.Message{
+		Headers: map[string]string{"region": "eu", "retries": "3", "tier": "gold"},
+		Payload: map[string]interface{}{
+			"amount":   150.0,
+			"priority": "high",
+			"express":  true,
+			"note":     nil,
+			"count":    "10",
+		},
+	}
+
+	tests := []struct {
+		filter string
+		want   bool
+	}{
+		// Comparisons
+		{"headers.region == 'eu'", true},
+		{`headers.region == "us"`, false},
+		{"headers.region != 'us'", true},
+		{"payload.amount >= 100", true},
+		{"payload.amount > 150", false},
+		{"payload.amount <= 150", true},
+		{"payload.amount < 150.5", true},
+		{"payload.amount == 1.5e2", true},
+		{"payload.express == true", true},
+		{"payload.express != false", true},
+		{"payload.note == null", true},
+		{"payload.priority != null", true},
+
+		// Precedence: not binds tighter than and, and tighter than or
+		{"headers.region == 'us' and payload.amount > 1 or payload.express == true", true},
+		{"headers.region == 'us' and (payload.amount > 1 or payload.express == true)", false},
+		{"payload.express == true or headers.region == 'us' and payload.amount > 1000", true},
+		{"(payload.express == true or headers.region == 'us') and payload.amount > 1000", false},
+		{"not headers.region == 'us' and payload.amount > 1000", false},
+		{"not (headers.region == 'us' and payload.amount > 1000)", true},
+		{"! headers.region == 'eu' || payload.amount > 100 && headers.tier == 'gold'", true},
+		{"not not headers.region == 'eu'", true},
+
+		// in lists
+		{"payload.priority in ('high', 'urgent')", true},
+		{"payload.priority in ('low')", false},
+		{"payload.amount in (100, 150)", true},
+		{"headers.retries in (1, 2, 3)", true},
+		{"headers.region in ('us', 'apac')", false},
+
+		// Header values compare numerically against numbers, payload
+		// strings do not
+		{"headers.retries > 2", true},
+		{"headers.retries == 3", true},
+		{"headers.retries == '3'", true},
+		{"headers.region > 2", false},
+		{"headers.region != 2", true},
+		{"payload.count == 10", false},
+		{"payload.count != 10", true},
+		{"payload.count == '10'", true},
+		{"payload.amount == '150'", false},
+
+		// Strings are ordered lexically
+		{"headers.tier < 'silver'", true},
+		{"headers.retries < '10'", false},
+
+		// A comparison with a missing field is false, so its negation is true
+		{"headers.missing == 'x'", false},
+		{"headers.missing != 'x'", false},
+		{"payload.missing in ('x')", false},
+		{"not payload.missing == 'x'", true},
+
+		// Header and payload fields with the same name are distinct
+		{"headers.priority == 'high'", false},
+		{"payload.region == 'eu'", false},
+	}
+
+	for _, tt := range tests {
+		f, err := Parse(tt.filter)
+		if err != nil {
+			t.Errorf("Parse(%q) error = %v", tt.filter, err)
+			continue
+		}
+		if got := f.Match(msg); got != tt.want {
+			t.Errorf("Parse(%q).Match() = %v, want %v", tt.filter, got, tt.want)
+		}
+	}
+}
+
+func TestMatchNonObjectPayload(t *testing.T) {
+	f, err := Parse("payload.amount > 1 or headers.kind == 'text'")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	msg := &models.Message{Payload: "plain text", Headers: map[string]string{"kind": "text"}}
+	if !f.Match(msg) {
+		t.Error("Match() = false, want true from the header")
+	}
+	msg.Headers = nil
+	if f.Match(msg) {
+		t.Error("Match() = true for a message with neither field")
+	}
+}
+
+func TestParseRejectsMalformedFilters(t *testing.T) {
+	tests := []struct {
+		filter string
+		err    string
+	}{
+		{"", "expected a field"},
+		{"headers.region", "expected a comparison operator"},
+		{"headers.region ==", "expected a value"},
+		{"headers.region = 'eu'", "unexpected character"},
+		{"region == 'eu'", "unknown field"},
+		{"body.region == 'eu'", "unknown field"},
+		{"headers. == 'eu'", "unknown field"},
+		{"headers.region == 'eu", "unterminated string"},
+		{"headers.region == eu", "expected a value"},
+		{"headers.region == 'eu' and", "expected a field"},
+		{"headers.region == 'eu' or or headers.x == 1", "expected a field"},
+		{"(headers.region == 'eu'", "expected )"},
+		{"headers.region == 'eu')", "unexpected"},
+		{"headers.region in 'eu'", "expected ( after in"},
+		{"headers.region in ()", "expected a value"},
+		{"headers.region in ('eu' 'us')", "expected , or )"},
+		{"headers.region in ('eu',", "expected a value"},
+		{"payload.amount > 1.2.3", "invalid number"},
+		{"payload.amount > 5 # comment", "unexpected character"},
+		{"headers.a == 1 headers.b == 2", "unexpected"},
+		{strings.Repeat("x", maxLength+1), "longer than"},
+	}
+
+	for _, tt := range tests {
+		_, err := Parse(tt.filter)
+		if err == nil {
+			t.Errorf("Parse(%q) succeeded, want an error containing %q", tt.filter, tt.err)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("Parse(%q) error = %q, want it to contain %q", tt.filter, err, tt.err)
+		}
+	}
+}
+
+func TestLex(t *testing.T) {
+	tokens, err := lex(`headers.x-id>=-1.5e3&&!(payload.s in ('a\'b', "c"))`)
+	if err != nil {
+		t.Fatalf("lex() error = %v", err)
+	}
+
+	want := []token{
+		{tokIdent, "headers.x-id", 0},
+		{tokOp, ">=", 12},
+		{tokNumber, "-1.5e3", 14},
+		{tokOp, "&&", 20},
+		{tokOp, "!", 22},
+		{tokPunct, "(", 23},
+		{tokIdent, "payload.s", 24},
+		{tokOp, "in", 34},
+		{tokPunct, "(", 37},
+		{tokString, "a'b", 38},
+		{tokPunct, ",", 44},
+		{tokString, "c", 46},
+		{tokPunct, ")", 49},
+		{tokPunct, ")", 50},
+		{tokEOF, "", 51},
+	}
+	if len(tokens) != len(want) {
+		t.Fatalf("lex() = %v, want %v", tokens, want)
+	}
+	for i := range want {
+		if tokens[i] != want[i] {
+			t.Errorf("token %d = %+v, want %+v", i, tokens[i], want[i])
+		}
+	}
+}
+
+func TestFilterString(t *testing.T) {
+	source := "headers.region == 'eu'"
+	f, err := Parse(source)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if f.String() != source {
+		t.Errorf("String() = %q, want %q", f.String(), source)
+	}
+}
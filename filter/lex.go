@@ -0,0 +1,144 @@
+package filter
+
+import (
+	"fmt"
+	"strings"
+)
+
+// tokenKind classifies a token
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp
+	tokPunct
+)
+
+// token is a lexical element of a filter expression
+type token struct {
+	kind tokenKind
+	text string
+	pos  int // Byte offset in the expression
+}
+
+// is reports whether the token has the given kind and one of the texts
+func (t token) is(kind tokenKind, texts ...string) bool {
+	if t.kind != kind {
+		return false
+	}
+	for _, text := range texts {
+		if t.text == text {
+			return true
+		}
+	}
+	return false
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of filter"
+	case tokString:
+		return fmt.Sprintf("string %q", t.text)
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// keywords are identifiers that act as operators
+var keywords = map[string]bool{"and": true, "or": true, "not": true, "in": true}
+
+// operators are the symbolic operators, longest first
+var operators = []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!"}
+
+// lex splits a filter expression into tokens, ending with tokEOF
+func lex(source string) ([]token, error) {
+	tokens := make([]token, 0)
+	for i := 0; i < len(source); {
+		c := source[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+
+		case c == '(' || c == ')' || c == ',':
+			tokens = append(tokens, token{kind: tokPunct, text: string(c), pos: i})
+			i++
+
+		case c == '\'' || c == '"':
+			text, end, err := lexString(source, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{kind: tokString, text: text, pos: i})
+			i = end
+
+		case isDigit(c) || (c == '-' && i+1 < len(source) && isDigit(source[i+1])):
+			start := i
+			i++
+			for i < len(source) && (isDigit(source[i]) || strings.IndexByte(".eE+-", source[i]) >= 0) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: source[start:i], pos: start})
+
+		case isIdentStart(c):
+			start := i
+			for i < len(source) && isIdentPart(source[i]) {
+				i++
+			}
+			text := source[start:i]
+			kind := tokIdent
+			if keywords[text] {
+				kind = tokOp
+			}
+			tokens = append(tokens, token{kind: kind, text: text, pos: start})
+
+		default:
+			matched := false
+			for _, op := range operators {
+				if strings.HasPrefix(source[i:], op) {
+					tokens = append(tokens, token{kind: tokOp, text: op, pos: i})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character %q at position %d", c, i)
+			}
+		}
+	}
+	return append(tokens, token{kind: tokEOF, pos: len(source)}), nil
+}
+
+// lexString reads a quoted string starting at source[start], handling
+// backslash escapes, and returns its contents and the offset after it
+func lexString(source string, start int) (string, int, error) {
+	quote := source[start]
+	var b strings.Builder
+	for i := start + 1; i < len(source); i++ {
+		switch c := source[i]; {
+		case c == '\\' && i+1 < len(source):
+			i++
+			b.WriteByte(source[i])
+		case c == quote:
+			return b.String(), i + 1, nil
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated string at position %d", start)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || isDigit(c) || c == '.' || c == '-'
+}
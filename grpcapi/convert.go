@@ -0,0 +1,126 @@
+package grpcapi
+
+import (
+	"encoding/json"
+	"time"
+
+	"pub-sub-system/grpcapi/pb"
+	"pub-sub-system/metrics"
+	"pub-sub-system/models"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// statusCodes maps protocol error codes to gRPC status codes
+var statusCodes = map[string]codes.Code{
+	"BAD_REQUEST":         codes.InvalidArgument,
+	"TOPIC_NOT_FOUND":     codes.NotFound,
+	"FORBIDDEN":           codes.PermissionDenied,
+	"SCHEMA_VIOLATION":    codes.InvalidArgument,
+	"OFFSET_OUT_OF_RANGE": codes.OutOfRange,
+	"SLOW_CONSUMER":       codes.ResourceExhausted,
+	"INTERNAL":            codes.Internal,
+}
+
+// errorCode builds a protocol error
+func errorCode(code, message string) *models.Error {
+	return &models.Error{Code: code, Message: message}
+}
+
+// statusFor converts an error returned by the pub/sub core into a gRPC
+// status and counts it
+func statusFor(err error) error {
+	e, ok := err.(*models.Error)
+	if !ok {
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	metrics.Errors.WithLabelValues(e.Code).Inc()
+	return statusOf(e)
+}
+
+// statusOf converts a protocol error into a gRPC status
+func statusOf(e *models.Error) error {
+	code, known := statusCodes[e.Code]
+	if !known {
+		code = codes.Unknown
+	}
+	message := e.Message
+	if e.Path != "" {
+		message += " at " + e.Path
+	}
+	return status.Error(code, message)
+}
+
+// fromProto converts a published message. Server-assigned fields are left
+// for the server to set.
+func fromProto(m *pb.Message) *models.Message {
+	msg := &models.Message{
+		ID:      m.Id,
+		Headers: m.Headers,
+		TTLMs:   m.TtlMs,
+		DelayMs: m.DelayMs,
+	}
+	if m.Payload != nil {
+		msg.Payload = m.Payload.AsInterface()
+	}
+	if m.ExpiresAt != nil {
+		expiresAt := m.ExpiresAt.AsTime()
+		msg.ExpiresAt = &expiresAt
+	}
+	if m.DeliverAt != nil {
+		deliverAt := m.DeliverAt.AsTime()
+		msg.DeliverAt = &deliverAt
+	}
+	return msg
+}
+
+// toProto converts a stored message for delivery
+func toProto(msg *models.Message) (*pb.Message, error) {
+	payload, err := payloadValue(msg.Payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.Message{
+		Id:          msg.ID,
+		Payload:     payload,
+		Headers:     msg.Headers,
+		Offset:      msg.Offset,
+		PublishedAt: timestamppb.New(msg.PublishedAt),
+		TtlMs:       msg.TTLMs,
+		ExpiresAt:   timestampOrNil(msg.ExpiresAt),
+		DelayMs:     msg.DelayMs,
+		DeliverAt:   timestampOrNil(msg.DeliverAt),
+	}, nil
+}
+
+// payloadValue converts a payload to a protobuf Value. Payloads published
+// as JSON convert directly; others, such as dead-letter envelopes, are
+// converted through their JSON encoding.
+func payloadValue(payload interface{}) (*structpb.Value, error) {
+	if value, err := structpb.NewValue(payload); err == nil {
+		return value, nil
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	var generic interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return nil, err
+	}
+	return structpb.NewValue(generic)
+}
+
+// timestampOrNil converts an optional time
+func timestampOrNil(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+	return timestamppb.New(*t)
+}
@@ -0,0 +1,259 @@
+package grpcapi
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"pub-sub-system/auth"
+	"pub-sub-system/grpcapi/pb"
+	"pub-sub-system/pubsub"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/status"
+)
+
+// Server implements the PubSub gRPC service on top of the same pub/sub core
+// as the WebSocket and REST handlers, so clients of every API see each
+// other's messages
+type Server struct {
+	pb.UnimplementedPubSubServer
+
+	pubSubSystem  *pubsub.PubSubSystem
+	topicManager  *pubsub.TopicManager
+	subManager    *pubsub.SubscriberManager
+	authenticator auth.Authenticator // nil disables authentication
+	authorizer    *auth.Authorizer   // nil allows every request
+	grpcServer    *grpc.Server
+	stopStreams   chan struct{}
+}
+
+// New creates the gRPC server. Credentials are read from the authorization
+// or x-api-key metadata, like the matching HTTP headers.
+func New(pubSubSystem *pubsub.PubSubSystem, authenticator auth.Authenticator, authorizer *auth.Authorizer) *Server {
+	s := &Server{
+		pubSubSystem:  pubSubSystem,
+		topicManager:  pubsub.NewTopicManager(),
+		subManager:    pubsub.NewSubscriberManager(),
+		authenticator: authenticator,
+		authorizer:    authorizer,
+		stopStreams:   make(chan struct{}),
+	}
+
+	s.grpcServer = grpc.NewServer(
+		grpc.UnaryInterceptor(s.unaryAuth),
+		grpc.StreamInterceptor(s.streamAuth),
+		grpc.StatsHandler(&connectionStats{pubSubSystem: pubSubSystem}),
+	)
+	pb.RegisterPubSubServer(s.grpcServer, s)
+	return s
+}
+
+// Serve accepts connections on lis until Shutdown is called
+func (s *Server) Serve(lis net.Listener) error {
+	return s.grpcServer.Serve(lis)
+}
+
+// Shutdown ends open Subscribe streams and waits for unary calls to finish,
+// forcing the server closed when ctx ends first
+func (s *Server) Shutdown(ctx context.Context) {
+	close(s.stopStreams)
+
+	done := make(chan struct{})
+	go func() {
+		s.grpcServer.GracefulStop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.grpcServer.Stop()
+	}
+}
+
+// authenticate binds the principal identified by the call's metadata to its
+// context
+func (s *Server) authenticate(ctx context.Context) (context.Context, error) {
+	if s.authenticator == nil {
+		return ctx, nil
+	}
+
+	// The authenticator reads HTTP headers; gRPC metadata keys are already
+	// lower-case header names
+	md, _ := metadata.FromIncomingContext(ctx)
+	r := &http.Request{Header: make(http.Header), URL: &url.URL{}}
+	for key, values := range md {
+		for _, value := range values {
+			r.Header.Add(key, value)
+		}
+	}
+
+	principal, err := s.authenticator.Authenticate(r)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	return auth.WithPrincipal(ctx, principal), nil
+}
+
+// unaryAuth authenticates unary calls
+func (s *Server) unaryAuth(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// streamAuth authenticates streaming calls
+func (s *Server) streamAuth(srv interface{}, stream grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := s.authenticate(stream.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authenticatedStream{ServerStream: stream, ctx: ctx})
+}
+
+// authenticatedStream carries the authenticated context into a stream
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// Context returns the authenticated context
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
+
+// authorize checks that the caller may perform an action on a topic
+func (s *Server) authorize(ctx context.Context, action, topic string) error {
+	if s.authorizer.Allowed(auth.FromContext(ctx), action, topic) {
+		return nil
+	}
+	return statusFor(errorCode("FORBIDDEN", action+" not allowed on topic "+topic))
+}
+
+// CreateTopic creates a topic with the server's default settings
+func (s *Server) CreateTopic(ctx context.Context, req *pb.CreateTopicRequest) (*pb.CreateTopicResponse, error) {
+	if req.Name == "" {
+		return nil, status.Error(codes.InvalidArgument, "Topic name is required")
+	}
+	if err := s.authorize(ctx, auth.ActionCreate, req.Name); err != nil {
+		return nil, err
+	}
+
+	if _, err := s.pubSubSystem.NewTopic(req.Name); err != nil {
+		switch {
+		case err.Error() == "topic already exists":
+			return nil, status.Error(codes.AlreadyExists, err.Error())
+		case err.Error() == "maximum topics reached":
+			return nil, status.Error(codes.ResourceExhausted, err.Error())
+		case strings.HasPrefix(err.Error(), "invalid "):
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &pb.CreateTopicResponse{Name: req.Name}, nil
+}
+
+// DeleteTopic deletes a topic, ending its subscribers' streams
+func (s *Server) DeleteTopic(ctx context.Context, req *pb.DeleteTopicRequest) (*pb.DeleteTopicResponse, error) {
+	if req.Name == "" {
+		return nil, status.Error(codes.InvalidArgument, "Topic name is required")
+	}
+	if err := s.authorize(ctx, auth.ActionDelete, req.Name); err != nil {
+		return nil, err
+	}
+
+	if err := s.pubSubSystem.DeleteTopic(req.Name); err != nil {
+		if err.Error() == "topic not found" {
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &pb.DeleteTopicResponse{Name: req.Name}, nil
+}
+
+// ListTopics lists the topics the caller may read stats for, by name
+func (s *Server) ListTopics(ctx context.Context, _ *pb.ListTopicsRequest) (*pb.ListTopicsResponse, error) {
+	principal := auth.FromContext(ctx)
+	resp := &pb.ListTopicsResponse{}
+	for _, topic := range s.pubSubSystem.ListTopics() {
+		if !s.authorizer.Allowed(principal, auth.ActionReadStats, topic.Name) {
+			continue
+		}
+		topic.Mu.RLock()
+		subscribers := len(topic.Subscribers)
+		topic.Mu.RUnlock()
+		resp.Topics = append(resp.Topics, &pb.Topic{Name: topic.Name, Subscribers: int32(subscribers)})
+	}
+
+	sort.Slice(resp.Topics, func(i, j int) bool { return resp.Topics[i].Name < resp.Topics[j].Name })
+	return resp, nil
+}
+
+// Publish publishes one message to a topic
+func (s *Server) Publish(ctx context.Context, req *pb.PublishRequest) (*pb.PublishResponse, error) {
+	if req.Topic == "" || req.Message == nil {
+		return nil, statusFor(errorCode("BAD_REQUEST", "Topic and message are required"))
+	}
+	if err := s.authorize(ctx, auth.ActionPublish, req.Topic); err != nil {
+		return nil, err
+	}
+
+	msg := fromProto(req.Message)
+	if err := pubsub.PrepareMessage(msg); err != nil {
+		return nil, statusFor(err)
+	}
+	if err := s.pubSubSystem.Publish(req.Topic, msg); err != nil {
+		return nil, statusFor(err)
+	}
+	return &pb.PublishResponse{Id: msg.ID}, nil
+}
+
+// connectionStats lists gRPC connections in /stats
+type connectionStats struct {
+	pubSubSystem *pubsub.PubSubSystem
+}
+
+// trackedConn holds the function releasing a connection from the registry
+type trackedConn struct {
+	release func()
+}
+
+type trackedConnKey struct{}
+
+// TagConn implements stats.Handler
+func (c *connectionStats) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return context.WithValue(ctx, trackedConnKey{}, &trackedConn{})
+}
+
+// HandleConn implements stats.Handler
+func (c *connectionStats) HandleConn(ctx context.Context, event stats.ConnStats) {
+	tracked, ok := ctx.Value(trackedConnKey{}).(*trackedConn)
+	if !ok {
+		return
+	}
+	switch event.(type) {
+	case *stats.ConnBegin:
+		tracked.release = c.pubSubSystem.TrackConnection("grpc", "protobuf")
+	case *stats.ConnEnd:
+		if tracked.release != nil {
+			tracked.release()
+		}
+	}
+}
+
+// TagRPC implements stats.Handler
+func (c *connectionStats) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+// HandleRPC implements stats.Handler
+func (c *connectionStats) HandleRPC(context.Context, stats.RPCStats) {}
@@ -0,0 +1,156 @@
+package grpcapi
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"pub-sub-system/auth"
+	"pub-sub-system/filter"
+	"pub-sub-system/grpcapi/pb"
+	"pub-sub-system/models"
+	"pub-sub-system/pubsub"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// streamConn adapts a Subscribe stream to models.WebSocketConn so gRPC
+// clients can be attached to topics like WebSocket subscribers
+type streamConn struct {
+	stream pb.PubSub_SubscribeServer
+	mu     sync.Mutex
+	done   chan struct{}
+	once   sync.Once
+	err    error // Status the stream ends with, if any
+}
+
+// WriteJSON sends events on the stream. An error frame, such as
+// SLOW_CONSUMER, sets the status the stream ends with when it is closed;
+// info frames have no equivalent and are skipped.
+func (c *streamConn) WriteJSON(v interface{}) error {
+	msg, ok := v.(*models.ServerMessage)
+	if !ok {
+		return nil
+	}
+
+	switch msg.Type {
+	case "event":
+		message, err := toProto(msg.Message)
+		if err != nil {
+			return err
+		}
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		select {
+		case <-c.done:
+			return fmt.Errorf("stream closed")
+		default:
+		}
+		return c.stream.Send(&pb.Event{Topic: msg.Topic, Message: message})
+
+	case "error":
+		if msg.Error != nil {
+			c.mu.Lock()
+			c.err = statusOf(msg.Error)
+			c.mu.Unlock()
+		}
+	}
+	return nil
+}
+
+// Close ends the stream; Subscribe returns once it is closed
+func (c *streamConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.once.Do(func() { close(c.done) })
+	return nil
+}
+
+// Subscribe attaches the stream to a topic or pattern like a WebSocket
+// subscribe request and sends events until the client cancels, the topic is
+// deleted or the subscriber is disconnected as a slow consumer
+func (s *Server) Subscribe(req *pb.SubscribeRequest, stream pb.PubSub_SubscribeServer) error {
+	if req.Topic == "" {
+		return statusFor(errorCode("BAD_REQUEST", "Topic is required"))
+	}
+	if req.LastN < 0 {
+		return statusFor(errorCode("BAD_REQUEST", "last_n must not be negative"))
+	}
+	if err := s.authorize(stream.Context(), auth.ActionSubscribe, req.Topic); err != nil {
+		return err
+	}
+
+	var subFilter models.MessageFilter
+	if req.Filter != "" {
+		parsed, err := filter.Parse(req.Filter)
+		if err != nil {
+			return statusFor(errorCode("BAD_REQUEST", "Invalid filter: "+err.Error()))
+		}
+		subFilter = parsed
+	}
+
+	clientID := req.ClientId
+	if clientID == "" {
+		clientID = "grpc-" + uuid.New().String()
+	}
+
+	conn := &streamConn{stream: stream, done: make(chan struct{})}
+	defer conn.Close()
+
+	if pubsub.IsPattern(req.Topic) {
+		if err := pubsub.ValidatePattern(req.Topic); err != nil {
+			return statusFor(errorCode("BAD_REQUEST", "Invalid topic pattern: "+err.Error()))
+		}
+		sub := s.subManager.NewSubscriber(pubsub.SubscriberKey(clientID, req.Topic), req.Topic, conn)
+		sub.Group = req.Group
+		sub.Filter = subFilter
+		history := pubsub.HistoryRequest{LastN: int(req.LastN)}
+		if err := s.pubSubSystem.AddWildcardSubscriberWithHistory(sub, history); err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+		defer s.pubSubSystem.RemoveWildcardSubscribersByConn(conn)
+		return s.stream(sub, conn, stream.Context())
+	}
+
+	topic, exists := s.pubSubSystem.GetTopic(req.Topic)
+	if !exists {
+		return statusFor(errorCode("TOPIC_NOT_FOUND", "Topic does not exist"))
+	}
+	sub := s.subManager.NewSubscriber(clientID, req.Topic, conn)
+	sub.Group = req.Group
+	sub.Filter = subFilter
+	history := pubsub.HistoryRequest{LastN: int(req.LastN)}
+	if err := s.topicManager.AddSubscriberWithHistory(topic, sub, history); err != nil {
+		if _, ok := err.(*models.Error); ok {
+			return statusFor(err)
+		}
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	defer s.topicManager.RemoveSubscribersByConn(topic, conn)
+
+	return s.stream(sub, conn, stream.Context())
+}
+
+// stream runs a subscriber's processor, which replays its backlog before
+// live events, until the stream ends
+func (s *Server) stream(sub *models.Subscriber, conn *streamConn, ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	s.subManager.StartMessageProcessor(sub, ctx)
+
+	select {
+	case <-conn.done:
+		conn.mu.Lock()
+		defer conn.mu.Unlock()
+		return conn.err
+	case <-ctx.Done():
+		return nil
+	case <-s.stopStreams:
+		return status.Error(codes.Unavailable, "server is shutting down")
+	}
+}
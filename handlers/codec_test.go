@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"pub-sub-system/models"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestCodecNegotiation(t *testing.T) {
+	_, srv := startTestServer(t)
+
+	tests := []struct {
+		name   string
+		offer  []string
+		chosen string // Negotiated subprotocol, empty for none
+	}{
+		{"no subprotocol", nil, ""},
+		{"unknown subprotocol", []string{"xml"}, ""},
+		{"json", []string{CodecJSON}, CodecJSON},
+		{"cbor", []string{"xml", CodecCBOR}, CodecCBOR},
+		// The server's preference wins over the client's order
+		{"server preference", []string{CodecJSON, CodecCBOR, CodecMsgpack}, CodecMsgpack},
+		{"stomp", []string{StompSubprotocol}, StompSubprotocol},
+	}
+	for _, tt := range tests {
+		ws := dialWebSocket(t, srv, tt.offer...)
+		if got := ws.Subprotocol(); got != tt.chosen {
+			t.Errorf("%s: negotiated %q, want %q", tt.name, got, tt.chosen)
+		}
+		ws.Close()
+	}
+
+	for subprotocol, want := range map[string]string{"": CodecJSON, "xml": CodecJSON, StompSubprotocol: CodecJSON, CodecMsgpack: CodecMsgpack, CodecCBOR: CodecCBOR} {
+		if got := codecFor(subprotocol).name; got != want {
+			t.Errorf("codecFor(%q) = %s, want %s", subprotocol, got, want)
+		}
+	}
+}
+
+// codecRequest sends a client message in a codec's frame type and reads
+// server messages until one of the given type or an error arrives
+func codecRequest(t *testing.T, ws *websocket.Conn, c *codec, msg models.ClientMessage, kind string) models.ServerMessage {
+	t.Helper()
+	data, err := c.marshal(msg)
+	if err != nil {
+		t.Fatalf("%s marshal error = %v", c.name, err)
+	}
+	if err := ws.WriteMessage(c.frameType, data); err != nil {
+		t.Fatalf("WebSocket write error = %v", err)
+	}
+	return readCodecMessage(t, ws, c, kind)
+}
+
+func readCodecMessage(t *testing.T, ws *websocket.Conn, c *codec, kind string) models.ServerMessage {
+	t.Helper()
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		frameType, data, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatalf("WebSocket read waiting for %s: %v", kind, err)
+		}
+		if frameType != c.frameType {
+			t.Fatalf("%s connection got frame type %d, want %d", c.name, frameType, c.frameType)
+		}
+		var msg models.ServerMessage
+		if err := c.unmarshal(frameType, data, &msg); err != nil {
+			t.Fatalf("%s unmarshal error = %v", c.name, err)
+		}
+		if msg.Type == kind || msg.Type == "error" {
+			return msg
+		}
+	}
+}
+
+func TestBinaryCodecsRoundTrip(t *testing.T) {
+	for _, name := range []string{CodecMsgpack, CodecCBOR} {
+		t.Run(name, func(t *testing.T) {
+			ps, srv := startTestServer(t)
+			if _, err := ps.NewTopic("orders"); err != nil {
+				t.Fatalf("NewTopic() error = %v", err)
+			}
+			c := codecFor(name)
+			ws := dialWebSocket(t, srv, name)
+			observer := dialWebSocket(t, srv)
+
+			// Binary integers reach filters as JSON numbers
+			reply := codecRequest(t, ws, c, models.ClientMessage{Type: "subscribe", Topic: "orders", ClientID: "binary", Filter: "payload.amount > 3", RequestID: "sub"}, "ack")
+			if reply.Type != "ack" || reply.RequestID != "sub" {
+				t.Fatalf("subscribe replied %s %+v", reply.Type, reply.Error)
+			}
+			if reply := request(t, observer, models.ClientMessage{Type: "subscribe", Topic: "orders", ClientID: "json", RequestID: "sub"}); reply.Type != "ack" {
+				t.Fatalf("JSON subscribe replied %s %+v", reply.Type, reply.Error)
+			}
+
+			for _, amount := range []int{1, 5} {
+				payload := map[string]interface{}{"amount": amount, "tags": []string{"a", "b"}}
+				reply := codecRequest(t, ws, c, models.ClientMessage{Type: "publish", Topic: "orders", Message: &models.Message{Payload: payload}, RequestID: "pub"}, "ack")
+				if reply.Type != "ack" {
+					t.Fatalf("publish replied %s %+v", reply.Type, reply.Error)
+				}
+			}
+
+			event := readCodecMessage(t, ws, c, "event")
+			if event.Type != "event" {
+				t.Fatalf("binary subscriber got %s %+v, want an event", event.Type, event.Error)
+			}
+			payload, ok := event.Message.Payload.(map[string]interface{})
+			if !ok || payload["amount"] != float64(5) {
+				t.Errorf("binary subscriber got payload %#v, want amount 5", event.Message.Payload)
+			}
+
+			// A JSON subscriber sees the same payload a JSON publisher would send
+			for _, want := range []float64{1, 5} {
+				event := readServerMessage(t, observer, "event")
+				payload, ok := event.Message.Payload.(map[string]interface{})
+				if !ok || payload["amount"] != want {
+					t.Fatalf("JSON subscriber got %#v, want amount %v", event.Message, want)
+				}
+				if tags, ok := payload["tags"].([]interface{}); !ok || len(tags) != 2 || tags[0] != "a" {
+					t.Errorf("JSON subscriber got tags %#v, want [a b]", payload["tags"])
+				}
+			}
+
+			// Text frames are JSON whatever the negotiated codec
+			if err := ws.WriteJSON(models.ClientMessage{Type: "ping", RequestID: "text"}); err != nil {
+				t.Fatalf("WebSocket write error = %v", err)
+			}
+			if reply := readCodecMessage(t, ws, c, "pong"); reply.Type != "pong" || reply.RequestID != "text" {
+				t.Errorf("text ping replied %s %+v", reply.Type, reply.Error)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"sync"
+
+	"pub-sub-system/auth"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsConn wraps a WebSocket connection so that the heartbeat, subscriber
+// processors and request handlers can write to it concurrently
+type wsConn struct {
+	*websocket.Conn
+	writeMu   sync.Mutex
+	principal *auth.Principal // nil when authentication is disabled
+	codec     *codec          // Negotiated through Sec-WebSocket-Protocol
+	stomp     *stompSession   // nil unless the client negotiated STOMP
+}
+
+// WriteJSON encodes a frame with the connection's codec, or as a STOMP frame,
+// and serialises writes to the underlying connection. The name is kept from
+// models.WebSocketConn; only JSON connections send JSON.
+func (c *wsConn) WriteJSON(v interface{}) error {
+	if c.stomp != nil {
+		return c.writeStomp(v)
+	}
+
+	data, err := c.codec.marshal(v)
+	if err != nil {
+		return err
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.Conn.WriteMessage(c.codec.frameType, data)
+}
+
+// ReadJSON reads the next frame and decodes it with the connection's codec
+func (c *wsConn) ReadJSON(v interface{}) error {
+	frameType, data, err := c.Conn.ReadMessage()
+	if err != nil {
+		return err
+	}
+	return c.codec.unmarshal(frameType, data, v)
+}
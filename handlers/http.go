@@ -1,22 +1,66 @@
 package handlers
 
 import (
+	"bytes"
 	"encoding/json"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
 
+	"pub-sub-system/auth"
+	"pub-sub-system/metrics"
+	"pub-sub-system/models"
 	"pub-sub-system/pubsub"
 )
 
+// maxPublishBodyBytes limits the size of a REST publish request
+const maxPublishBodyBytes = 1 << 20
+
 // HTTPHandler manages HTTP REST API endpoints
 type HTTPHandler struct {
 	pubSubSystem *pubsub.PubSubSystem
+	topicManager *pubsub.TopicManager
+	subManager   *pubsub.SubscriberManager
+	authorizer   *auth.Authorizer // nil allows every request
 }
 
 // NewHTTPHandler creates a new HTTP handler
-func NewHTTPHandler(pubSubSystem *pubsub.PubSubSystem) *HTTPHandler {
+func NewHTTPHandler(pubSubSystem *pubsub.PubSubSystem, authorizer *auth.Authorizer) *HTTPHandler {
 	return &HTTPHandler{
 		pubSubSystem: pubSubSystem,
+		topicManager: pubsub.NewTopicManager(),
+		subManager:   pubsub.NewSubscriberManager(),
+		authorizer:   authorizer,
+	}
+}
+
+// authorize checks that the request's principal may perform an action on a
+// topic, replying 403 if not
+func (h *HTTPHandler) authorize(w http.ResponseWriter, r *http.Request, action, topic string) bool {
+	if h.authorizer.Allowed(auth.FromContext(r.Context()), action, topic) {
+		return true
+	}
+	metrics.Errors.WithLabelValues("FORBIDDEN").Inc()
+	http.Error(w, "Forbidden: "+action+" not allowed on topic "+topic, http.StatusForbidden)
+	return false
+}
+
+// readableTopics filters a map keyed by topic name down to the topics the
+// request's principal may read stats for
+func (h *HTTPHandler) readableTopics(r *http.Request, topics map[string]interface{}) map[string]interface{} {
+	if h.authorizer == nil {
+		return topics
+	}
+
+	principal := auth.FromContext(r.Context())
+	readable := make(map[string]interface{}, len(topics))
+	for name, topic := range topics {
+		if h.authorizer.Allowed(principal, auth.ActionReadStats, name) {
+			readable[name] = topic
+		}
 	}
+	return readable
 }
 
 // HandleTopics handles all topic operations based on HTTP method
@@ -31,10 +75,14 @@ func (h *HTTPHandler) HandleTopics(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// handleCreateTopic handles topic creation
+// handleCreateTopic handles topic creation. The optional settings object
+// accepts the same fields as PATCH /topics/{name}; unset fields fall back to
+// the server defaults.
 func (h *HTTPHandler) handleCreateTopic(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		Name string `json:"name"`
+		Name      string                  `json:"name"`
+		Retention *models.RetentionPolicy `json:"retention,omitempty"`
+		Settings  *pubsub.TopicUpdate     `json:"settings,omitempty"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -46,11 +94,37 @@ func (h *HTTPHandler) handleCreateTopic(w http.ResponseWriter, r *http.Request)
 		http.Error(w, "Topic name is required", http.StatusBadRequest)
 		return
 	}
+	if !h.authorize(w, r, auth.ActionCreate, req.Name) {
+		return
+	}
+
+	// Unset retention fields fall back to the server defaults
+	retention := pubsub.DefaultRetention()
+	if req.Retention != nil {
+		if req.Retention.MaxMessages != 0 {
+			retention.MaxMessages = req.Retention.MaxMessages
+		}
+		if req.Retention.MaxAgeMs != 0 {
+			retention.MaxAgeMs = req.Retention.MaxAgeMs
+		}
+		if req.Retention.MaxBytes != 0 {
+			retention.MaxBytes = req.Retention.MaxBytes
+		}
+	}
+
+	update := pubsub.TopicUpdate{}
+	if req.Settings != nil {
+		update = *req.Settings
+		update.Retention = nil
+	}
+	settings, retention := pubsub.ApplyTopicUpdate(h.pubSubSystem.DefaultSettings(), retention, update)
 
-	topic, err := h.pubSubSystem.NewTopic(req.Name)
+	topic, err := h.pubSubSystem.NewTopicWithSettings(req.Name, settings, retention)
 	if err != nil {
 		if err.Error() == "topic already exists" {
 			http.Error(w, err.Error(), http.StatusConflict)
+		} else if strings.HasPrefix(err.Error(), "invalid ") {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 		} else {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
@@ -65,19 +139,138 @@ func (h *HTTPHandler) handleCreateTopic(w http.ResponseWriter, r *http.Request)
 	})
 }
 
-// HandleDeleteTopic handles topic deletion
-func (h *HTTPHandler) HandleDeleteTopic(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodDelete {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+// HandleTopic handles operations on a single topic under /topics/{name}
+func (h *HTTPHandler) HandleTopic(w http.ResponseWriter, r *http.Request) {
+	topicName, resource := splitTopicPath(r.URL.Path)
+	if topicName == "" {
+		http.Error(w, "Topic name is required", http.StatusBadRequest)
 		return
 	}
 
-	topicName := r.URL.Path[len("/topics/"):]
-	if topicName == "" {
-		http.Error(w, "Topic name is required", http.StatusBadRequest)
+	switch resource {
+	case "":
+		switch r.Method {
+		case http.MethodGet:
+			if h.authorize(w, r, auth.ActionReadStats, topicName) {
+				h.handleGetTopic(w, topicName)
+			}
+		case http.MethodPatch:
+			// Changing settings needs the same grant as creating the topic
+			if h.authorize(w, r, auth.ActionCreate, topicName) {
+				h.handleUpdateTopic(w, r, topicName)
+			}
+		case http.MethodDelete:
+			if h.authorize(w, r, auth.ActionDelete, topicName) {
+				h.handleDeleteTopic(w, topicName)
+			}
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	case "messages":
+		switch r.Method {
+		case http.MethodPost:
+			if h.authorize(w, r, auth.ActionPublish, topicName) {
+				h.handlePublish(w, r, topicName)
+			}
+		case http.MethodGet:
+			// Reading retained messages is a form of subscribing
+			if h.authorize(w, r, auth.ActionSubscribe, topicName) {
+				h.handleGetMessages(w, r, topicName)
+			}
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	case "scheduled":
+		switch r.Method {
+		case http.MethodGet:
+			if h.authorize(w, r, auth.ActionReadStats, topicName) {
+				h.handleListScheduled(w, topicName)
+			}
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	case "replay":
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if h.authorize(w, r, auth.ActionSubscribe, topicName) {
+			h.handleReplay(w, r, topicName)
+		}
+	case "events":
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if h.authorize(w, r, auth.ActionSubscribe, topicName) {
+			h.handleEvents(w, r, topicName)
+		}
+	default:
+		// DELETE /topics/{name}/scheduled/{id}
+		messageID, found := strings.CutPrefix(resource, "scheduled/")
+		if !found || messageID == "" || strings.Contains(messageID, "/") {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != http.MethodDelete {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		// Cancelling takes back a publish
+		if h.authorize(w, r, auth.ActionPublish, topicName) {
+			h.handleCancelScheduled(w, topicName, messageID)
+		}
+	}
+}
+
+// splitTopicPath splits /topics/{name}[/{resource}] into its parts
+func splitTopicPath(path string) (string, string) {
+	rest := strings.TrimPrefix(path, "/topics/")
+	if i := strings.Index(rest, "/"); i >= 0 {
+		return rest[:i], rest[i+1:]
+	}
+	return rest, ""
+}
+
+// handleGetTopic returns a topic's settings and current state
+func (h *HTTPHandler) handleGetTopic(w http.ResponseWriter, topicName string) {
+	topic, exists := h.pubSubSystem.GetTopic(topicName)
+	if !exists {
+		http.Error(w, "topic not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.pubSubSystem.TopicInfo(topic))
+}
+
+// handleUpdateTopic changes the settings of an existing topic. Only the
+// fields present in the request body are changed.
+func (h *HTTPHandler) handleUpdateTopic(w http.ResponseWriter, r *http.Request, topicName string) {
+	var update pubsub.TopicUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	topic, err := h.pubSubSystem.UpdateTopic(topicName, update)
+	if err != nil {
+		if err.Error() == "topic not found" {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else if strings.HasPrefix(err.Error(), "invalid ") {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
 		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.pubSubSystem.TopicInfo(topic))
+}
+
+// handleDeleteTopic handles topic deletion
+func (h *HTTPHandler) handleDeleteTopic(w http.ResponseWriter, topicName string) {
 	if err := h.pubSubSystem.DeleteTopic(topicName); err != nil {
 		if err.Error() == "topic not found" {
 			http.Error(w, err.Error(), http.StatusNotFound)
@@ -94,9 +287,198 @@ func (h *HTTPHandler) HandleDeleteTopic(w http.ResponseWriter, r *http.Request)
 	})
 }
 
+// handlePublish publishes a single message or a JSON array of messages to a
+// topic. Every message is validated before any of them is published.
+func (h *HTTPHandler) handlePublish(w http.ResponseWriter, r *http.Request, topicName string) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPublishBodyBytes))
+	if err != nil {
+		http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	var messages []*models.Message
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '[' {
+		err = json.Unmarshal(trimmed, &messages)
+	} else {
+		var msg models.Message
+		err = json.Unmarshal(trimmed, &msg)
+		messages = []*models.Message{&msg}
+	}
+	if err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if len(messages) == 0 {
+		http.Error(w, "At least one message is required", http.StatusBadRequest)
+		return
+	}
+
+	for _, msg := range messages {
+		if msg == nil {
+			http.Error(w, "Messages must be objects", http.StatusBadRequest)
+			return
+		}
+		if err := pubsub.PrepareMessage(msg); err != nil {
+			writeProtocolError(w, err)
+			return
+		}
+		if err := h.pubSubSystem.ValidatePayload(topicName, msg); err != nil {
+			writeProtocolError(w, err)
+			return
+		}
+	}
+
+	ids := make([]string, 0, len(messages))
+	for _, msg := range messages {
+		if err := h.pubSubSystem.Publish(topicName, msg); err != nil {
+			writeProtocolError(w, err)
+			return
+		}
+		ids = append(ids, msg.ID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "published",
+		"topic":  topicName,
+		"ids":    ids,
+	})
+}
+
+// defaultMessageLimit is how many messages GET .../messages returns when no
+// limit is given
+const defaultMessageLimit = 100
+
+// handleGetMessages returns retained messages of a topic, the last ones by
+// default or those starting at from_offset. It is mainly used to inspect
+// dead-letter topics.
+func (h *HTTPHandler) handleGetMessages(w http.ResponseWriter, r *http.Request, topicName string) {
+	topic, exists := h.pubSubSystem.GetTopic(topicName)
+	if !exists {
+		http.Error(w, "topic not found", http.StatusNotFound)
+		return
+	}
+
+	limit := defaultMessageLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	var messages []*models.Message
+	if v := r.URL.Query().Get("from_offset"); v != "" {
+		offset, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || offset < 0 {
+			http.Error(w, "from_offset must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+		if messages, err = h.topicManager.GetMessagesFrom(topic, offset); err != nil {
+			writeProtocolError(w, err)
+			return
+		}
+		if len(messages) > limit {
+			messages = messages[:limit]
+		}
+	} else {
+		messages = h.topicManager.GetLastMessages(topic, limit)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"topic":    topicName,
+		"messages": messages,
+	})
+}
+
+// handleReplay republishes dead letters held in a topic to their original
+// topics. The body may list the dead-letter message IDs to replay; without
+// one every retained dead letter is replayed. Publishing to each original
+// topic is authorized separately.
+func (h *HTTPHandler) handleReplay(w http.ResponseWriter, r *http.Request, topicName string) {
+	var req struct {
+		IDs []string `json:"ids"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+	}
+
+	principal := auth.FromContext(r.Context())
+	replayed, err := h.pubSubSystem.ReplayDeadLetters(topicName, req.IDs, func(original string) bool {
+		return h.authorizer.Allowed(principal, auth.ActionPublish, original)
+	})
+	if err != nil {
+		writeProtocolError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":   "replayed",
+		"topic":    topicName,
+		"replayed": replayed,
+	})
+}
+
+// handleListScheduled lists the messages waiting to be published to a topic
+func (h *HTTPHandler) handleListScheduled(w http.ResponseWriter, topicName string) {
+	scheduled, err := h.pubSubSystem.ScheduledMessages(topicName)
+	if err != nil {
+		writeProtocolError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"topic":     topicName,
+		"scheduled": scheduled,
+	})
+}
+
+// handleCancelScheduled cancels a scheduled message before it is published
+func (h *HTTPHandler) handleCancelScheduled(w http.ResponseWriter, topicName, messageID string) {
+	if !h.pubSubSystem.CancelScheduled(topicName, messageID) {
+		http.Error(w, "scheduled message not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status": "cancelled",
+		"topic":  topicName,
+		"id":     messageID,
+	})
+}
+
+// writeProtocolError maps a protocol error code to an HTTP status
+func writeProtocolError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if e, ok := err.(*models.Error); ok {
+		switch e.Code {
+		case "BAD_REQUEST":
+			status = http.StatusBadRequest
+		case "TOPIC_NOT_FOUND":
+			status = http.StatusNotFound
+		case "FORBIDDEN":
+			status = http.StatusForbidden
+		case "SCHEMA_VIOLATION":
+			status = http.StatusUnprocessableEntity
+		}
+		metrics.Errors.WithLabelValues(e.Code).Inc()
+	}
+	http.Error(w, err.Error(), status)
+}
+
 // handleListTopics handles topic listing
 func (h *HTTPHandler) handleListTopics(w http.ResponseWriter, r *http.Request) {
 	topics := h.pubSubSystem.GetTopics()
+	topics["topics"] = h.readableTopics(r, topics["topics"].(map[string]interface{}))
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(topics)
 }
@@ -121,10 +503,25 @@ func (h *HTTPHandler) HandleStats(w http.ResponseWriter, r *http.Request) {
 	}
 
 	stats := h.pubSubSystem.GetStats()
+	stats["topics"] = h.readableTopics(r, stats["topics"].(map[string]interface{}))
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(stats)
 }
 
+// HandleMetrics serves Prometheus metrics. Metrics cover every topic, so
+// reading them requires read-stats on all topics.
+func (h *HTTPHandler) HandleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.authorize(w, r, auth.ActionReadStats, ">") {
+		return
+	}
+
+	metrics.Handler().ServeHTTP(w, r)
+}
+
 // HandleRoot handles the root endpoint
 func (h *HTTPHandler) HandleRoot(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -147,8 +544,13 @@ func (h *HTTPHandler) HandleRoot(w http.ResponseWriter, r *http.Request) {
 			"root":      "/",
 			"websocket": "/ws",
 			"topics":    "/topics",
+			"topic":     "/topics/{name}",
+			"publish":   "/topics/{name}/messages",
+			"events":    "/topics/{name}/events",
 			"health":    "/health",
 			"stats":     "/stats",
+			"metrics":   "/metrics",
+			"schemas":   "/schemas",
 		},
 		"websocket_url": "wss://pub-sub-system-production.up.railway.app/ws",
 		"status":        "running",
@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"pub-sub-system/auth"
+	"pub-sub-system/schema"
+)
+
+// HandleSchemas serves the schema registry under /schemas. Reading it needs
+// the read-stats grant on every topic and changing it the create grant,
+// since schemas constrain what can be published anywhere.
+func (h *HTTPHandler) HandleSchemas(w http.ResponseWriter, r *http.Request) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/schemas"), "/")
+	parts := strings.Split(rest, "/")
+
+	action := auth.ActionReadStats
+	if r.Method != http.MethodGet {
+		action = auth.ActionCreate
+	}
+
+	switch {
+	case rest == "":
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if h.authorize(w, r, action, ">") {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{"subjects": h.pubSubSystem.Schemas.Subjects()})
+		}
+
+	case len(parts) == 1:
+		switch r.Method {
+		case http.MethodGet:
+			if h.authorize(w, r, action, ">") {
+				h.handleGetSubject(w, parts[0])
+			}
+		case http.MethodDelete:
+			if h.authorize(w, r, action, ">") {
+				h.handleDeleteSubject(w, parts[0])
+			}
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+
+	case len(parts) == 2 && parts[1] == "versions":
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if h.authorize(w, r, action, ">") {
+			h.handleRegisterSchema(w, r, parts[0])
+		}
+
+	case len(parts) == 3 && parts[1] == "versions":
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if h.authorize(w, r, action, ">") {
+			h.handleGetSchemaVersion(w, parts[0], parts[2])
+		}
+
+	case len(parts) == 2 && parts[1] == "compatibility":
+		if r.Method != http.MethodPut {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if h.authorize(w, r, action, ">") {
+			h.handleSetCompatibility(w, r, parts[0])
+		}
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// handleGetSubject returns a subject with all its versions
+func (h *HTTPHandler) handleGetSubject(w http.ResponseWriter, subject string) {
+	s, exists := h.pubSubSystem.Schemas.Subject(subject)
+	if !exists {
+		http.Error(w, "subject not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s)
+}
+
+// handleDeleteSubject deletes a subject no topic is bound to
+func (h *HTTPHandler) handleDeleteSubject(w http.ResponseWriter, subject string) {
+	if err := h.pubSubSystem.DeleteSchema(subject); err != nil {
+		if err.Error() == "subject not found" {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else if strings.HasPrefix(err.Error(), "schema is bound") {
+			http.Error(w, err.Error(), http.StatusConflict)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "deleted",
+		"subject": subject,
+	})
+}
+
+// handleRegisterSchema registers a new version of a subject. The new version
+// must satisfy the subject's compatibility mode against the latest one.
+func (h *HTTPHandler) handleRegisterSchema(w http.ResponseWriter, r *http.Request, subject string) {
+	var req struct {
+		Schema json.RawMessage `json:"schema"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Schema) == 0 {
+		http.Error(w, "Invalid JSON: expected {\"schema\": {...}}", http.StatusBadRequest)
+		return
+	}
+
+	version, created, err := h.pubSubSystem.Schemas.Register(subject, req.Schema)
+	if err != nil {
+		if incompatible, ok := err.(*schema.IncompatibleError); ok {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error":         "incompatible schema",
+				"compatibility": incompatible.Compatibility,
+				"problems":      incompatible.Problems,
+			})
+			return
+		}
+		if strings.HasPrefix(err.Error(), "failed to save") {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.Error(w, "Invalid schema: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if created {
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"subject": subject,
+		"version": version.Version,
+	})
+}
+
+// handleGetSchemaVersion returns one version of a subject, or the latest
+func (h *HTTPHandler) handleGetSchemaVersion(w http.ResponseWriter, subject, versionParam string) {
+	version := 0
+	if versionParam != "latest" {
+		n, err := strconv.Atoi(versionParam)
+		if err != nil || n <= 0 {
+			http.Error(w, "version must be a positive integer or latest", http.StatusBadRequest)
+			return
+		}
+		version = n
+	}
+
+	v, exists := h.pubSubSystem.Schemas.Version(subject, version)
+	if !exists {
+		http.Error(w, "schema version not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"subject":    subject,
+		"version":    v.Version,
+		"schema":     v.Schema,
+		"created_at": v.CreatedAt,
+	})
+}
+
+// handleSetCompatibility changes the compatibility mode of a subject
+func (h *HTTPHandler) handleSetCompatibility(w http.ResponseWriter, r *http.Request, subject string) {
+	var req struct {
+		Compatibility string `json:"compatibility"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.pubSubSystem.Schemas.SetCompatibility(subject, req.Compatibility); err != nil {
+		if err.Error() == "subject not found" {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else if strings.HasPrefix(err.Error(), "unknown ") {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"subject":       subject,
+		"compatibility": req.Compatibility,
+	})
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"pub-sub-system/models"
+)
+
+// post sends a JSON body and returns the status and response body
+func post(t *testing.T, url, body string) (int, string) {
+	t.Helper()
+	resp, err := http.Post(url, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("POST %s error = %v", url, err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(data)
+}
+
+// bindOrderSchema registers an order schema and creates a topic bound to it
+func bindOrderSchema(t *testing.T, base string) {
+	t.Helper()
+	schema := `{"schema": {"type": "object", "properties": {"items": {"type": "array", "items": {"type": "object", "properties": {"price": {"type": "number", "minimum": 0}}}}}, "required": ["items"]}}`
+	if status, body := post(t, base+"/schemas/order/versions", schema); status != http.StatusCreated {
+		t.Fatalf("register schema = %d %s, want 201", status, body)
+	}
+	if status, body := post(t, base+"/topics", `{"name": "orders", "settings": {"schema": "order"}}`); status != http.StatusCreated {
+		t.Fatalf("create topic = %d %s, want 201", status, body)
+	}
+}
+
+func TestRESTPublishSchemaViolation(t *testing.T) {
+	_, srv := startTestServer(t)
+	bindOrderSchema(t, srv.URL)
+
+	status, body := post(t, srv.URL+"/topics/orders/messages", `{"payload": {"items": [{"price": 1}, {"price": 2}, {"price": -3}]}}`)
+	if status != http.StatusUnprocessableEntity {
+		t.Fatalf("publish = %d %s, want 422", status, body)
+	}
+	if !strings.Contains(body, "version 1 at $.items[2].price: must be at least 0") {
+		t.Errorf("publish error = %q, want the failing path $.items[2].price", body)
+	}
+
+	// A batch with one invalid message is rejected as a whole
+	status, body = post(t, srv.URL+"/topics/orders/messages", `[{"payload": {"items": []}}, {"payload": {}}]`)
+	if status != http.StatusUnprocessableEntity || !strings.Contains(body, "at $.items:") {
+		t.Errorf("batch publish = %d %q, want 422 at $.items", status, body)
+	}
+
+	if status, body := post(t, srv.URL+"/topics/orders/messages", `{"payload": {"items": [{"price": 1}]}}`); status != http.StatusOK {
+		t.Errorf("valid publish = %d %s, want 200", status, body)
+	}
+}
+
+func TestRESTRegisterIncompatibleSchema(t *testing.T) {
+	_, srv := startTestServer(t)
+	bindOrderSchema(t, srv.URL)
+
+	status, body := post(t, srv.URL+"/schemas/order/versions", `{"schema": {"type": "object", "required": ["items", "id"]}}`)
+	if status != http.StatusConflict {
+		t.Fatalf("register incompatible schema = %d %s, want 409", status, body)
+	}
+	var reply struct {
+		Problems []string `json:"problems"`
+	}
+	if err := json.Unmarshal([]byte(body), &reply); err != nil || len(reply.Problems) == 0 {
+		t.Fatalf("register incompatible schema replied %s, want problems", body)
+	}
+	if !strings.Contains(strings.Join(reply.Problems, "\n"), "$.id: property is now required") {
+		t.Errorf("problems = %q, want $.id to be reported", reply.Problems)
+	}
+
+	if status, body := post(t, srv.URL+"/schemas/order/versions", `{"schema": {"type": "object", "not": {}}}`); status != http.StatusBadRequest {
+		t.Errorf("register schema with an unsupported keyword = %d %s, want 400", status, body)
+	}
+}
+
+func TestWebSocketPublishSchemaViolationCarriesPath(t *testing.T) {
+	_, srv := startTestServer(t)
+	bindOrderSchema(t, srv.URL)
+	ws := dialWebSocket(t, srv)
+
+	reply := request(t, ws, models.ClientMessage{
+		Type:      "publish",
+		Topic:     "orders",
+		Message:   &models.Message{Payload: map[string]interface{}{"items": []interface{}{map[string]interface{}{"price": "free"}}}},
+		RequestID: "pub",
+	})
+	if reply.Type != "error" || reply.Error.Code != "SCHEMA_VIOLATION" || reply.Error.Path != "$.items[0].price" {
+		t.Errorf("publish replied %s %+v, want SCHEMA_VIOLATION at $.items[0].price", reply.Type, reply.Error)
+	}
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"pub-sub-system/auth"
+	"pub-sub-system/models"
+	"pub-sub-system/pubsub"
+)
+
+// openSession issues a session for a new connection, or resumes the one
+// named by token and restores its subscriptions before any request is read.
+// It returns nil when sessions are disabled.
+func (h *WebSocketHandler) openSession(conn *wsConn, token string, ctx context.Context) *pubsub.Session {
+	if !h.sessions.Enabled() {
+		return nil
+	}
+
+	principal := ""
+	if conn.principal != nil {
+		principal = conn.principal.Name
+	}
+
+	if token != "" {
+		if session, subs, ok := h.sessions.Resume(token, principal, conn); ok {
+			h.sendSession(conn, session, true)
+			h.restoreSubscriptions(conn, subs, ctx)
+			return session
+		}
+		h.sendError(conn, "SESSION_EXPIRED", "Session not found or expired; subscriptions must be recreated", "")
+	}
+
+	session := h.sessions.Open(principal, conn)
+	h.sendSession(conn, session, false)
+	return session
+}
+
+// restoreSubscriptions re-creates a resumed session's subscriptions on conn.
+// Each processor replays what was published while the client was away
+// before any live event. Subscriptions whose topic is gone or that the
+// policy no longer allows are reported with an error frame and dropped.
+func (h *WebSocketHandler) restoreSubscriptions(conn *wsConn, subs []*models.Subscriber, ctx context.Context) {
+	for _, old := range subs {
+		if !h.authorize(conn, auth.ActionSubscribe, old.Topic, "") {
+			continue
+		}
+
+		sub := h.subManager.Resubscribe(old, conn)
+		if err := h.pubSubSystem.RestoreSubscriber(sub); err != nil {
+			h.sendErrorFor(conn, err, "")
+			continue
+		}
+		h.subManager.StartMessageProcessor(sub, ctx)
+	}
+}
+
+// sendSession tells the client its session token
+func (h *WebSocketHandler) sendSession(conn *wsConn, session *pubsub.Session, resumed bool) {
+	conn.WriteJSON(&models.ServerMessage{
+		Type:    "session",
+		Session: session.Token,
+		Resumed: resumed,
+		TS:      time.Now().UTC().Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"pub-sub-system/filter"
+	"pub-sub-system/models"
+	"pub-sub-system/pubsub"
+
+	"github.com/google/uuid"
+)
+
+// sseConn adapts a Server-Sent Events response stream to models.WebSocketConn
+// so SSE clients can be attached to topics like WebSocket subscribers
+type sseConn struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	mu      sync.Mutex
+	done    chan struct{}
+	once    sync.Once
+}
+
+// WriteJSON writes a server message as an SSE event. Events carry the
+// message ID so browsers resume with Last-Event-ID after a reconnect.
+func (c *sseConn) WriteJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return fmt.Errorf("stream closed")
+	default:
+	}
+
+	if msg, ok := v.(*models.ServerMessage); ok {
+		if msg.Type == "event" && msg.Message != nil {
+			fmt.Fprintf(c.w, "id: %s\n", msg.Message.ID)
+		} else {
+			fmt.Fprintf(c.w, "event: %s\n", msg.Type)
+		}
+	}
+	if _, err := fmt.Fprintf(c.w, "data: %s\n\n", data); err != nil {
+		return err
+	}
+	c.flusher.Flush()
+	return nil
+}
+
+// writeComment writes an SSE comment line, used as a keep-alive
+func (c *sseConn) writeComment(text string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return fmt.Errorf("stream closed")
+	default:
+	}
+
+	if _, err := fmt.Fprintf(c.w, ": %s\n\n", text); err != nil {
+		return err
+	}
+	c.flusher.Flush()
+	return nil
+}
+
+// Close ends the stream; the handler returns once it is closed. Taking the
+// write lock guarantees no write is in progress when the handler returns.
+func (c *sseConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.once.Do(func() { close(c.done) })
+	return nil
+}
+
+// handleEvents streams a topic's events as text/event-stream. Query
+// parameters: client_id (generated if absent), group, from_offset, last_n,
+// backpressure and block_timeout_ms.
+// A Last-Event-ID header takes precedence over last_n and replays everything
+// published after that message.
+func (h *HTTPHandler) handleEvents(w http.ResponseWriter, r *http.Request, topicName string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	query := r.URL.Query()
+	clientID := query.Get("client_id")
+	if clientID == "" {
+		clientID = "sse-" + uuid.New().String()
+	}
+
+	history := pubsub.HistoryRequest{AfterID: r.Header.Get("Last-Event-ID")}
+	if v := query.Get("last_n"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "last_n must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+		history.LastN = n
+	}
+	if v := query.Get("from_offset"); v != "" && history.AfterID == "" {
+		offset, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			http.Error(w, "from_offset must be an integer", http.StatusBadRequest)
+			return
+		}
+		history.FromOffset = &offset
+	}
+
+	backpressure := query.Get("backpressure")
+	if backpressure != "" && !pubsub.ValidBackpressure(backpressure) {
+		http.Error(w, "backpressure must be disconnect, drop_newest, drop_oldest or block", http.StatusBadRequest)
+		return
+	}
+	var blockTimeout time.Duration
+	if v := query.Get("block_timeout_ms"); v != "" {
+		ms, err := strconv.Atoi(v)
+		if err != nil || ms < 0 {
+			http.Error(w, "block_timeout_ms must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+		blockTimeout = time.Duration(ms) * time.Millisecond
+	}
+	var subFilter models.MessageFilter
+	if v := query.Get("filter"); v != "" {
+		parsed, err := filter.Parse(v)
+		if err != nil {
+			http.Error(w, "Invalid filter: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		subFilter = parsed
+	}
+
+	conn := &sseConn{w: w, flusher: flusher, done: make(chan struct{})}
+	defer conn.Close()
+
+	var sub *models.Subscriber
+	if pubsub.IsPattern(topicName) {
+		if err := pubsub.ValidatePattern(topicName); err != nil {
+			http.Error(w, "Invalid topic pattern: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if history.FromOffset != nil {
+			http.Error(w, "from_offset cannot be used with a topic pattern", http.StatusBadRequest)
+			return
+		}
+		sub = h.subManager.NewSubscriber(pubsub.SubscriberKey(clientID, topicName), topicName, conn)
+		sub.Group = query.Get("group")
+		sub.Backpressure = backpressure
+		sub.BlockTimeout = blockTimeout
+		sub.Filter = subFilter
+		if err := h.pubSubSystem.AddWildcardSubscriberWithHistory(sub, history); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer h.pubSubSystem.RemoveWildcardSubscribersByConn(conn)
+	} else {
+		topic, exists := h.pubSubSystem.GetTopic(topicName)
+		if !exists {
+			http.Error(w, "Topic does not exist", http.StatusNotFound)
+			return
+		}
+		sub = h.subManager.NewSubscriber(clientID, topicName, conn)
+		sub.Group = query.Get("group")
+		sub.Backpressure = backpressure
+		sub.BlockTimeout = blockTimeout
+		sub.Filter = subFilter
+		if err := h.topicManager.AddSubscriberWithHistory(topic, sub, history); err != nil {
+			if e, ok := err.(*models.Error); ok && e.Code == "OFFSET_OUT_OF_RANGE" {
+				http.Error(w, e.Message, http.StatusRequestedRangeNotSatisfiable)
+			} else {
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			}
+			return
+		}
+		// Remove by connection so a newer stream that reused the client ID
+		// keeps its subscription
+		defer h.topicManager.RemoveSubscribersByConn(topic, conn)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	// The processor replays the backlog before live events
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	h.subManager.StartMessageProcessor(sub, ctx)
+
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := conn.writeComment("ping"); err != nil {
+				return
+			}
+		case <-conn.done:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,657 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"pub-sub-system/models"
+	"pub-sub-system/pubsub"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// StompSubprotocol is the Sec-WebSocket-Protocol value that switches a
+// connection from JSON messages to STOMP 1.2 frames
+const StompSubprotocol = "v12.stomp"
+
+// stompTopicPrefix is the destination prefix STOMP clients conventionally
+// use for topics; "/topic/orders" and "orders" name the same topic
+const stompTopicPrefix = "/topic/"
+
+// Ack modes of a STOMP subscription
+const (
+	stompAckAuto             = "auto"
+	stompAckClient           = "client"
+	stompAckClientIndividual = "client-individual"
+)
+
+// stompReservedHeaders are the SEND and MESSAGE headers that belong to STOMP
+// itself rather than to the message
+var stompReservedHeaders = map[string]bool{
+	"destination":    true,
+	"subscription":   true,
+	"message-id":     true,
+	"ack":            true,
+	"receipt":        true,
+	"content-type":   true,
+	"content-length": true,
+	"transaction":    true,
+	"offset":         true,
+}
+
+// stompSession is the STOMP state of one WebSocket connection
+type stompSession struct {
+	id        string // Prefixes the client IDs of the connection's subscriptions
+	connected bool   // Set once CONNECT has been answered; read loop only
+	failed    atomic.Bool
+
+	mu            sync.Mutex
+	subscriptions map[string]*stompSubscription // Keyed by STOMP subscription id
+	bySubscriber  map[string]*stompSubscription // Keyed by subscriber ID
+}
+
+// stompSubscription maps a STOMP subscription onto a subscriber
+type stompSubscription struct {
+	id          string
+	destination string // As given by the client, echoed in MESSAGE frames
+	topic       string
+	clientID    string
+	ack         string
+}
+
+// newStompSession creates the STOMP state for a new connection
+func newStompSession() *stompSession {
+	return &stompSession{
+		id:            uuid.New().String(),
+		subscriptions: make(map[string]*stompSubscription),
+		bySubscriber:  make(map[string]*stompSubscription),
+	}
+}
+
+// stompFrame is a decoded STOMP frame. Only the first occurrence of a
+// repeated header is kept, as the specification requires.
+type stompFrame struct {
+	command string
+	headers map[string]string
+	body    []byte
+}
+
+// processStompFrames reads STOMP frames until the connection closes, the
+// client disconnects or an ERROR frame ends the connection
+func (h *WebSocketHandler) processStompFrames(conn *wsConn, ctx context.Context) {
+	for {
+		_, data, err := conn.Conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				log.Printf("WebSocket read error: %v", err)
+			}
+			return
+		}
+
+		frames, err := parseStompFrames(data)
+		if err != nil {
+			h.sendError(conn, "BAD_REQUEST", "Malformed STOMP frame: "+err.Error(), "")
+			return
+		}
+		for _, frame := range frames {
+			if !h.handleStompFrame(conn, frame, ctx) || conn.stomp.failed.Load() {
+				return
+			}
+		}
+	}
+}
+
+// handleStompFrame performs the operation a client frame stands for by
+// translating it into the ClientMessage a JSON client would have sent. It
+// returns false once the connection should end.
+func (h *WebSocketHandler) handleStompFrame(conn *wsConn, frame *stompFrame, ctx context.Context) bool {
+	receipt := frame.headers["receipt"]
+	if frame.command == "CONNECT" || frame.command == "STOMP" {
+		return h.handleStompConnect(conn, frame)
+	}
+	if !conn.stomp.connected {
+		h.sendError(conn, "BAD_REQUEST", "Expected CONNECT frame", receipt)
+		return false
+	}
+
+	var msg *models.ClientMessage
+	switch frame.command {
+	case "SEND":
+		msg = h.stompPublish(conn, frame)
+	case "SUBSCRIBE":
+		msg = h.stompSubscribe(conn, frame)
+	case "UNSUBSCRIBE":
+		msg = h.stompUnsubscribe(conn, frame)
+	case "ACK", "NACK":
+		msg = h.stompAck(conn, frame)
+	case "DISCONNECT":
+		if receipt != "" {
+			conn.writeStompFrame(encodeStompFrame("RECEIPT", []string{"receipt-id", receipt}, nil))
+		}
+	case "BEGIN", "COMMIT", "ABORT":
+		h.sendError(conn, "BAD_REQUEST", "Transactions are not supported", receipt)
+	default:
+		h.sendError(conn, "BAD_REQUEST", "Unknown STOMP command "+frame.command, receipt)
+	}
+	if msg == nil {
+		return false
+	}
+
+	h.handleClientMessage(conn, msg, ctx)
+	return true
+}
+
+// handleStompConnect answers CONNECT. The connection is already
+// authenticated by the upgrade request, so login and passcode are ignored.
+func (h *WebSocketHandler) handleStompConnect(conn *wsConn, frame *stompFrame) bool {
+	if conn.stomp.connected {
+		h.sendError(conn, "BAD_REQUEST", "Already connected", "")
+		return false
+	}
+
+	supported := false
+	for _, version := range strings.Split(frame.headers["accept-version"], ",") {
+		supported = supported || strings.TrimSpace(version) == "1.2"
+	}
+	if !supported {
+		conn.writeStompFrame(encodeStompFrame("ERROR", []string{
+			"version", "1.2",
+			"message", "BAD_REQUEST",
+			"content-type", "text/plain",
+		}, []byte("Only STOMP 1.2 is supported")))
+		return false
+	}
+
+	conn.stomp.connected = true
+	return conn.writeStompFrame(encodeStompFrame("CONNECTED", []string{
+		"version", "1.2",
+		"heart-beat", "0,0",
+		"session", conn.stomp.id,
+		"server", "pub-sub-system",
+	}, nil)) == nil
+}
+
+// stompPublish translates SEND into a publish. Headers other than STOMP's
+// own become message headers.
+func (h *WebSocketHandler) stompPublish(conn *wsConn, frame *stompFrame) *models.ClientMessage {
+	receipt := frame.headers["receipt"]
+	if frame.headers["transaction"] != "" {
+		h.sendError(conn, "BAD_REQUEST", "Transactions are not supported", receipt)
+		return nil
+	}
+
+	payload, ok := stompPayload(frame.headers["content-type"], frame.body)
+	if !ok {
+		h.sendError(conn, "BAD_REQUEST", "Body is not valid JSON", receipt)
+		return nil
+	}
+
+	message := &models.Message{Payload: payload}
+	for name, value := range frame.headers {
+		if !stompReservedHeaders[name] {
+			if message.Headers == nil {
+				message.Headers = make(map[string]string)
+			}
+			message.Headers[name] = value
+		}
+	}
+
+	return &models.ClientMessage{
+		Type:      "publish",
+		Topic:     stompTopic(frame.headers["destination"]),
+		Message:   message,
+		RequestID: receipt,
+	}
+}
+
+// stompSubscribe translates SUBSCRIBE into a subscribe. The ack mode picks
+// the delivery mode, and the last-n, from-offset, group and filter headers
+// work like the fields of a JSON subscribe.
+func (h *WebSocketHandler) stompSubscribe(conn *wsConn, frame *stompFrame) *models.ClientMessage {
+	receipt := frame.headers["receipt"]
+	id, destination := frame.headers["id"], frame.headers["destination"]
+	if id == "" || destination == "" {
+		h.sendError(conn, "BAD_REQUEST", "SUBSCRIBE requires destination and id headers", receipt)
+		return nil
+	}
+
+	msg := &models.ClientMessage{
+		Type:      "subscribe",
+		Topic:     stompTopic(destination),
+		ClientID:  conn.stomp.id + ":" + id,
+		RequestID: receipt,
+		Group:     frame.headers["group"],
+		Filter:    frame.headers["filter"],
+		Delivery:  pubsub.DeliveryAtMostOnce,
+	}
+
+	ack := frame.headers["ack"]
+	switch ack {
+	case "", stompAckAuto:
+		ack = stompAckAuto
+	case stompAckClient, stompAckClientIndividual:
+		msg.Delivery = pubsub.DeliveryAtLeastOnce
+	default:
+		h.sendError(conn, "BAD_REQUEST", "ack must be auto, client or client-individual", receipt)
+		return nil
+	}
+
+	if value := frame.headers["last-n"]; value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			h.sendError(conn, "BAD_REQUEST", "last-n must be a non-negative integer", receipt)
+			return nil
+		}
+		msg.LastN = n
+	}
+	if value := frame.headers["from-offset"]; value != "" {
+		offset, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			h.sendError(conn, "BAD_REQUEST", "from-offset must be an integer", receipt)
+			return nil
+		}
+		msg.FromOffset = &offset
+	}
+
+	// Register before subscribing so the first MESSAGE frame can name its
+	// subscription. A failed subscribe ends the connection anyway.
+	sub := &stompSubscription{id: id, destination: destination, topic: msg.Topic, clientID: msg.ClientID, ack: ack}
+	if !conn.stomp.add(sub) {
+		h.sendError(conn, "BAD_REQUEST", "Subscription id "+id+" is already in use", receipt)
+		return nil
+	}
+	return msg
+}
+
+// stompUnsubscribe translates UNSUBSCRIBE of the subscription named by the
+// id header into an unsubscribe
+func (h *WebSocketHandler) stompUnsubscribe(conn *wsConn, frame *stompFrame) *models.ClientMessage {
+	receipt := frame.headers["receipt"]
+	sub, exists := conn.stomp.remove(frame.headers["id"])
+	if !exists {
+		h.sendError(conn, "BAD_REQUEST", "No subscription with id "+frame.headers["id"], receipt)
+		return nil
+	}
+
+	return &models.ClientMessage{
+		Type:      "unsubscribe",
+		Topic:     sub.topic,
+		ClientID:  sub.clientID,
+		RequestID: receipt,
+	}
+}
+
+// stompAck translates ACK or NACK into an ack or nack of the message named
+// by the id header, which is the ack header of its MESSAGE frame. In client
+// ack mode it is cumulative and also covers every unacknowledged message the
+// subscription was sent before that one; in client-individual mode it
+// covers just the one message.
+func (h *WebSocketHandler) stompAck(conn *wsConn, frame *stompFrame) *models.ClientMessage {
+	receipt := frame.headers["receipt"]
+	if frame.headers["transaction"] != "" {
+		h.sendError(conn, "BAD_REQUEST", "Transactions are not supported", receipt)
+		return nil
+	}
+
+	ackID := frame.headers["id"]
+	sep := strings.LastIndex(ackID, "/")
+	if sep < 0 {
+		h.sendError(conn, "BAD_REQUEST", "Invalid ack id "+ackID, receipt)
+		return nil
+	}
+	sub, exists := conn.stomp.lookup(ackID[:sep])
+	if !exists {
+		h.sendError(conn, "BAD_REQUEST", "No subscription for ack id "+ackID, receipt)
+		return nil
+	}
+
+	return &models.ClientMessage{
+		Type:       strings.ToLower(frame.command),
+		Topic:      sub.topic,
+		ClientID:   sub.clientID,
+		Message:    &models.Message{ID: ackID[sep+1:]},
+		RequestID:  receipt,
+		Cumulative: sub.ack == stompAckClient,
+	}
+}
+
+// add registers a subscription unless its id is taken
+func (s *stompSession) add(sub *stompSubscription) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.subscriptions[sub.id]; exists {
+		return false
+	}
+	s.subscriptions[sub.id] = sub
+	s.bySubscriber[pubsub.SubscriberKey(sub.clientID, sub.topic)] = sub
+	return true
+}
+
+// remove unregisters a subscription by id
+func (s *stompSession) remove(id string) (*stompSubscription, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sub, exists := s.subscriptions[id]
+	if exists {
+		delete(s.subscriptions, id)
+		delete(s.bySubscriber, pubsub.SubscriberKey(sub.clientID, sub.topic))
+	}
+	return sub, exists
+}
+
+// lookup returns a subscription by id
+func (s *stompSession) lookup(id string) (*stompSubscription, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sub, exists := s.subscriptions[id]
+	return sub, exists
+}
+
+// frameFor translates a server message into a STOMP frame: events become
+// MESSAGE, acks for requests with a receipt header become RECEIPT, errors
+// become ERROR and the heartbeat becomes a heart-beat EOL. It returns nil
+// for messages STOMP has no frame for. The second result reports whether
+// the connection must close after the frame.
+func (s *stompSession) frameFor(msg *models.ServerMessage) ([]byte, bool) {
+	switch msg.Type {
+	case "event":
+		s.mu.Lock()
+		sub, exists := s.bySubscriber[msg.SubscriberID]
+		s.mu.Unlock()
+		if !exists || msg.Message == nil {
+			return nil, false // Unsubscribed meanwhile
+		}
+		frame, err := messageFrame(sub, msg)
+		if err != nil {
+			log.Printf("Failed to encode STOMP message %s: %v", msg.Message.ID, err)
+			return nil, false
+		}
+		return frame, false
+
+	case "ack":
+		if msg.RequestID == "" {
+			return nil, false
+		}
+		return encodeStompFrame("RECEIPT", []string{"receipt-id", msg.RequestID}, nil), false
+
+	case "error":
+		if msg.Error == nil {
+			return nil, false
+		}
+		headers := []string{"message", msg.Error.Code, "content-type", "text/plain"}
+		if msg.RequestID != "" {
+			headers = append(headers, "receipt-id", msg.RequestID)
+		}
+		if msg.Error.Path != "" {
+			headers = append(headers, "path", msg.Error.Path)
+		}
+		return encodeStompFrame("ERROR", headers, []byte(msg.Error.Message)), true
+
+	case "info":
+		if msg.Msg == "ping" {
+			return []byte("\n"), false
+		}
+	}
+	return nil, false
+}
+
+// messageFrame encodes an event for a subscription. String payloads are sent
+// as text and anything else as JSON; message headers are passed through.
+func messageFrame(sub *stompSubscription, msg *models.ServerMessage) ([]byte, error) {
+	destination := msg.Topic
+	if strings.HasPrefix(sub.destination, stompTopicPrefix) {
+		destination = stompTopicPrefix + msg.Topic
+	}
+
+	contentType := "text/plain"
+	body, isText := msg.Message.Payload.(string)
+	var data []byte
+	if isText {
+		data = []byte(body)
+	} else {
+		var err error
+		if data, err = json.Marshal(msg.Message.Payload); err != nil {
+			return nil, err
+		}
+		contentType = "application/json"
+	}
+
+	headers := []string{
+		"destination", destination,
+		"subscription", sub.id,
+		"message-id", msg.Message.ID,
+		"content-type", contentType,
+		"offset", strconv.FormatInt(msg.Message.Offset, 10),
+	}
+	if sub.ack != stompAckAuto {
+		headers = append(headers, "ack", sub.id+"/"+msg.Message.ID)
+	}
+
+	names := make([]string, 0, len(msg.Message.Headers))
+	for name := range msg.Message.Headers {
+		if !stompReservedHeaders[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		headers = append(headers, name, msg.Message.Headers[name])
+	}
+	return encodeStompFrame("MESSAGE", headers, data), nil
+}
+
+// writeStomp writes a server message as a STOMP frame, closing the
+// connection after an ERROR frame as STOMP requires
+func (c *wsConn) writeStomp(v interface{}) error {
+	msg, ok := v.(*models.ServerMessage)
+	if !ok {
+		return nil
+	}
+	frame, fatal := c.stomp.frameFor(msg)
+	if frame == nil {
+		return nil
+	}
+
+	err := c.writeStompFrame(frame)
+	if fatal {
+		c.stomp.failed.Store(true)
+		c.Conn.Close()
+	}
+	return err
+}
+
+// writeStompFrame writes an encoded frame as one text message
+func (c *wsConn) writeStompFrame(frame []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.Conn.WriteMessage(websocket.TextMessage, frame)
+}
+
+// stompTopic returns the topic a destination names
+func stompTopic(destination string) string {
+	return strings.TrimPrefix(destination, stompTopicPrefix)
+}
+
+// stompPayload decodes a SEND body. JSON bodies keep their structure and
+// text stays a string; without a content-type the body is JSON if it parses
+// as JSON. It returns false for an application/json body that is not JSON.
+func stompPayload(contentType string, body []byte) (interface{}, bool) {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.TrimSpace(strings.ToLower(mediaType))
+
+	var v interface{}
+	switch {
+	case mediaType == "application/json":
+		if err := json.Unmarshal(body, &v); err != nil {
+			return nil, false
+		}
+		return v, true
+	case mediaType == "" && json.Unmarshal(body, &v) == nil:
+		return v, true
+	}
+	return string(body), true
+}
+
+// parseStompFrames decodes the frames in one WebSocket message, skipping
+// heart-beat EOLs between them
+func parseStompFrames(data []byte) ([]*stompFrame, error) {
+	var frames []*stompFrame
+	for {
+		data = bytes.TrimLeft(data, "\r\n")
+		if len(data) == 0 {
+			return frames, nil
+		}
+
+		frame, rest, err := parseStompFrame(data)
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, frame)
+		data = rest
+	}
+}
+
+// parseStompFrame decodes one frame and returns the data after it
+func parseStompFrame(data []byte) (*stompFrame, []byte, error) {
+	readLine := func() (string, bool) {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			return "", false
+		}
+		line := strings.TrimSuffix(string(data[:i]), "\r")
+		data = data[i+1:]
+		return line, true
+	}
+
+	command, ok := readLine()
+	if !ok {
+		return nil, nil, fmt.Errorf("incomplete frame")
+	}
+	frame := &stompFrame{command: command, headers: make(map[string]string)}
+
+	// CONNECT headers are not escaped, for compatibility with STOMP 1.0
+	escaped := command != "CONNECT"
+	for {
+		line, ok := readLine()
+		if !ok {
+			return nil, nil, fmt.Errorf("incomplete headers")
+		}
+		if line == "" {
+			break
+		}
+		name, value, found := strings.Cut(line, ":")
+		if !found {
+			return nil, nil, fmt.Errorf("invalid header %q", line)
+		}
+		if escaped {
+			var err error
+			if name, err = unescapeStompHeader(name); err != nil {
+				return nil, nil, err
+			}
+			if value, err = unescapeStompHeader(value); err != nil {
+				return nil, nil, err
+			}
+		}
+		if _, exists := frame.headers[name]; !exists {
+			frame.headers[name] = value
+		}
+	}
+
+	// The body runs for content-length bytes if given, otherwise up to the
+	// first NULL; either way a NULL ends the frame
+	end := bytes.IndexByte(data, 0)
+	if value, exists := frame.headers["content-length"]; exists {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return nil, nil, fmt.Errorf("invalid content-length %q", value)
+		}
+		end = n
+		if len(data) <= n || data[n] != 0 {
+			return nil, nil, fmt.Errorf("body does not match content-length")
+		}
+	}
+	if end < 0 {
+		return nil, nil, fmt.Errorf("missing NULL after body")
+	}
+
+	frame.body = data[:end]
+	return frame, data[end+1:], nil
+}
+
+// encodeStompFrame encodes a frame from alternating header names and
+// values. Header values are escaped except in CONNECTED, and a
+// content-length header is added for bodies.
+func encodeStompFrame(command string, headers []string, body []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(command)
+	buf.WriteByte('\n')
+
+	escape := command != "CONNECTED"
+	for i := 0; i+1 < len(headers); i += 2 {
+		name, value := headers[i], headers[i+1]
+		if escape {
+			name, value = stompHeaderEscaper.Replace(name), stompHeaderEscaper.Replace(value)
+		}
+		buf.WriteString(name)
+		buf.WriteByte(':')
+		buf.WriteString(value)
+		buf.WriteByte('\n')
+	}
+	if len(body) > 0 {
+		buf.WriteString("content-length:")
+		buf.WriteString(strconv.Itoa(len(body)))
+		buf.WriteByte('\n')
+	}
+
+	buf.WriteByte('\n')
+	buf.Write(body)
+	buf.WriteByte(0)
+	return buf.Bytes()
+}
+
+var stompHeaderEscaper = strings.NewReplacer("\\", "\\\\", "\r", "\\r", "\n", "\\n", ":", "\\c")
+
+// unescapeStompHeader reverses header escaping, rejecting undefined escape
+// sequences as the specification requires
+func unescapeStompHeader(s string) (string, error) {
+	if !strings.Contains(s, "\\") {
+		return s, nil
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+1 == len(s) {
+			return "", fmt.Errorf("invalid escape in header %q", s)
+		}
+		i++
+		switch s[i] {
+		case 'r':
+			b.WriteByte('\r')
+		case 'n':
+			b.WriteByte('\n')
+		case 'c':
+			b.WriteByte(':')
+		case '\\':
+			b.WriteByte('\\')
+		default:
+			return "", fmt.Errorf("invalid escape in header %q", s)
+		}
+	}
+	return b.String(), nil
+}
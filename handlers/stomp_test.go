@@ -0,0 +1,286 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestStompFrameRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		headers []string
+		body    []byte
+	}{
+		{"no body", "SUBSCRIBE", []string{"id", "0", "destination", "/topic/orders"}, nil},
+		{"text body", "SEND", []string{"destination", "orders", "content-type", "text/plain"}, []byte("hello")},
+		{"body with NULL", "SEND", []string{"destination", "orders"}, []byte("a\x00b")},
+		{"escaped headers", "SEND", []string{"destination", "orders", "key:with\\colon", "line1\nline2\r:\\"}, []byte("{}")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := encodeStompFrame(tt.command, tt.headers, tt.body)
+			frame, rest, err := parseStompFrame(data)
+			if err != nil {
+				t.Fatalf("parseStompFrame() error = %v", err)
+			}
+			if len(rest) != 0 {
+				t.Errorf("parseStompFrame() left %q", rest)
+			}
+			if frame.command != tt.command || !bytes.Equal(frame.body, tt.body) {
+				t.Errorf("parsed %s %q, want %s %q", frame.command, frame.body, tt.command, tt.body)
+			}
+			for i := 0; i+1 < len(tt.headers); i += 2 {
+				if got := frame.headers[tt.headers[i]]; got != tt.headers[i+1] {
+					t.Errorf("header %q = %q, want %q", tt.headers[i], got, tt.headers[i+1])
+				}
+			}
+			if len(tt.body) > 0 && frame.headers["content-length"] == "" {
+				t.Error("encoded frame with a body has no content-length")
+			}
+		})
+	}
+}
+
+func TestEncodeStompFrameEscaping(t *testing.T) {
+	got := string(encodeStompFrame("MESSAGE", []string{"a:b", "c\nd\\"}, nil))
+	if want := "MESSAGE\na\\cb:c\\nd\\\\\n\n\x00"; got != want {
+		t.Errorf("MESSAGE = %q, want %q", got, want)
+	}
+
+	// CONNECTED headers are not escaped
+	got = string(encodeStompFrame("CONNECTED", []string{"server", "a:b"}, nil))
+	if want := "CONNECTED\nserver:a:b\n\n\x00"; got != want {
+		t.Errorf("CONNECTED = %q, want %q", got, want)
+	}
+}
+
+func TestUnescapeStompHeader(t *testing.T) {
+	tests := []struct {
+		in, want string
+		ok       bool
+	}{
+		{"plain", "plain", true},
+		{`a\cb`, "a:b", true},
+		{`\r\n\\`, "\r\n\\", true},
+		{`tab\t`, "", false},
+		{`trailing\`, "", false},
+	}
+	for _, tt := range tests {
+		got, err := unescapeStompHeader(tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("unescapeStompHeader(%q) = %q, %v, want %q ok=%v", tt.in, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestParseStompFrames(t *testing.T) {
+	data := []byte("\n\r\nSEND\ndestination:a\n\nfirst\x00\nCONNECT\naccept-version:1.2\nhost:a\\c\n\n\x00SEND\ndestination:b\ndestination:ignored\ncontent-length:3\n\nx\x00y\x00\n")
+	frames, err := parseStompFrames(data)
+	if err != nil {
+		t.Fatalf("parseStompFrames() error = %v", err)
+	}
+	if len(frames) != 3 {
+		t.Fatalf("parsed %d frames, want 3", len(frames))
+	}
+	if frames[0].command != "SEND" || string(frames[0].body) != "first" {
+		t.Errorf("frame 0 = %s %q", frames[0].command, frames[0].body)
+	}
+	// CONNECT headers are taken literally
+	if frames[1].command != "CONNECT" || frames[1].headers["host"] != `a\c` {
+		t.Errorf("frame 1 = %s host %q, want CONNECT host a\\c", frames[1].command, frames[1].headers["host"])
+	}
+	// The first of repeated headers wins, and content-length allows NULLs
+	if frames[2].headers["destination"] != "b" || string(frames[2].body) != "x\x00y" {
+		t.Errorf("frame 2 = destination %q body %q", frames[2].headers["destination"], frames[2].body)
+	}
+}
+
+func TestParseStompFrameRejectsMalformedFrames(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		err  string
+	}{
+		{"no command line", "SEND", "incomplete frame"},
+		{"no blank line", "SEND\ndestination:a\n", "incomplete headers"},
+		{"header without colon", "SEND\ndestination\n\n\x00", "invalid header"},
+		{"bad escape", "SEND\ndestination:a\\t\n\n\x00", "invalid escape"},
+		{"no NULL", "SEND\ndestination:a\n\nbody", "missing NULL"},
+		{"content-length too long", "SEND\ncontent-length:10\n\nshort\x00", "does not match content-length"},
+		{"content-length too short", "SEND\ncontent-length:2\n\nlonger\x00", "does not match content-length"},
+		{"negative content-length", "SEND\ncontent-length:-1\n\n\x00", "invalid content-length"},
+		{"non-numeric content-length", "SEND\ncontent-length:abc\n\n\x00", "invalid content-length"},
+	}
+	for _, tt := range tests {
+		_, _, err := parseStompFrame([]byte(tt.data))
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: parseStompFrame() error = %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+// dialStomp opens /ws with the STOMP subprotocol and connects
+func dialStomp(t *testing.T, srv *httptest.Server) *websocket.Conn {
+	t.Helper()
+	ws := dialWebSocket(t, srv, StompSubprotocol)
+	sendStomp(t, ws, "CONNECT", "accept-version", "1.2", "host", "localhost")
+	if frame := readStomp(t, ws); frame.command != "CONNECTED" || frame.headers["version"] != "1.2" {
+		t.Fatalf("CONNECT answered with %s %v", frame.command, frame.headers)
+	}
+	return ws
+}
+
+// sendStomp writes one frame from alternating header names and values
+func sendStomp(t *testing.T, ws *websocket.Conn, command string, headers ...string) {
+	t.Helper()
+	sendStompBody(t, ws, command, nil, headers...)
+}
+
+func sendStompBody(t *testing.T, ws *websocket.Conn, command string, body []byte, headers ...string) {
+	t.Helper()
+	if err := ws.WriteMessage(websocket.TextMessage, encodeStompFrame(command, headers, body)); err != nil {
+		t.Fatalf("write %s error = %v", command, err)
+	}
+}
+
+// readStomp reads the next frame, skipping heart-beat EOLs
+func readStomp(t *testing.T, ws *websocket.Conn) *stompFrame {
+	t.Helper()
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatalf("STOMP read error = %v", err)
+		}
+		frames, err := parseStompFrames(data)
+		if err != nil {
+			t.Fatalf("server sent a malformed frame %q: %v", data, err)
+		}
+		if len(frames) > 0 {
+			return frames[0]
+		}
+	}
+}
+
+func TestStompReceiptAndError(t *testing.T) {
+	ps, srv := startTestServer(t)
+	if _, err := ps.NewTopic("orders"); err != nil {
+		t.Fatalf("NewTopic() error = %v", err)
+	}
+	ws := dialStomp(t, srv)
+
+	// A receipt header is answered with RECEIPT
+	sendStomp(t, ws, "SUBSCRIBE", "id", "0", "destination", "/topic/orders", "receipt", "sub-1")
+	if frame := readStomp(t, ws); frame.command != "RECEIPT" || frame.headers["receipt-id"] != "sub-1" {
+		t.Fatalf("SUBSCRIBE answered with %s %v, want RECEIPT sub-1", frame.command, frame.headers)
+	}
+
+	sendStompBody(t, ws, "SEND", []byte(`{"id":1}`), "destination", "/topic/orders", "content-type", "application/json", "receipt", "send-1")
+	var message *stompFrame
+	for receipt := false; !receipt || message == nil; {
+		switch frame := readStomp(t, ws); frame.command {
+		case "RECEIPT":
+			receipt = frame.headers["receipt-id"] == "send-1"
+		case "MESSAGE":
+			message = frame
+		default:
+			t.Fatalf("unexpected %s %v", frame.command, frame.headers)
+		}
+	}
+	if message.headers["destination"] != "/topic/orders" || message.headers["subscription"] != "0" || string(message.body) != `{"id":1}` {
+		t.Errorf("MESSAGE = %v %q", message.headers, message.body)
+	}
+
+	// An error becomes ERROR with the code and receipt, then the connection closes
+	sendStomp(t, ws, "SUBSCRIBE", "id", "1", "destination", "/topic/missing", "receipt", "sub-2")
+	frame := readStomp(t, ws)
+	if frame.command != "ERROR" || frame.headers["message"] != "TOPIC_NOT_FOUND" || frame.headers["receipt-id"] != "sub-2" {
+		t.Errorf("SUBSCRIBE to a missing topic answered with %s %v", frame.command, frame.headers)
+	}
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, _, err := ws.ReadMessage(); err == nil {
+		t.Error("connection stayed open after ERROR")
+	}
+}
+
+func TestStompMalformedFrameIsAnError(t *testing.T) {
+	_, srv := startTestServer(t)
+	ws := dialStomp(t, srv)
+
+	if err := ws.WriteMessage(websocket.TextMessage, []byte("SEND\ndestination:orders\ncontent-length:10\n\nshort\x00")); err != nil {
+		t.Fatalf("write error = %v", err)
+	}
+	frame := readStomp(t, ws)
+	if frame.command != "ERROR" || frame.headers["message"] != "BAD_REQUEST" || !strings.Contains(string(frame.body), "content-length") {
+		t.Errorf("malformed frame answered with %s %v %q", frame.command, frame.headers, frame.body)
+	}
+}
+
+func TestStompClientAckIsCumulative(t *testing.T) {
+	tests := []struct {
+		mode    string
+		pending []string // Messages still unacknowledged after acking m2
+	}{
+		{stompAckClient, []string{"m3"}},
+		{stompAckClientIndividual, []string{"m1", "m3"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			ps, srv := startTestServer(t)
+			if _, err := ps.NewTopic("orders"); err != nil {
+				t.Fatalf("NewTopic() error = %v", err)
+			}
+			ws := dialStomp(t, srv)
+
+			sendStomp(t, ws, "SUBSCRIBE", "id", "0", "destination", "orders", "ack", tt.mode, "receipt", "sub")
+			readStomp(t, ws)
+
+			acks := make(map[string]string) // Message body to ack header
+			for _, body := range []string{"m1", "m2", "m3"} {
+				sendStompBody(t, ws, "SEND", []byte(body), "destination", "orders", "content-type", "text/plain")
+				frame := readStomp(t, ws)
+				if frame.command != "MESSAGE" {
+					t.Fatalf("SEND answered with %s %v", frame.command, frame.headers)
+				}
+				acks[string(frame.body)] = frame.headers["ack"]
+			}
+
+			sendStomp(t, ws, "ACK", "id", acks["m2"], "receipt", "ack")
+			if frame := readStomp(t, ws); frame.command != "RECEIPT" {
+				t.Fatalf("ACK answered with %s %v", frame.command, frame.headers)
+			}
+			// NACK of a pending message is receipted, of an acknowledged one is an error
+			for _, body := range []string{"m3", "m1"} {
+				sendStomp(t, ws, "NACK", "id", acks[body], "receipt", "nack-"+body)
+				frame := readStomp(t, ws)
+				if frame.command == "MESSAGE" {
+					frame = readStomp(t, ws) // The NACKed message is redelivered
+				}
+				acknowledged := !containsString(tt.pending, body)
+				if acknowledged != (frame.command == "ERROR") {
+					t.Fatalf("NACK of %s answered with %s %v", body, frame.command, frame.headers)
+				}
+				if acknowledged {
+					return // The ERROR ended the connection
+				}
+			}
+		})
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
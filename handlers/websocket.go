@@ -4,12 +4,16 @@ import (
 	"context"
 	"log"
 	"net/http"
+	"os"
+	"strings"
 	"time"
 
+	"pub-sub-system/auth"
+	"pub-sub-system/filter"
+	"pub-sub-system/metrics"
 	"pub-sub-system/models"
 	"pub-sub-system/pubsub"
 
-	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
@@ -18,45 +22,120 @@ type WebSocketHandler struct {
 	pubSubSystem *pubsub.PubSubSystem
 	topicManager *pubsub.TopicManager
 	subManager   *pubsub.SubscriberManager
+	upgrader     websocket.Upgrader
+	authorizer   *auth.Authorizer // nil allows every request
+	sessions     *pubsub.SessionStore
 }
 
-// NewWebSocketHandler creates a new WebSocket handler
-func NewWebSocketHandler(pubSubSystem *pubsub.PubSubSystem) *WebSocketHandler {
+// NewWebSocketHandler creates a new WebSocket handler. WS_ALLOWED_ORIGINS is
+// a comma-separated list of origins allowed to open a connection; when it is
+// unset or "*", every origin is allowed.
+func NewWebSocketHandler(pubSubSystem *pubsub.PubSubSystem, authorizer *auth.Authorizer) *WebSocketHandler {
 	return &WebSocketHandler{
 		pubSubSystem: pubSubSystem,
 		topicManager: pubsub.NewTopicManager(),
 		subManager:   pubsub.NewSubscriberManager(),
+		upgrader: websocket.Upgrader{
+			CheckOrigin:  originChecker(os.Getenv("WS_ALLOWED_ORIGINS")),
+			Subprotocols: append(codecSubprotocols, StompSubprotocol),
+		},
+		authorizer: authorizer,
+		sessions:   pubsub.NewSessionStore(),
 	}
 }
 
-// WebSocket upgrader
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true // Allow all origins for development
-	},
+// authorize checks that the connection's principal may perform an action on
+// a topic, sending a FORBIDDEN error if not
+func (h *WebSocketHandler) authorize(conn *wsConn, action, topic, requestID string) bool {
+	if h.authorizer.Allowed(conn.principal, action, topic) {
+		return true
+	}
+	h.sendError(conn, "FORBIDDEN", action+" not allowed on topic "+topic, requestID)
+	return false
 }
 
-// HandleWebSocket handles WebSocket connections
+// originChecker returns a CheckOrigin function accepting the listed origins.
+// Requests without an Origin header come from non-browser clients and are
+// always accepted.
+func originChecker(list string) func(r *http.Request) bool {
+	allowed := make(map[string]bool)
+	for _, origin := range strings.Split(list, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			allowed[strings.ToLower(origin)] = true
+		}
+	}
+	if len(allowed) == 0 || allowed["*"] {
+		return func(r *http.Request) bool { return true }
+	}
+
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		return origin == "" || allowed[strings.ToLower(origin)]
+	}
+}
+
+// HandleWebSocket handles WebSocket connections. The principal authenticated
+// for the upgrade request stays bound to the connection, as does the codec or
+// STOMP negotiated through Sec-WebSocket-Protocol. A session query parameter
+// resumes an earlier connection's session; STOMP connections have none.
 func (h *WebSocketHandler) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+	ws, err := h.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("WebSocket upgrade failed: %v", err)
 		return
 	}
+	conn := &wsConn{
+		Conn:      ws,
+		principal: auth.FromContext(r.Context()),
+		codec:     codecFor(ws.Subprotocol()),
+	}
+	codecName := conn.codec.name
+	if ws.Subprotocol() == StompSubprotocol {
+		conn.stomp = newStompSession()
+		codecName = "stomp"
+	}
 	defer conn.Close()
+	defer h.pubSubSystem.TrackConnection("websocket", codecName)()
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	var session *pubsub.Session
+	if conn.stomp == nil {
+		session = h.openSession(conn, r.URL.Query().Get("session"), ctx)
+	}
+
 	// Start heartbeat goroutine
 	go h.startHeartbeat(conn, ctx)
 
 	// Process incoming messages
-	h.processMessages(conn, ctx)
+	if conn.stomp != nil {
+		h.processStompFrames(conn, ctx)
+	} else {
+		h.processMessages(conn, ctx)
+	}
+
+	// Stop delivery, then drop this connection's subscriptions so consumer
+	// groups rebalance. The session keeps them for a later resume.
+	cancel()
+	subs := h.removeSubscriptions(conn)
+	if session != nil {
+		h.sessions.Detach(session, subs)
+	}
+}
+
+// removeSubscriptions removes every subscription owned by a closed
+// connection and returns them
+func (h *WebSocketHandler) removeSubscriptions(conn *wsConn) []*models.Subscriber {
+	subs := make([]*models.Subscriber, 0)
+	for _, topic := range h.pubSubSystem.ListTopics() {
+		subs = append(subs, h.topicManager.RemoveSubscribersByConn(topic, conn)...)
+	}
+	return append(subs, h.pubSubSystem.RemoveWildcardSubscribersByConn(conn)...)
 }
 
 // startHeartbeat sends periodic heartbeat messages
-func (h *WebSocketHandler) startHeartbeat(conn *websocket.Conn, ctx context.Context) {
+func (h *WebSocketHandler) startHeartbeat(conn *wsConn, ctx context.Context) {
 	ticker := time.NewTicker(30 * time.Second)
 	defer ticker.Stop()
 
@@ -76,7 +155,7 @@ func (h *WebSocketHandler) startHeartbeat(conn *websocket.Conn, ctx context.Cont
 }
 
 // processMessages processes incoming WebSocket messages
-func (h *WebSocketHandler) processMessages(conn *websocket.Conn, ctx context.Context) {
+func (h *WebSocketHandler) processMessages(conn *wsConn, ctx context.Context) {
 	for {
 		var clientMsg models.ClientMessage
 		if err := conn.ReadJSON(&clientMsg); err != nil {
@@ -91,7 +170,7 @@ func (h *WebSocketHandler) processMessages(conn *websocket.Conn, ctx context.Con
 }
 
 // handleClientMessage processes incoming client messages
-func (h *WebSocketHandler) handleClientMessage(conn *websocket.Conn, msg *models.ClientMessage, ctx context.Context) {
+func (h *WebSocketHandler) handleClientMessage(conn *wsConn, msg *models.ClientMessage, ctx context.Context) {
 	switch msg.Type {
 	case "subscribe":
 		h.handleSubscribe(conn, msg, ctx)
@@ -101,18 +180,59 @@ func (h *WebSocketHandler) handleClientMessage(conn *websocket.Conn, msg *models
 		h.handlePublish(conn, msg)
 	case "ping":
 		h.handlePing(conn, msg)
+	case "ack", "nack":
+		h.handleAck(conn, msg)
 	default:
 		h.sendError(conn, "BAD_REQUEST", "Invalid message type", msg.RequestID)
 	}
 }
 
 // handleSubscribe handles subscription requests
-func (h *WebSocketHandler) handleSubscribe(conn *websocket.Conn, msg *models.ClientMessage, ctx context.Context) {
+func (h *WebSocketHandler) handleSubscribe(conn *wsConn, msg *models.ClientMessage, ctx context.Context) {
 	if msg.Topic == "" || msg.ClientID == "" {
 		h.sendError(conn, "BAD_REQUEST", "Topic and client_id are required", msg.RequestID)
 		return
 	}
 
+	if msg.Delivery == "" {
+		msg.Delivery = pubsub.DeliveryAtMostOnce
+	} else if !pubsub.ValidDelivery(msg.Delivery) {
+		h.sendError(conn, "BAD_REQUEST", "delivery must be at_most_once or at_least_once", msg.RequestID)
+		return
+	}
+
+	if msg.Backpressure != "" && !pubsub.ValidBackpressure(msg.Backpressure) {
+		h.sendError(conn, "BAD_REQUEST", "backpressure must be disconnect, drop_newest, drop_oldest or block", msg.RequestID)
+		return
+	}
+	if msg.BlockTimeoutMs < 0 {
+		h.sendError(conn, "BAD_REQUEST", "block_timeout_ms must not be negative", msg.RequestID)
+		return
+	}
+
+	var subFilter models.MessageFilter
+	if msg.Filter != "" {
+		parsed, err := filter.Parse(msg.Filter)
+		if err != nil {
+			h.sendError(conn, "BAD_REQUEST", "Invalid filter: "+err.Error(), msg.RequestID)
+			return
+		}
+		subFilter = parsed
+	}
+
+	if !h.authorize(conn, auth.ActionSubscribe, msg.Topic, msg.RequestID) {
+		return
+	}
+
+	if pubsub.IsPattern(msg.Topic) {
+		if msg.FromOffset != nil {
+			h.sendError(conn, "BAD_REQUEST", "from_offset cannot be used with a topic pattern", msg.RequestID)
+			return
+		}
+		h.handleWildcardSubscribe(conn, msg, subFilter, ctx)
+		return
+	}
+
 	topic, exists := h.pubSubSystem.GetTopic(msg.Topic)
 	if !exists {
 		h.sendError(conn, "TOPIC_NOT_FOUND", "Topic does not exist", msg.RequestID)
@@ -120,13 +240,56 @@ func (h *WebSocketHandler) handleSubscribe(conn *websocket.Conn, msg *models.Cli
 	}
 
 	sub := h.subManager.NewSubscriber(msg.ClientID, msg.Topic, conn)
-	if err := h.topicManager.AddSubscriber(topic, sub); err != nil {
-		h.sendError(conn, "INTERNAL", err.Error(), msg.RequestID)
+	sub.Group = msg.Group
+	sub.Delivery = msg.Delivery
+	sub.AckTimeout = time.Duration(msg.AckTimeoutMs) * time.Millisecond
+	sub.Backpressure = msg.Backpressure
+	sub.BlockTimeout = time.Duration(msg.BlockTimeoutMs) * time.Millisecond
+	sub.Filter = subFilter
+
+	// Attach and snapshot requested history atomically; the processor replays
+	// it ahead of live events
+	history := pubsub.HistoryRequest{FromOffset: msg.FromOffset, LastN: msg.LastN}
+	if err := h.topicManager.AddSubscriberWithHistory(topic, sub, history); err != nil {
+		h.sendErrorFor(conn, err, msg.RequestID)
 		return
 	}
 
+	// Send acknowledgment
+	ack := &models.ServerMessage{
+		Type:      "ack",
+		RequestID: msg.RequestID,
+		Topic:     msg.Topic,
+		Status:    "ok",
+		TS:        time.Now().UTC().Format(time.RFC3339),
+	}
+	conn.WriteJSON(ack)
+
 	// Start message processor
 	h.subManager.StartMessageProcessor(sub, ctx)
+}
+
+// handleWildcardSubscribe handles subscriptions to topic patterns such as
+// "orders.eu.*" or "orders.>". The subscription also covers matching topics
+// created later, and last_n is replayed per matching topic.
+func (h *WebSocketHandler) handleWildcardSubscribe(conn *wsConn, msg *models.ClientMessage, subFilter models.MessageFilter, ctx context.Context) {
+	if err := pubsub.ValidatePattern(msg.Topic); err != nil {
+		h.sendError(conn, "BAD_REQUEST", "Invalid topic pattern: "+err.Error(), msg.RequestID)
+		return
+	}
+
+	sub := h.subManager.NewSubscriber(pubsub.SubscriberKey(msg.ClientID, msg.Topic), msg.Topic, conn)
+	sub.Group = msg.Group
+	sub.Delivery = msg.Delivery
+	sub.AckTimeout = time.Duration(msg.AckTimeoutMs) * time.Millisecond
+	sub.Backpressure = msg.Backpressure
+	sub.BlockTimeout = time.Duration(msg.BlockTimeoutMs) * time.Millisecond
+	sub.Filter = subFilter
+	// Attach and snapshot last_n from each matching topic atomically
+	if err := h.pubSubSystem.AddWildcardSubscriberWithHistory(sub, pubsub.HistoryRequest{LastN: msg.LastN}); err != nil {
+		h.sendErrorFor(conn, err, msg.RequestID)
+		return
+	}
 
 	// Send acknowledgment
 	ack := &models.ServerMessage{
@@ -138,35 +301,31 @@ func (h *WebSocketHandler) handleSubscribe(conn *websocket.Conn, msg *models.Cli
 	}
 	conn.WriteJSON(ack)
 
-	// Send historical messages if requested
-	if msg.LastN > 0 {
-		historicalMessages := h.topicManager.GetLastMessages(topic, msg.LastN)
-		for _, histMsg := range historicalMessages {
-			eventMsg := &models.ServerMessage{
-				Type:    "event",
-				Topic:   msg.Topic,
-				Message: histMsg,
-				TS:      time.Now().UTC().Format(time.RFC3339),
-			}
-			conn.WriteJSON(eventMsg)
-		}
-	}
+	// Start message processor
+	h.subManager.StartMessageProcessor(sub, ctx)
 }
 
 // handleUnsubscribe handles unsubscription requests
-func (h *WebSocketHandler) handleUnsubscribe(conn *websocket.Conn, msg *models.ClientMessage) {
+func (h *WebSocketHandler) handleUnsubscribe(conn *wsConn, msg *models.ClientMessage) {
 	if msg.Topic == "" || msg.ClientID == "" {
 		h.sendError(conn, "BAD_REQUEST", "Topic and client_id are required", msg.RequestID)
 		return
 	}
 
-	topic, exists := h.pubSubSystem.GetTopic(msg.Topic)
-	if !exists {
-		h.sendError(conn, "TOPIC_NOT_FOUND", "Topic does not exist", msg.RequestID)
-		return
-	}
+	if pubsub.IsPattern(msg.Topic) {
+		if !h.pubSubSystem.RemoveWildcardSubscriber(msg.ClientID, msg.Topic, conn) {
+			h.sendError(conn, "BAD_REQUEST", "No subscription for topic pattern", msg.RequestID)
+			return
+		}
+	} else {
+		topic, exists := h.pubSubSystem.GetTopic(msg.Topic)
+		if !exists {
+			h.sendError(conn, "TOPIC_NOT_FOUND", "Topic does not exist", msg.RequestID)
+			return
+		}
 
-	h.topicManager.RemoveSubscriber(topic, msg.ClientID)
+		h.topicManager.RemoveSubscriber(topic, msg.ClientID, conn)
+	}
 
 	ack := &models.ServerMessage{
 		Type:      "ack",
@@ -179,34 +338,25 @@ func (h *WebSocketHandler) handleUnsubscribe(conn *websocket.Conn, msg *models.C
 }
 
 // handlePublish handles publish requests
-func (h *WebSocketHandler) handlePublish(conn *websocket.Conn, msg *models.ClientMessage) {
+func (h *WebSocketHandler) handlePublish(conn *wsConn, msg *models.ClientMessage) {
 	if msg.Topic == "" || msg.Message == nil {
 		h.sendError(conn, "BAD_REQUEST", "Topic and message are required", msg.RequestID)
 		return
 	}
 
-	// Validate message ID
-	if msg.Message.ID == "" {
-		msg.Message.ID = uuid.New().String()
-	} else {
-		// Validate UUID format if provided
-		if _, err := uuid.Parse(msg.Message.ID); err != nil {
-			h.sendError(conn, "BAD_REQUEST", "message.id must be a valid UUID", msg.RequestID)
-			return
-		}
+	if !h.authorize(conn, auth.ActionPublish, msg.Topic, msg.RequestID) {
+		return
 	}
 
-	topic, exists := h.pubSubSystem.GetTopic(msg.Topic)
-	if !exists {
-		h.sendError(conn, "TOPIC_NOT_FOUND", "Topic does not exist", msg.RequestID)
+	if err := pubsub.PrepareMessage(msg.Message); err != nil {
+		h.sendErrorFor(conn, err, msg.RequestID)
 		return
 	}
 
-	// Add message to topic history
-	h.topicManager.AddMessage(topic, msg.Message)
-
-	// Broadcast to all subscribers
-	h.topicManager.Broadcast(topic, msg.Message)
+	if err := h.pubSubSystem.Publish(msg.Topic, msg.Message); err != nil {
+		h.sendErrorFor(conn, err, msg.RequestID)
+		return
+	}
 
 	// Send acknowledgment
 	ack := &models.ServerMessage{
@@ -219,8 +369,72 @@ func (h *WebSocketHandler) handlePublish(conn *websocket.Conn, msg *models.Clien
 	conn.WriteJSON(ack)
 }
 
+// sendErrorFor sends the error frame for a failed operation that
+// returned a *models.Error
+func (h *WebSocketHandler) sendErrorFor(conn *wsConn, err error, requestID string) {
+	e, ok := err.(*models.Error)
+	if !ok {
+		e = &models.Error{Code: "INTERNAL", Message: err.Error()}
+	}
+	h.writeError(conn, e, requestID)
+}
+
+// handleAck handles ack and nack requests for at-least-once subscriptions.
+// The message is identified by message.id on the subscription named by
+// topic and client_id.
+func (h *WebSocketHandler) handleAck(conn *wsConn, msg *models.ClientMessage) {
+	if msg.Topic == "" || msg.ClientID == "" || msg.Message == nil || msg.Message.ID == "" {
+		h.sendError(conn, "BAD_REQUEST", "Topic, client_id and message.id are required", msg.RequestID)
+		return
+	}
+
+	var (
+		sub    *models.Subscriber
+		exists bool
+	)
+	if pubsub.IsPattern(msg.Topic) {
+		sub, exists = h.pubSubSystem.GetWildcardSubscriber(msg.ClientID, msg.Topic)
+	} else if topic, found := h.pubSubSystem.GetTopic(msg.Topic); found {
+		sub, exists = h.topicManager.GetSubscriber(topic, msg.ClientID)
+	}
+	if !exists || sub.Conn != models.WebSocketConn(conn) {
+		h.sendError(conn, "BAD_REQUEST", "No subscription for topic and client_id", msg.RequestID)
+		return
+	}
+
+	if sub.Delivery != pubsub.DeliveryAtLeastOnce {
+		h.sendError(conn, "BAD_REQUEST", "Subscription does not use at_least_once delivery", msg.RequestID)
+		return
+	}
+
+	var ok bool
+	switch {
+	case msg.Type == "ack" && msg.Cumulative:
+		ok = h.subManager.AckThrough(sub, msg.Message.ID)
+	case msg.Type == "ack":
+		ok = h.subManager.Ack(sub, msg.Message.ID)
+	case msg.Cumulative:
+		ok = h.subManager.NackThrough(sub, msg.Message.ID)
+	default:
+		ok = h.subManager.Nack(sub, msg.Message.ID)
+	}
+	if !ok {
+		h.sendError(conn, "BAD_REQUEST", "Message is not awaiting acknowledgement", msg.RequestID)
+		return
+	}
+
+	ack := &models.ServerMessage{
+		Type:      "ack",
+		RequestID: msg.RequestID,
+		Topic:     msg.Topic,
+		Status:    "ok",
+		TS:        time.Now().UTC().Format(time.RFC3339),
+	}
+	conn.WriteJSON(ack)
+}
+
 // handlePing handles ping requests
-func (h *WebSocketHandler) handlePing(conn *websocket.Conn, msg *models.ClientMessage) {
+func (h *WebSocketHandler) handlePing(conn *wsConn, msg *models.ClientMessage) {
 	pong := &models.ServerMessage{
 		Type:      "pong",
 		RequestID: msg.RequestID,
@@ -230,15 +444,18 @@ func (h *WebSocketHandler) handlePing(conn *websocket.Conn, msg *models.ClientMe
 }
 
 // sendError sends an error message to the client
-func (h *WebSocketHandler) sendError(conn *websocket.Conn, code, message, requestID string) {
+func (h *WebSocketHandler) sendError(conn *wsConn, code, message, requestID string) {
+	h.writeError(conn, &models.Error{Code: code, Message: message}, requestID)
+}
+
+// writeError sends an error frame and counts it
+func (h *WebSocketHandler) writeError(conn *wsConn, e *models.Error, requestID string) {
 	errorMsg := &models.ServerMessage{
 		Type:      "error",
 		RequestID: requestID,
-		Error: &models.Error{
-			Code:    code,
-			Message: message,
-		},
-		TS: time.Now().UTC().Format(time.RFC3339),
+		Error:     e,
+		TS:        time.Now().UTC().Format(time.RFC3339),
 	}
+	metrics.Errors.WithLabelValues(e.Code).Inc()
 	conn.WriteJSON(errorMsg)
 }
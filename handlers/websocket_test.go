@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"pub-sub-system/models"
+	"pub-sub-system/pubsub"
+)
+
+// startTestServer serves the WebSocket and REST endpoints without
+// authentication or authorization
+func startTestServer(t *testing.T) (*pubsub.PubSubSystem, *httptest.Server) {
+	t.Helper()
+	ps := pubsub.NewPubSubSystem()
+	wsHandler := NewWebSocketHandler(ps, nil)
+	httpHandler := NewHTTPHandler(ps, nil)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", wsHandler.HandleWebSocket)
+	mux.HandleFunc("/topics/", httpHandler.HandleTopic)
+	mux.HandleFunc("/topics", httpHandler.HandleTopics)
+	mux.HandleFunc("/schemas", httpHandler.HandleSchemas)
+	mux.HandleFunc("/schemas/", httpHandler.HandleSchemas)
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(func() {
+		srv.Close()
+		ps.Close()
+	})
+	return ps, srv
+}
+
+// dialWebSocket opens /ws, offering the given subprotocols
+func dialWebSocket(t *testing.T, srv *httptest.Server, subprotocols ...string) *websocket.Conn {
+	t.Helper()
+	dialer := websocket.Dialer{Subprotocols: subprotocols}
+	ws, _, err := dialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatalf("WebSocket dial error = %v", err)
+	}
+	t.Cleanup(func() { ws.Close() })
+	return ws
+}
+
+// readServerMessage reads JSON server messages until one of the given type
+// or an error arrives
+func readServerMessage(t *testing.T, ws *websocket.Conn, kind string) models.ServerMessage {
+	t.Helper()
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		var msg models.ServerMessage
+		if err := ws.ReadJSON(&msg); err != nil {
+			t.Fatalf("WebSocket read waiting for %s: %v", kind, err)
+		}
+		if msg.Type == kind || msg.Type == "error" {
+			return msg
+		}
+	}
+}
+
+// request sends a client message and returns the reply to it
+func request(t *testing.T, ws *websocket.Conn, msg models.ClientMessage) models.ServerMessage {
+	t.Helper()
+	if err := ws.WriteJSON(msg); err != nil {
+		t.Fatalf("WebSocket write error = %v", err)
+	}
+	return readServerMessage(t, ws, "ack")
+}
+
+func TestSubscribeRejectsMalformedFilter(t *testing.T) {
+	ps, srv := startTestServer(t)
+	if _, err := ps.NewTopic("orders"); err != nil {
+		t.Fatalf("NewTopic() error = %v", err)
+	}
+	ws := dialWebSocket(t, srv)
+
+	for _, expr := range []string{"payload.amount >", "amount > 1", "headers.region == 'eu"} {
+		reply := request(t, ws, models.ClientMessage{Type: "subscribe", Topic: "orders", ClientID: "c1", Filter: expr, RequestID: "bad"})
+		if reply.Type != "error" || reply.Error.Code != "BAD_REQUEST" || !strings.HasPrefix(reply.Error.Message, "Invalid filter: ") {
+			t.Errorf("subscribe with filter %q replied %s %+v, want BAD_REQUEST Invalid filter", expr, reply.Type, reply.Error)
+		}
+	}
+
+	// A valid filter subscribes and only matching messages are delivered
+	reply := request(t, ws, models.ClientMessage{Type: "subscribe", Topic: "orders", ClientID: "c1", Filter: "headers.region == 'eu' and payload.amount >= 100", RequestID: "ok"})
+	if reply.Type != "ack" {
+		t.Fatalf("subscribe with a valid filter replied %+v", reply.Error)
+	}
+	for _, msg := range []*models.Message{
+		{Headers: map[string]string{"region": "eu"}, Payload: map[string]interface{}{"amount": 5.0, "name": "small"}},
+		{Headers: map[string]string{"region": "us"}, Payload: map[string]interface{}{"amount": 500.0, "name": "us"}},
+		{Headers: map[string]string{"region": "eu"}, Payload: map[string]interface{}{"amount": 500.0, "name": "match"}},
+	} {
+		if err := pubsub.PrepareMessage(msg); err != nil {
+			t.Fatalf("PrepareMessage() error = %v", err)
+		}
+		if err := ps.Publish("orders", msg); err != nil {
+			t.Fatalf("Publish() error = %v", err)
+		}
+	}
+	event := readServerMessage(t, ws, "event")
+	if event.Type != "event" {
+		t.Fatalf("waiting for an event got error %+v", event.Error)
+	}
+	if payload, ok := event.Message.Payload.(map[string]interface{}); !ok || payload["name"] != "match" {
+		t.Errorf("first delivery = %v, want the matching event", event.Message.Payload)
+	}
+}
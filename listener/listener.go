@@ -0,0 +1,156 @@
+// Package listener holds what the TCP protocol adapters, MQTT and Redis,
+// share: accepting and tracking connections, creating topics on first use
+// and converting payloads between raw bytes and message payloads
+package listener
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"sync"
+	"unicode/utf8"
+
+	"pub-sub-system/pubsub"
+)
+
+// Listener accepts connections and tracks them so Close can end them. The
+// zero value is ready to use.
+type Listener struct {
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closed   bool
+}
+
+// Serve accepts connections on lis until Close is called, running handle on
+// each in its own goroutine. The connection is closed once handle returns.
+func (l *Listener) Serve(lis net.Listener, handle func(conn net.Conn)) error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		lis.Close()
+		return net.ErrClosed
+	}
+	l.listener = lis
+	l.mu.Unlock()
+
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			if l.Closed() {
+				return nil
+			}
+			return err
+		}
+
+		if !l.track(conn) {
+			conn.Close()
+			continue
+		}
+		go func() {
+			defer l.release(conn)
+			handle(conn)
+		}()
+	}
+}
+
+// Close stops accepting connections and closes every tracked connection
+func (l *Listener) Close() error {
+	l.mu.Lock()
+	l.closed = true
+	lis := l.listener
+	conns := make([]net.Conn, 0, len(l.conns))
+	for conn := range l.conns {
+		conns = append(conns, conn)
+	}
+	l.mu.Unlock()
+
+	var err error
+	if lis != nil {
+		err = lis.Close()
+	}
+	for _, conn := range conns {
+		conn.Close()
+	}
+	return err
+}
+
+// Closed reports whether Close has been called
+func (l *Listener) Closed() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.closed
+}
+
+// track registers an accepted connection so Close can end it
+func (l *Listener) track(conn net.Conn) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return false
+	}
+	if l.conns == nil {
+		l.conns = make(map[net.Conn]struct{})
+	}
+	l.conns[conn] = struct{}{}
+	return true
+}
+
+// release closes a connection whose handler has returned and stops tracking
+// it
+func (l *Listener) release(conn net.Conn) {
+	conn.Close()
+	l.mu.Lock()
+	delete(l.conns, conn)
+	l.mu.Unlock()
+}
+
+// EnsureTopic makes sure a topic exists, creating it with the server's
+// default settings if authorize allows it. MQTT topics and Redis channels
+// need no creating, so the first publish or subscribe to a name creates the
+// topic.
+func EnsureTopic(ps *pubsub.PubSubSystem, name string, authorize func() error) error {
+	if _, exists := ps.GetTopic(name); exists {
+		return nil
+	}
+	if err := authorize(); err != nil {
+		return err
+	}
+
+	if _, err := ps.NewTopic(name); err != nil && err.Error() != "topic already exists" {
+		log.Printf("Could not create topic %s on first use: %v", name, err)
+		return err
+	}
+	return nil
+}
+
+// PayloadValue converts raw bytes received from a client to a message
+// payload. JSON documents keep their structure so WebSocket and gRPC clients
+// see them as JSON, other UTF-8 text becomes a string and anything else is
+// kept as bytes.
+func PayloadValue(data []byte) interface{} {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err == nil {
+		return v
+	}
+	if utf8.Valid(data) {
+		return string(data)
+	}
+	return data
+}
+
+// PayloadBytes converts a message payload to the raw bytes sent to a
+// client: strings and bytes are sent as they are and anything else is
+// encoded as JSON
+func PayloadBytes(payload interface{}) ([]byte, error) {
+	switch p := payload.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []byte(p), nil
+	case []byte:
+		return p, nil
+	}
+	return json.Marshal(payload)
+}
@@ -0,0 +1,95 @@
+package listener
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPayloadConversion(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  []byte
+		value interface{}
+		back  []byte // Bytes sent back to a client, when they differ from data
+	}{
+		{"JSON object", []byte(`{"a":1}`), map[string]interface{}{"a": float64(1)}, nil},
+		{"JSON number", []byte(`42`), float64(42), nil},
+		{"JSON string", []byte(`"quoted"`), "quoted", []byte("quoted")},
+		{"text", []byte("hello world"), "hello world", nil},
+		{"binary", []byte{0xff, 0x00, 0xfe}, []byte{0xff, 0x00, 0xfe}, nil},
+	}
+	for _, tt := range tests {
+		value := PayloadValue(tt.data)
+		if !reflect.DeepEqual(value, tt.value) {
+			t.Errorf("%s: PayloadValue() = %#v, want %#v", tt.name, value, tt.value)
+		}
+
+		want := tt.back
+		if want == nil {
+			want = tt.data
+		}
+		if got, err := PayloadBytes(value); err != nil || !bytes.Equal(got, want) {
+			t.Errorf("%s: PayloadBytes() = %q, %v, want %q", tt.name, got, err, want)
+		}
+	}
+
+	if got, err := PayloadBytes(nil); err != nil || got != nil {
+		t.Errorf("PayloadBytes(nil) = %q, %v, want nothing", got, err)
+	}
+}
+
+func TestCloseEndsServeAndConnections(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() error = %v", err)
+	}
+
+	var l Listener
+	handled := make(chan struct{})
+	served := make(chan error, 1)
+	go func() {
+		served <- l.Serve(lis, func(conn net.Conn) {
+			close(handled)
+			conn.Read(make([]byte, 1)) // Until the connection is closed
+		})
+	}()
+
+	conn, err := net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatalf("net.Dial() error = %v", err)
+	}
+	defer conn.Close()
+	<-handled
+
+	if err := l.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("Serve() error = %v after Close", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Serve() did not return after Close")
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Error("tracked connection stayed open after Close")
+	}
+	if !l.Closed() {
+		t.Error("Closed() = false after Close")
+	}
+
+	// A closed listener refuses to serve again
+	lis, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() error = %v", err)
+	}
+	if err := l.Serve(lis, func(net.Conn) {}); err != net.ErrClosed {
+		t.Errorf("Serve() after Close error = %v, want net.ErrClosed", err)
+	}
+}
@@ -3,39 +3,92 @@ package main
 import (
 	"context"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"pub-sub-system/auth"
+	"pub-sub-system/cluster"
+	"pub-sub-system/env"
+	"pub-sub-system/grpcapi"
 	"pub-sub-system/handlers"
+	"pub-sub-system/metrics"
 	"pub-sub-system/middleware"
+	"pub-sub-system/mqtt"
 	"pub-sub-system/pubsub"
+	"pub-sub-system/resp"
 )
 
 func main() {
 	// Initialize the pub/sub system
 	pubSubSystem := pubsub.NewPubSubSystem()
 
+	// Authentication is enabled when API keys or JWT keys are configured
+	authenticator, err := auth.FromEnv()
+	if err != nil {
+		log.Fatalf("Failed to configure authentication: %v", err)
+	}
+	if authenticator == nil {
+		log.Println("Authentication disabled: no API keys or JWT keys configured")
+	}
+
+	// Authorization is enabled when a policy file is configured. It is
+	// reloaded when the file changes or on SIGHUP.
+	authorizer, err := auth.AuthorizerFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to load authorization policy: %v", err)
+	}
+	stopPolicyWatch := make(chan struct{})
+	if authorizer != nil {
+		go authorizer.Watch(env.Interval("AUTH_POLICY_RELOAD_INTERVAL_MS", 5000), stopPolicyWatch)
+		go reloadOnHangup(authorizer)
+	}
+
+	metrics.RegisterSource(pubSubSystem)
+
 	// Initialize handlers
-	wsHandler := handlers.NewWebSocketHandler(pubSubSystem)
-	httpHandler := handlers.NewHTTPHandler(pubSubSystem)
+	wsHandler := handlers.NewWebSocketHandler(pubSubSystem, authorizer)
+	httpHandler := handlers.NewHTTPHandler(pubSubSystem, authorizer)
 
 	// Create a new mux for better routing
 	mux := http.NewServeMux()
 
 	// Set up HTTP routes - order matters in Go ServeMux (most specific first)
 	mux.HandleFunc("/ws", wsHandler.HandleWebSocket)
-	mux.HandleFunc("/topics/", httpHandler.HandleDeleteTopic) // DELETE for delete (more specific first)
-	mux.HandleFunc("/topics", httpHandler.HandleTopics)       // Combined handler for POST/GET
+	mux.HandleFunc("/topics/", httpHandler.HandleTopic) // DELETE /topics/{name}, POST .../messages, GET .../events
+	mux.HandleFunc("/topics", httpHandler.HandleTopics) // Combined handler for POST/GET
 	mux.HandleFunc("/health", httpHandler.HandleHealth)
 	mux.HandleFunc("/stats", httpHandler.HandleStats)
+	mux.HandleFunc("/metrics", httpHandler.HandleMetrics)
+	mux.HandleFunc("/schemas", httpHandler.HandleSchemas)
+	mux.HandleFunc("/schemas/", httpHandler.HandleSchemas)
 	mux.HandleFunc("/api", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		w.Write([]byte(`{"message": "API endpoint working!", "status": "success"}`))
 	}) // Test API endpoint
 
+	handler := middleware.Auth(authenticator, mux)
+
+	// Cluster mode replicates topics to static peers and forwards publishes
+	// to them. Node-to-node requests bypass client authentication and are
+	// checked against the cluster secret instead.
+	var node *cluster.Node
+	if opts, enabled := cluster.OptionsFromEnv(); enabled {
+		opts.RequireSecret = authenticator != nil || authorizer != nil
+		node, err = cluster.New(pubSubSystem, opts)
+		if err != nil {
+			log.Fatalf("Failed to start cluster mode: %v", err)
+		}
+		root := http.NewServeMux()
+		root.Handle("/cluster/", node.Handler())
+		root.Handle("/", handler)
+		handler = root
+		log.Printf("Cluster mode enabled as node %s with peers %v", opts.NodeID, opts.Peers)
+	}
+
 	// Start server - use Railway's PORT environment variable
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -44,11 +97,15 @@ func main() {
 	port = ":" + port
 	log.Printf("Starting Pub/Sub server on port %s", port)
 
-	// Create HTTP server with graceful shutdown
+	// Create HTTP server with graceful shutdown. Request contexts derive from
+	// streamCtx so long-lived SSE streams end when shutdown begins.
+	streamCtx, stopStreams := context.WithCancel(context.Background())
 	server := &http.Server{
-		Addr:    port,
-		Handler: middleware.CORS(mux),
+		Addr:        port,
+		Handler:     middleware.CORS(handler),
+		BaseContext: func(net.Listener) context.Context { return streamCtx },
 	}
+	server.RegisterOnShutdown(stopStreams)
 
 	// Start server in goroutine
 	go func() {
@@ -57,12 +114,61 @@ func main() {
 		}
 	}()
 
+	// The gRPC API listens on its own port when GRPC_PORT is set
+	var grpcServer *grpcapi.Server
+	if grpcPort := os.Getenv("GRPC_PORT"); grpcPort != "" {
+		lis, err := net.Listen("tcp", ":"+grpcPort)
+		if err != nil {
+			log.Fatalf("Failed to listen for gRPC on port %s: %v", grpcPort, err)
+		}
+		grpcServer = grpcapi.New(pubSubSystem, authenticator, authorizer)
+		log.Printf("Starting gRPC server on port :%s", grpcPort)
+		go func() {
+			if err := grpcServer.Serve(lis); err != nil {
+				log.Fatalf("gRPC server error: %v", err)
+			}
+		}()
+	}
+
+	// The MQTT listener is enabled when MQTT_PORT is set
+	var mqttServer *mqtt.Server
+	if mqttPort := os.Getenv("MQTT_PORT"); mqttPort != "" {
+		lis, err := net.Listen("tcp", ":"+mqttPort)
+		if err != nil {
+			log.Fatalf("Failed to listen for MQTT on port %s: %v", mqttPort, err)
+		}
+		mqttServer = mqtt.New(pubSubSystem, authenticator, authorizer)
+		log.Printf("Starting MQTT listener on port :%s", mqttPort)
+		go func() {
+			if err := mqttServer.Serve(lis); err != nil {
+				log.Fatalf("MQTT listener error: %v", err)
+			}
+		}()
+	}
+
+	// The Redis listener is enabled when REDIS_PORT is set
+	var redisServer *resp.Server
+	if redisPort := os.Getenv("REDIS_PORT"); redisPort != "" {
+		lis, err := net.Listen("tcp", ":"+redisPort)
+		if err != nil {
+			log.Fatalf("Failed to listen for Redis clients on port %s: %v", redisPort, err)
+		}
+		redisServer = resp.New(pubSubSystem, authenticator, authorizer)
+		log.Printf("Starting Redis listener on port :%s", redisPort)
+		go func() {
+			if err := redisServer.Serve(lis); err != nil {
+				log.Fatalf("Redis listener error: %v", err)
+			}
+		}()
+	}
+
 	// Wait for interrupt signal
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
 	log.Println("Shutting down server...")
+	close(stopPolicyWatch)
 
 	// Graceful shutdown with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
@@ -72,5 +178,38 @@ func main() {
 		log.Fatal("Server forced to shutdown:", err)
 	}
 
+	if grpcServer != nil {
+		grpcServer.Shutdown(ctx)
+	}
+
+	if mqttServer != nil {
+		mqttServer.Close()
+	}
+
+	if redisServer != nil {
+		redisServer.Close()
+	}
+
+	if node != nil {
+		node.Close()
+	}
+
+	if err := pubSubSystem.Close(); err != nil {
+		log.Printf("Error closing write-ahead log: %v", err)
+	}
+
 	log.Println("Server exited gracefully")
 }
+
+// reloadOnHangup reloads the authorization policy on every SIGHUP
+func reloadOnHangup(authorizer *auth.Authorizer) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	for range hangup {
+		if err := authorizer.Reload(); err != nil {
+			log.Printf("Keeping previous authorization policy: %v", err)
+		} else {
+			log.Println("Reloaded authorization policy")
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"pub-sub-system/env"
+)
+
+// TopicSnapshot is the state of one topic at scrape time
+type TopicSnapshot struct {
+	Name        string
+	Subscribers []SubscriberSnapshot
+}
+
+// SubscriberSnapshot is the state of one subscriber at scrape time
+type SubscriberSnapshot struct {
+	ID         string
+	QueueDepth int
+}
+
+// Source provides the state reported by the gauges
+type Source interface {
+	MetricsSnapshot() []TopicSnapshot
+}
+
+var (
+	topicsDesc = prometheus.NewDesc(
+		"pubsub_topics", "Number of topics.", nil, nil)
+	subscribersDesc = prometheus.NewDesc(
+		"pubsub_subscribers", "Number of subscribers, by topic.", []string{"topic"}, nil)
+	queueDepthDesc = prometheus.NewDesc(
+		"pubsub_subscriber_queue_depth", "Messages waiting in a subscriber's queue.", []string{"topic", "subscriber"}, nil)
+)
+
+// collector reports gauges computed from a Source at scrape time
+type collector struct {
+	source         Source
+	maxQueueSeries int
+}
+
+// RegisterSource registers gauges for topics, subscribers and per-subscriber
+// queue depth. At most METRICS_MAX_SUBSCRIBER_SERIES queue depth series are
+// reported per scrape.
+func RegisterSource(source Source) {
+	Registry.MustRegister(&collector{
+		source:         source,
+		maxQueueSeries: env.Int("METRICS_MAX_SUBSCRIBER_SERIES", 1000),
+	})
+}
+
+// Describe implements prometheus.Collector
+func (c *collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- topicsDesc
+	ch <- subscribersDesc
+	ch <- queueDepthDesc
+}
+
+// Collect implements prometheus.Collector
+func (c *collector) Collect(ch chan<- prometheus.Metric) {
+	topics := c.source.MetricsSnapshot()
+	ch <- prometheus.MustNewConstMetric(topicsDesc, prometheus.GaugeValue, float64(len(topics)))
+
+	// Topics beyond the label limit are summed under OtherTopic
+	subscribers := make(map[string]int)
+	queueSeries := 0
+	for _, topic := range topics {
+		label := TopicLabel(topic.Name)
+		subscribers[label] += len(topic.Subscribers)
+
+		if label == OtherTopic {
+			continue
+		}
+		for _, sub := range topic.Subscribers {
+			if queueSeries >= c.maxQueueSeries {
+				break
+			}
+			ch <- prometheus.MustNewConstMetric(queueDepthDesc, prometheus.GaugeValue, float64(sub.QueueDepth), label, sub.ID)
+			queueSeries++
+		}
+	}
+
+	for label, count := range subscribers {
+		ch <- prometheus.MustNewConstMetric(subscribersDesc, prometheus.GaugeValue, float64(count), label)
+	}
+}
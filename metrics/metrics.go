@@ -0,0 +1,184 @@
+package metrics
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"pub-sub-system/env"
+)
+
+// OtherTopic is the label used for topics beyond the cardinality limit and
+// for topics that no longer exist. It contains a wildcard, so no topic can be
+// named after it.
+const OtherTopic = "<other>"
+
+// Registry holds every metric the server exports
+var Registry = prometheus.NewRegistry()
+
+var (
+	// Publishes counts messages accepted by topics
+	Publishes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pubsub_publishes_total",
+		Help: "Messages published, by topic.",
+	}, []string{"topic"})
+
+	// Deliveries counts events written to subscribers, redeliveries included
+	Deliveries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pubsub_deliveries_total",
+		Help: "Events written to subscribers, by topic.",
+	}, []string{"topic"})
+
+	// SlowConsumerDisconnects counts subscribers disconnected on overflow
+	SlowConsumerDisconnects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pubsub_slow_consumer_disconnects_total",
+		Help: "Subscribers disconnected because their queue overflowed, by topic.",
+	}, []string{"topic"})
+
+	// Dropped counts messages discarded by backpressure policies
+	Dropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pubsub_dropped_total",
+		Help: "Messages dropped by backpressure policies, by topic.",
+	}, []string{"topic"})
+
+	// Expired counts messages discarded because their TTL elapsed
+	Expired = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pubsub_expired_total",
+		Help: "Messages discarded from history or subscriber queues after expiring, by topic.",
+	}, []string{"topic"})
+
+	// DeadLetters counts messages copied to dead-letter topics
+	DeadLetters = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pubsub_dead_letters_total",
+		Help: "Messages copied to a dead-letter topic, by original topic and reason.",
+	}, []string{"topic", "reason"})
+
+	// Errors counts error frames and error responses by protocol error code
+	Errors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pubsub_errors_total",
+		Help: "Protocol errors returned to clients, by code.",
+	}, []string{"code"})
+
+	// Connections counts open client connections
+	Connections = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pubsub_connections",
+		Help: "Open client connections, by protocol and codec.",
+	}, []string{"protocol", "codec"})
+
+	// PublishToWrite measures the time from a message being stored to it
+	// being written to a live subscriber
+	PublishToWrite = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "pubsub_publish_to_write_seconds",
+		Help:    "Latency from publish to the event being written to a subscriber, by topic.",
+		Buckets: prometheus.ExponentialBuckets(0.0005, 2, 16),
+	}, []string{"topic"})
+)
+
+func init() {
+	Registry.MustRegister(
+		Publishes,
+		Deliveries,
+		SlowConsumerDisconnects,
+		Dropped,
+		Expired,
+		DeadLetters,
+		Errors,
+		Connections,
+		PublishToWrite,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// Handler serves the registry in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// topicLabels bounds the number of distinct topic label values. Existing
+// topics are admitted in the order they are first seen; later ones share
+// OtherTopic.
+var topicLabels = newLabelLimiter(env.Int("METRICS_MAX_TOPICS", 100))
+
+// TopicLabel returns the label value to use for a topic
+func TopicLabel(topic string) string {
+	return topicLabels.label(topic)
+}
+
+// AddTopic marks a topic as existing so that it can be given its own label
+func AddTopic(topic string) {
+	topicLabels.add(topic)
+}
+
+// ForgetTopic removes a deleted topic's series and frees its label slot.
+// Counts recorded for it afterwards go to OtherTopic.
+func ForgetTopic(topic string) {
+	if !topicLabels.forget(topic) {
+		return
+	}
+	for _, vec := range []*prometheus.MetricVec{
+		Publishes.MetricVec,
+		Deliveries.MetricVec,
+		SlowConsumerDisconnects.MetricVec,
+		Dropped.MetricVec,
+		Expired.MetricVec,
+		PublishToWrite.MetricVec,
+	} {
+		vec.DeleteLabelValues(topic)
+	}
+	DeadLetters.DeletePartialMatch(prometheus.Labels{"topic": topic})
+}
+
+// labelLimiter admits up to max distinct label values out of the values
+// added to it
+type labelLimiter struct {
+	mu     sync.Mutex
+	max    int
+	known  map[string]bool // Values that may be admitted
+	values map[string]bool // Admitted values
+}
+
+func newLabelLimiter(max int) *labelLimiter {
+	return &labelLimiter{max: max, known: make(map[string]bool), values: make(map[string]bool)}
+}
+
+// add makes a value eligible for admission
+func (l *labelLimiter) add(value string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.known[value] = true
+}
+
+// label returns value if it is admitted, or OtherTopic if it is unknown or
+// the limit is reached
+func (l *labelLimiter) label(value string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.values[value] {
+		return value
+	}
+	if !l.known[value] || len(l.values) >= l.max {
+		return OtherTopic
+	}
+	l.values[value] = true
+	return value
+}
+
+// forget removes a value, so that it is no longer admitted, and reports
+// whether it had been admitted
+func (l *labelLimiter) forget(value string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.known, value)
+	if !l.values[value] {
+		return false
+	}
+	delete(l.values, value)
+	return true
+}
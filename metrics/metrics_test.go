@@ -0,0 +1,40 @@
+package metrics
+
+import "testing"
+
+func TestLabelLimiterAdmitsKnownValuesUpToLimit(t *testing.T) {
+	l := newLabelLimiter(2)
+	for _, topic := range []string{"a", "b", "c"} {
+		l.add(topic)
+	}
+
+	if got := l.label("a"); got != "a" {
+		t.Fatalf("label(a) = %q, want a", got)
+	}
+	if got := l.label("unknown"); got != OtherTopic {
+		t.Fatalf("label(unknown) = %q, want %q", got, OtherTopic)
+	}
+	if got := l.label("b"); got != "b" {
+		t.Fatalf("label(b) = %q, want b", got)
+	}
+	if got := l.label("c"); got != OtherTopic {
+		t.Fatalf("label(c) beyond the limit = %q, want %q", got, OtherTopic)
+	}
+}
+
+func TestLabelLimiterForgetIsNotUndoneByLateLabel(t *testing.T) {
+	l := newLabelLimiter(1)
+	l.add("a")
+	l.add("b")
+	l.label("a")
+
+	if !l.forget("a") {
+		t.Fatal("forget(a) = false, want true")
+	}
+	if got := l.label("a"); got != OtherTopic {
+		t.Fatalf("label(a) after forget = %q, want %q", got, OtherTopic)
+	}
+	if got := l.label("b"); got != "b" {
+		t.Fatalf("label(b) = %q, want the freed slot", got)
+	}
+}
@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"net/http"
+
+	"pub-sub-system/auth"
+)
+
+// publicPaths are reachable without credentials so load balancers can probe
+// the server
+var publicPaths = map[string]bool{
+	"/":       true,
+	"/health": true,
+}
+
+// Auth authenticates every request except those to public paths and binds
+// the principal to the request context. A nil authenticator disables
+// authentication.
+func Auth(authenticator auth.Authenticator, next http.Handler) http.Handler {
+	if authenticator == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if publicPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		principal, err := authenticator.Authenticate(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="pubsub"`)
+			http.Error(w, "Unauthorized: "+err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), principal)))
+	})
+}
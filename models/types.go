@@ -7,18 +7,64 @@ import (
 
 // Message represents a published message
 type Message struct {
-	ID      string      `json:"id"`
-	Payload interface{} `json:"payload"`
+	ID          string            `json:"id"`
+	Payload     interface{}       `json:"payload"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Offset      int64             `json:"offset"`       // Assigned per topic when the message is stored
+	PublishedAt time.Time         `json:"published_at"` // Set by the server when the message is stored
+	TTLMs       int64             `json:"ttl_ms,omitempty"`
+	ExpiresAt   *time.Time        `json:"expires_at,omitempty"` // Derived from TTLMs when the message is stored
+	DelayMs     int64             `json:"delay_ms,omitempty"`
+	DeliverAt   *time.Time        `json:"deliver_at,omitempty"`  // Derived from DelayMs when the message is published
+	Topic       string            `json:"-"`                     // Concrete topic the message was stored in
+	Size        int               `json:"-"`                     // Encoded payload size counted towards retention
+	DeadLetter  bool              `json:"dead_letter,omitempty"` // Already a dead-letter copy; never dead-lettered again. Set by the server only.
+}
+
+// Expired reports whether the message's expiry time has passed
+func (m *Message) Expired(now time.Time) bool {
+	return m.ExpiresAt != nil && !now.Before(*m.ExpiresAt)
+}
+
+// DeadLetter is the payload of a message republished to a dead-letter topic
+type DeadLetter struct {
+	OriginalTopic  string    `json:"original_topic"`
+	SubscriberID   string    `json:"subscriber_id"`
+	Reason         string    `json:"reason"`
+	DeadLetteredAt time.Time `json:"dead_lettered_at"`
+	Message        *Message  `json:"message"`
+}
+
+// DeadLetterSink receives messages that could not be delivered to a
+// subscriber
+type DeadLetterSink interface {
+	DeadLetter(msg *Message, subscriberID, reason string)
+}
+
+// MessageFilter selects the messages a subscriber receives
+type MessageFilter interface {
+	Match(msg *Message) bool
 }
 
 // ClientMessage represents incoming WebSocket messages from clients
 type ClientMessage struct {
-	Type      string   `json:"type"`
-	Topic     string   `json:"topic"`
-	Message   *Message `json:"message,omitempty"`
-	ClientID  string   `json:"client_id"`
-	LastN     int      `json:"last_n,omitempty"`
-	RequestID string   `json:"request_id,omitempty"`
+	Type         string   `json:"type"`
+	Topic        string   `json:"topic"`
+	Message      *Message `json:"message,omitempty"`
+	ClientID     string   `json:"client_id"`
+	LastN        int      `json:"last_n,omitempty"`
+	RequestID    string   `json:"request_id,omitempty"`
+	Group        string   `json:"group,omitempty"`
+	Delivery     string   `json:"delivery,omitempty"`
+	AckTimeoutMs int      `json:"ack_timeout_ms,omitempty"`
+	FromOffset   *int64   `json:"from_offset,omitempty"`
+
+	Backpressure   string `json:"backpressure,omitempty"`
+	BlockTimeoutMs int    `json:"block_timeout_ms,omitempty"`
+
+	Filter string `json:"filter,omitempty"`
+
+	Cumulative bool `json:"-"` // An ack or nack also covers earlier messages; set for STOMP client ack mode
 }
 
 // ServerMessage represents outgoing WebSocket messages to clients
@@ -31,30 +77,133 @@ type ServerMessage struct {
 	Status    string   `json:"status,omitempty"`
 	Msg       string   `json:"msg,omitempty"`
 	TS        string   `json:"ts,omitempty"`
+	Attempt   int      `json:"attempt,omitempty"`
+	Dropped   int64    `json:"dropped,omitempty"`
+	Session   string   `json:"session,omitempty"`
+	Resumed   bool     `json:"resumed,omitempty"`
+
+	SubscriberID string `json:"-"` // Subscriber an event was delivered to; never sent
 }
 
 // Error represents error details
 type Error struct {
 	Code    string `json:"code"`
 	Message string `json:"message"`
+	Path    string `json:"path,omitempty"` // Failing payload location for SCHEMA_VIOLATION
+}
+
+// Error implements the error interface so protocol errors can be returned
+// from the pub/sub core and mapped to error frames or HTTP statuses
+func (e *Error) Error() string {
+	return e.Message
 }
 
 // Topic represents a pub/sub topic
 type Topic struct {
-	Name        string
-	Subscribers map[string]*Subscriber
-	Messages    []*Message
-	MaxMessages int
-	Mu          sync.RWMutex
+	Name         string
+	Subscribers  map[string]*Subscriber
+	Messages     []*Message
+	Retention    RetentionPolicy
+	Settings     TopicSettings
+	HistoryBytes int64          // Total payload size of Messages
+	Dropped      int64          // Messages dropped by backpressure policies
+	NextOffset   int64          // Offset assigned to the next stored message
+	Log          MessageLog     // nil when persistence is disabled
+	DeadLetters  DeadLetterSink // Inherited by subscribers when they attach
+	GroupCursors map[string]int // Round-robin position per consumer group
+	Mu           sync.RWMutex
+}
+
+// RetentionPolicy bounds the history a topic keeps. A zero value disables
+// the corresponding limit.
+type RetentionPolicy struct {
+	MaxMessages int   `json:"max_messages"`
+	MaxAgeMs    int64 `json:"max_age_ms"`
+	MaxBytes    int64 `json:"max_bytes"`
+}
+
+// TopicSettings holds a topic's subscriber limits and descriptive metadata.
+// HistorySize mirrors Retention.MaxMessages.
+type TopicSettings struct {
+	MaxSubscribers  int               `json:"max_subscribers"`
+	QueueSize       int               `json:"queue_size"`
+	HistorySize     int               `json:"history_size"`
+	Backpressure    string            `json:"backpressure"`
+	BlockTimeoutMs  int64             `json:"block_timeout_ms"`
+	DeadLetterTopic string            `json:"dead_letter_topic,omitempty"`
+	Schema          string            `json:"schema,omitempty"`         // Registry subject payloads must match
+	SchemaVersion   int               `json:"schema_version,omitempty"` // 0 follows the subject's latest version
+	Description     string            `json:"description,omitempty"`
+	Labels          map[string]string `json:"labels,omitempty"`
+}
+
+// MessageLog is an interface for durable per-topic message storage
+type MessageLog interface {
+	Append(msg *Message) error
+	TruncateBefore(offset int64) error
+	WriteMeta(meta interface{}) error
+	Close() error
 }
 
 // Subscriber represents a WebSocket client subscription
 type Subscriber struct {
 	ID       string
 	Conn     WebSocketConn
-	Topic    string
+	Topic    string // Topic name or wildcard pattern
 	Queue    chan *Message
 	MaxQueue int
+	Group    string        // Consumer group; empty means receive every message
+	Filter   MessageFilter // nil receives every message
+
+	// Queue lifecycle: senders hold QueueMu for reading and skip a closed
+	// queue, and Closing is closed first to wake senders blocked on a full
+	// queue, so the queue can be closed without holding the topic lock
+	// during sends
+	QueueMu     sync.RWMutex
+	QueueClosed bool
+	Closing     chan struct{}
+	closeOnce   sync.Once
+
+	// Overflow handling; an empty Backpressure uses the topic's policy
+	Backpressure string
+	BlockTimeout time.Duration
+	Dropped      int64 // Updated atomically
+
+	DeadLetters DeadLetterSink // Receives undeliverable messages; nil discards them
+
+	// Replay state: Backlog is sent before live messages, and live messages
+	// with an offset below SkipBelow[topic] were already in the backlog
+	Backlog   []*Message
+	SkipBelow map[string]int64 // Keyed by concrete topic
+
+	// Resume state: the next offset the client has not been sent, per
+	// concrete topic, so a resumed session continues without duplicates
+	Cursors  map[string]int64
+	CursorMu sync.Mutex
+
+	// At-least-once delivery state
+	Delivery   string
+	AckTimeout time.Duration
+	InFlight   map[string]*InFlight // Keyed by message ID
+	Sends      uint64               // Counts in-flight sends, for InFlight.Sent
+	InFlightMu sync.Mutex
+}
+
+// SignalClosing closes Closing, once, to wake senders blocked on the queue
+func (s *Subscriber) SignalClosing() {
+	s.closeOnce.Do(func() {
+		if s.Closing != nil {
+			close(s.Closing)
+		}
+	})
+}
+
+// InFlight tracks a delivered message awaiting client acknowledgement
+type InFlight struct {
+	Message  *Message
+	Attempt  int
+	Deadline time.Time
+	Sent     uint64 // Order of the latest send among the subscriber's messages
 }
 
 // WebSocketConn is an interface for WebSocket connections
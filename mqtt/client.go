@@ -0,0 +1,311 @@
+package mqtt
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// requestTimeout bounds how long the client waits for an acknowledgement
+const requestTimeout = 10 * time.Second
+
+// ErrClientClosed is returned by calls on a client whose connection ended
+var ErrClientClosed = errors.New("mqtt: connection closed")
+
+// Options configures Dial
+type Options struct {
+	ClientID  string        // Empty asks the server to assign one
+	Username  string        // Ignored by this server
+	Password  string        // API key or JWT when authentication is enabled
+	KeepAlive time.Duration // PINGREQ interval; 0 disables keep-alive
+}
+
+// Message is a message received on a subscription
+type Message struct {
+	Topic     string
+	Payload   []byte
+	QoS       byte
+	Duplicate bool
+}
+
+// Client is a minimal MQTT 3.1.1 client supporting QoS 0 and 1. It is used
+// by cmd/mqtt-client to exercise the listener and works against any broker.
+type Client struct {
+	conn     net.Conn
+	reader   *bufio.Reader
+	messages chan Message
+	done     chan struct{}
+
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	nextID  uint16
+	pending map[uint16]chan *packet // Acknowledgements awaited by packet ID
+	pings   chan struct{}
+	err     error
+}
+
+// Dial connects to a broker at addr and sends CONNECT with a clean session
+func Dial(addr string, opts Options) (*Client, error) {
+	conn, err := net.DialTimeout("tcp", addr, requestTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &connect{
+		cleanSession: true,
+		keepAlive:    uint16(opts.KeepAlive / time.Second),
+		clientID:     opts.ClientID,
+		username:     opts.Username,
+		password:     opts.Password,
+		hasPassword:  opts.Password != "",
+	}
+	if err := writePacket(conn, packetConnect, 0, encodeConnect(req)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(requestTimeout))
+	p, err := readPacket(reader, maxRemainingLength)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Time{})
+	if p.kind != packetConnack || len(p.body) != 2 {
+		conn.Close()
+		return nil, fmt.Errorf("mqtt: expected CONNACK, got packet type %d", p.kind)
+	}
+	if code := p.body[1]; code != connackAccepted {
+		conn.Close()
+		return nil, fmt.Errorf("mqtt: connection refused with return code %d", code)
+	}
+
+	c := &Client{
+		conn:     conn,
+		reader:   reader,
+		messages: make(chan Message, 100),
+		done:     make(chan struct{}),
+		pending:  make(map[uint16]chan *packet),
+		pings:    make(chan struct{}, 1),
+	}
+	go c.readLoop()
+	if opts.KeepAlive > 0 {
+		go c.keepAlive(opts.KeepAlive)
+	}
+	return c, nil
+}
+
+// Messages returns the channel messages on subscriptions are delivered to.
+// It must be drained, since acknowledgements are read from the same
+// connection, and is closed when the connection ends.
+func (c *Client) Messages() <-chan Message {
+	return c.messages
+}
+
+// Publish publishes a message, waiting for PUBACK at QoS 1
+func (c *Client) Publish(topic string, payload []byte, qos byte) error {
+	if qos > 1 {
+		return fmt.Errorf("mqtt: QoS %d is not supported", qos)
+	}
+
+	pub := &publish{topic: topic, payload: payload, qos: qos}
+	if qos == 0 {
+		flags, body := encodePublish(pub)
+		return c.write(packetPublish, flags, body)
+	}
+
+	id, ack := c.expect()
+	pub.packetID = id
+	flags, body := encodePublish(pub)
+	if err := c.write(packetPublish, flags, body); err != nil {
+		return err
+	}
+	_, err := c.await(id, ack)
+	return err
+}
+
+// Subscribe subscribes to a topic filter and returns the granted QoS
+func (c *Client) Subscribe(filter string, qos byte) (byte, error) {
+	id, ack := c.expect()
+	body := appendString(appendUint16(nil, id), filter)
+	if err := c.write(packetSubscribe, 0x02, append(body, qos)); err != nil {
+		return 0, err
+	}
+
+	p, err := c.await(id, ack)
+	if err != nil {
+		return 0, err
+	}
+	if len(p.body) != 3 {
+		return 0, errMalformed
+	}
+	if granted := p.body[2]; granted != subackFailure {
+		return granted, nil
+	}
+	return 0, fmt.Errorf("mqtt: subscription to %s was rejected", filter)
+}
+
+// Unsubscribe removes the subscription to a topic filter
+func (c *Client) Unsubscribe(filter string) error {
+	id, ack := c.expect()
+	if err := c.write(packetUnsubscribe, 0x02, appendString(appendUint16(nil, id), filter)); err != nil {
+		return err
+	}
+	_, err := c.await(id, ack)
+	return err
+}
+
+// Ping sends PINGREQ and waits for PINGRESP
+func (c *Client) Ping() error {
+	if err := c.write(packetPingreq, 0, nil); err != nil {
+		return err
+	}
+
+	select {
+	case <-c.pings:
+		return nil
+	case <-c.done:
+		return c.closedErr()
+	case <-time.After(requestTimeout):
+		return fmt.Errorf("mqtt: timed out waiting for PINGRESP")
+	}
+}
+
+// Disconnect sends DISCONNECT, so the server discards the will, and closes
+// the connection
+func (c *Client) Disconnect() error {
+	err := c.write(packetDisconnect, 0, nil)
+	c.conn.Close()
+	return err
+}
+
+// Close closes the connection without DISCONNECT
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// write sends one packet
+func (c *Client) write(kind, flags byte, body []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return writePacket(c.conn, kind, flags, body)
+}
+
+// expect allocates a packet ID and registers for its acknowledgement
+func (c *Client) expect() (uint16, chan *packet) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for {
+		c.nextID++
+		if _, used := c.pending[c.nextID]; c.nextID != 0 && !used {
+			break
+		}
+	}
+	ack := make(chan *packet, 1)
+	c.pending[c.nextID] = ack
+	return c.nextID, ack
+}
+
+// await waits for the acknowledgement of a packet ID
+func (c *Client) await(id uint16, ack chan *packet) (*packet, error) {
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	select {
+	case p := <-ack:
+		return p, nil
+	case <-c.done:
+		return nil, c.closedErr()
+	case <-time.After(requestTimeout):
+		return nil, fmt.Errorf("mqtt: timed out waiting for acknowledgement of packet %d", id)
+	}
+}
+
+// closedErr returns the error the connection ended with
+func (c *Client) closedErr() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return c.err
+	}
+	return ErrClientClosed
+}
+
+// readLoop dispatches incoming packets until the connection ends
+func (c *Client) readLoop() {
+	defer close(c.messages)
+	defer close(c.done)
+
+	for {
+		p, err := readPacket(c.reader, maxRemainingLength)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				c.mu.Lock()
+				c.err = err
+				c.mu.Unlock()
+			}
+			return
+		}
+
+		switch p.kind {
+		case packetPublish:
+			pub, err := decodePublish(p)
+			if err != nil {
+				c.conn.Close()
+				continue
+			}
+			c.messages <- Message{Topic: pub.topic, Payload: pub.payload, QoS: pub.qos, Duplicate: pub.dup}
+			if pub.qos == 1 {
+				c.write(packetPuback, 0, appendUint16(nil, pub.packetID))
+			}
+
+		case packetPuback, packetSuback, packetUnsuback:
+			id, err := decodePacketID(p.body)
+			if err != nil {
+				continue
+			}
+			c.mu.Lock()
+			ack, exists := c.pending[id]
+			c.mu.Unlock()
+			if exists {
+				select {
+				case ack <- p:
+				default: // Duplicate acknowledgement
+				}
+			}
+
+		case packetPingresp:
+			select {
+			case c.pings <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// keepAlive pings the server every interval until the connection ends
+func (c *Client) keepAlive(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.write(packetPingreq, 0, nil); err != nil {
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
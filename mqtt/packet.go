@@ -0,0 +1,349 @@
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Control packet types of MQTT 3.1.1
+const (
+	packetConnect     = 1
+	packetConnack     = 2
+	packetPublish     = 3
+	packetPuback      = 4
+	packetPubrec      = 5
+	packetPubrel      = 6
+	packetPubcomp     = 7
+	packetSubscribe   = 8
+	packetSuback      = 9
+	packetUnsubscribe = 10
+	packetUnsuback    = 11
+	packetPingreq     = 12
+	packetPingresp    = 13
+	packetDisconnect  = 14
+)
+
+// CONNACK return codes
+const (
+	connackAccepted           = 0x00
+	connackBadProtocolVersion = 0x01
+	connackIdentifierRejected = 0x02
+	connackBadCredentials     = 0x04
+	connackNotAuthorized      = 0x05
+)
+
+// subackFailure is the SUBACK return code for a rejected topic filter
+const subackFailure = 0x80
+
+// maxRemainingLength is the largest remaining length the four-byte encoding
+// can express
+const maxRemainingLength = 268435455
+
+var errMalformed = errors.New("malformed packet")
+
+// packet is a raw control packet: the type and flags of the fixed header and
+// the variable header and payload that follow it
+type packet struct {
+	kind  byte
+	flags byte
+	body  []byte
+}
+
+// readPacket reads one control packet, rejecting bodies over maxSize bytes
+func readPacket(r *bufio.Reader, maxSize int) (*packet, error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		if i == 4 {
+			return nil, errMalformed
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		length += int(b&0x7f) * multiplier
+		if b&0x80 == 0 {
+			break
+		}
+		multiplier *= 128
+	}
+	if length > maxSize {
+		return nil, fmt.Errorf("packet of %d bytes exceeds the %d byte limit", length, maxSize)
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	return &packet{kind: first >> 4, flags: first & 0x0f, body: body}, nil
+}
+
+// writePacket writes a control packet with the given fixed header
+func writePacket(w io.Writer, kind, flags byte, body []byte) error {
+	if len(body) > maxRemainingLength {
+		return fmt.Errorf("packet of %d bytes is too large", len(body))
+	}
+
+	header := []byte{kind<<4 | flags}
+	length := len(body)
+	for {
+		b := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			b |= 0x80
+		}
+		header = append(header, b)
+		if length == 0 {
+			break
+		}
+	}
+
+	if _, err := w.Write(append(header, body...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// decoder reads the fields of a packet body
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) byte() byte {
+	if d.err != nil || len(d.buf) < 1 {
+		d.err = errMalformed
+		return 0
+	}
+	b := d.buf[0]
+	d.buf = d.buf[1:]
+	return b
+}
+
+func (d *decoder) uint16() uint16 {
+	if d.err != nil || len(d.buf) < 2 {
+		d.err = errMalformed
+		return 0
+	}
+	v := binary.BigEndian.Uint16(d.buf)
+	d.buf = d.buf[2:]
+	return v
+}
+
+func (d *decoder) bytes() []byte {
+	n := int(d.uint16())
+	if d.err != nil || len(d.buf) < n {
+		d.err = errMalformed
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+// rest returns the unread remainder of the body
+func (d *decoder) rest() []byte {
+	b := d.buf
+	d.buf = nil
+	return b
+}
+
+// appendUint16 appends a big-endian two-byte integer
+func appendUint16(b []byte, v uint16) []byte {
+	return binary.BigEndian.AppendUint16(b, v)
+}
+
+// appendString appends a length-prefixed UTF-8 string
+func appendString(b []byte, s string) []byte {
+	return append(appendUint16(b, uint16(len(s))), s...)
+}
+
+// connect is a decoded CONNECT packet
+type connect struct {
+	protocol     string
+	level        byte
+	cleanSession bool
+	keepAlive    uint16
+	clientID     string
+	will         *publish // nil without a will message
+	username     string
+	password     string
+	hasPassword  bool
+}
+
+// Flags of the CONNECT variable header
+const (
+	connectReserved     = 0x01
+	connectCleanSession = 0x02
+	connectWill         = 0x04
+	connectWillQoS      = 0x18
+	connectWillRetain   = 0x20
+	connectPassword     = 0x40
+	connectUsername     = 0x80
+)
+
+// decodeConnect decodes the body of a CONNECT packet. Only the protocol name
+// and level are read when the level is not 4, so the caller can reply with
+// the right return code.
+func decodeConnect(body []byte) (*connect, error) {
+	d := &decoder{buf: body}
+	c := &connect{protocol: d.string(), level: d.byte()}
+	if d.err != nil || c.level != 4 {
+		return c, d.err
+	}
+
+	flags := d.byte()
+	c.keepAlive = d.uint16()
+	c.clientID = d.string()
+	if flags&connectReserved != 0 {
+		return nil, errMalformed
+	}
+	c.cleanSession = flags&connectCleanSession != 0
+
+	if flags&connectWill != 0 {
+		c.will = &publish{
+			qos:    (flags & connectWillQoS) >> 3,
+			retain: flags&connectWillRetain != 0,
+		}
+		c.will.topic = d.string()
+		c.will.payload = d.bytes()
+	}
+	if flags&connectUsername != 0 {
+		c.username = d.string()
+	}
+	if flags&connectPassword != 0 {
+		c.password = d.string()
+		c.hasPassword = true
+	}
+	return c, d.err
+}
+
+// encodeConnect encodes a CONNECT packet body
+func encodeConnect(c *connect) []byte {
+	var flags byte
+	if c.cleanSession {
+		flags |= connectCleanSession
+	}
+	if c.username != "" {
+		flags |= connectUsername
+	}
+	if c.hasPassword {
+		flags |= connectPassword
+	}
+
+	body := appendString(nil, "MQTT")
+	body = append(body, 4, flags)
+	body = appendUint16(body, c.keepAlive)
+	body = appendString(body, c.clientID)
+	if c.username != "" {
+		body = appendString(body, c.username)
+	}
+	if c.hasPassword {
+		body = appendString(body, c.password)
+	}
+	return body
+}
+
+// publish is a decoded PUBLISH packet
+type publish struct {
+	topic    string
+	packetID uint16 // Only set for QoS 1 and 2
+	payload  []byte
+	qos      byte
+	dup      bool
+	retain   bool
+}
+
+// decodePublish decodes a PUBLISH packet
+func decodePublish(p *packet) (*publish, error) {
+	pub := &publish{
+		qos:    (p.flags >> 1) & 0x03,
+		dup:    p.flags&0x08 != 0,
+		retain: p.flags&0x01 != 0,
+	}
+	if pub.qos == 3 {
+		return nil, errMalformed
+	}
+
+	d := &decoder{buf: p.body}
+	pub.topic = d.string()
+	if pub.qos > 0 {
+		pub.packetID = d.uint16()
+	}
+	pub.payload = d.rest()
+	return pub, d.err
+}
+
+// encodePublish encodes a PUBLISH packet, returning its flags and body
+func encodePublish(pub *publish) (byte, []byte) {
+	flags := pub.qos << 1
+	if pub.dup {
+		flags |= 0x08
+	}
+	if pub.retain {
+		flags |= 0x01
+	}
+
+	body := appendString(nil, pub.topic)
+	if pub.qos > 0 {
+		body = appendUint16(body, pub.packetID)
+	}
+	return flags, append(body, pub.payload...)
+}
+
+// subscription is one topic filter of a SUBSCRIBE packet
+type subscription struct {
+	filter string
+	qos    byte
+}
+
+// decodeSubscribe decodes the packet ID and topic filters of a SUBSCRIBE
+// packet
+func decodeSubscribe(body []byte) (uint16, []subscription, error) {
+	d := &decoder{buf: body}
+	packetID := d.uint16()
+
+	var subs []subscription
+	for d.err == nil && len(d.buf) > 0 {
+		subs = append(subs, subscription{filter: d.string(), qos: d.byte()})
+	}
+	if d.err == nil && len(subs) == 0 {
+		return 0, nil, errMalformed
+	}
+	return packetID, subs, d.err
+}
+
+// decodeUnsubscribe decodes the packet ID and topic filters of an
+// UNSUBSCRIBE packet
+func decodeUnsubscribe(body []byte) (uint16, []string, error) {
+	d := &decoder{buf: body}
+	packetID := d.uint16()
+
+	var filters []string
+	for d.err == nil && len(d.buf) > 0 {
+		filters = append(filters, d.string())
+	}
+	if d.err == nil && len(filters) == 0 {
+		return 0, nil, errMalformed
+	}
+	return packetID, filters, d.err
+}
+
+// decodePacketID decodes a body consisting of a packet ID only, as in
+// PUBACK and UNSUBACK
+func decodePacketID(body []byte) (uint16, error) {
+	d := &decoder{buf: body}
+	packetID := d.uint16()
+	return packetID, d.err
+}
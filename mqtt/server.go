@@ -0,0 +1,176 @@
+package mqtt
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"pub-sub-system/auth"
+	"pub-sub-system/listener"
+	"pub-sub-system/pubsub"
+
+	"github.com/google/uuid"
+)
+
+// maxPacketSize bounds the remaining length of packets read from clients
+const maxPacketSize = 1 << 20
+
+// connectTimeout is how long a new connection has to send CONNECT
+const connectTimeout = 10 * time.Second
+
+// Server is an MQTT 3.1.1 listener bridged to the pub/sub core, so MQTT
+// clients see messages published over WebSocket, REST and gRPC and the other
+// way round
+type Server struct {
+	pubSubSystem  *pubsub.PubSubSystem
+	topicManager  *pubsub.TopicManager
+	subManager    *pubsub.SubscriberManager
+	authenticator auth.Authenticator // nil disables authentication
+	authorizer    *auth.Authorizer   // nil allows every request
+
+	listener listener.Listener
+	mu       sync.Mutex
+	sessions map[string]*session // Keyed by MQTT client ID
+}
+
+// New creates the MQTT server. The CONNECT password carries the credential,
+// an API key or JWT, and the username is ignored.
+func New(pubSubSystem *pubsub.PubSubSystem, authenticator auth.Authenticator, authorizer *auth.Authorizer) *Server {
+	return &Server{
+		pubSubSystem:  pubSubSystem,
+		topicManager:  pubsub.NewTopicManager(),
+		subManager:    pubsub.NewSubscriberManager(),
+		authenticator: authenticator,
+		authorizer:    authorizer,
+		sessions:      make(map[string]*session),
+	}
+}
+
+// Serve accepts connections on lis until Close is called
+func (s *Server) Serve(lis net.Listener) error {
+	return s.listener.Serve(lis, s.handle)
+}
+
+// Close stops accepting connections and disconnects every client. Wills are
+// not published, since the clients did not go away on their own.
+func (s *Server) Close() error {
+	return s.listener.Close()
+}
+
+// handle runs one network connection from CONNECT to disconnect
+func (s *Server) handle(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(connectTimeout))
+	p, err := readPacket(reader, maxPacketSize)
+	if err != nil || p.kind != packetConnect {
+		return
+	}
+
+	sess, code := s.accept(conn, reader, p)
+	if err := writePacket(conn, packetConnack, 0, []byte{0, code}); err != nil || sess == nil {
+		if sess != nil {
+			sess.close()
+		}
+		return
+	}
+
+	defer s.pubSubSystem.TrackConnection("mqtt", "mqtt-3.1.1")()
+	sess.run()
+}
+
+// accept validates a CONNECT packet and authenticates the client, returning
+// the CONNACK return code and, when accepted, the registered session
+func (s *Server) accept(conn net.Conn, reader *bufio.Reader, p *packet) (*session, byte) {
+	req, err := decodeConnect(p.body)
+	if err != nil {
+		return nil, connackIdentifierRejected
+	}
+	if req.protocol != "MQTT" || req.level != 4 {
+		return nil, connackBadProtocolVersion
+	}
+
+	clientID := req.clientID
+	if clientID == "" {
+		if !req.cleanSession {
+			return nil, connackIdentifierRejected
+		}
+		clientID = "mqtt-" + uuid.New().String()
+	}
+
+	principal, code := s.authenticate(req)
+	if code != connackAccepted {
+		return nil, code
+	}
+
+	if req.will != nil {
+		if _, err := topicFromMQTT(req.will.topic, false); err != nil {
+			return nil, connackIdentifierRejected
+		}
+	}
+	sess := newSession(s, conn, reader, clientID, principal, req)
+
+	// A second connection with the same client ID takes over: the first one
+	// is disconnected as the specification requires
+	s.mu.Lock()
+	previous := s.sessions[clientID]
+	s.sessions[clientID] = sess
+	s.mu.Unlock()
+	if previous != nil {
+		previous.conn.Close()
+		<-previous.done
+	}
+	return sess, connackAccepted
+}
+
+// authenticate checks the credential in the CONNECT password
+func (s *Server) authenticate(req *connect) (*auth.Principal, byte) {
+	if s.authenticator == nil {
+		return nil, connackAccepted
+	}
+	if !req.hasPassword || req.password == "" {
+		return nil, connackNotAuthorized
+	}
+
+	// The authenticator reads HTTP headers, so pass the password as a
+	// bearer token
+	r := &http.Request{Header: make(http.Header), URL: &url.URL{}}
+	r.Header.Set("Authorization", "Bearer "+req.password)
+
+	principal, err := s.authenticator.Authenticate(r)
+	if err != nil {
+		return nil, connackBadCredentials
+	}
+	return principal, connackAccepted
+}
+
+// release unregisters a session that has ended, unless a newer connection
+// has already taken its client ID
+func (s *Server) release(sess *session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.sessions[sess.id] == sess {
+		delete(s.sessions, sess.id)
+	}
+}
+
+// authorize checks that a client may perform an action on a topic
+func (s *Server) authorize(sess *session, action, topic string) error {
+	if s.authorizer.Allowed(sess.principal, action, topic) {
+		return nil
+	}
+	return errors.New(action + " not allowed on topic " + topic)
+}
+
+// ensureTopic makes sure a topic exists, creating it if the client may.
+// MQTT has no notion of creating topics, so the first publish or subscribe
+// to a name creates it.
+func (s *Server) ensureTopic(sess *session, name string) error {
+	return listener.EnsureTopic(s.pubSubSystem, name, func() error {
+		return s.authorize(sess, auth.ActionCreate, name)
+	})
+}
@@ -0,0 +1,295 @@
+package mqtt
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"pub-sub-system/handlers"
+	"pub-sub-system/models"
+	"pub-sub-system/pubsub"
+
+	"github.com/gorilla/websocket"
+)
+
+// startTestServer runs the listener on a localhost port
+func startTestServer(t *testing.T) (*pubsub.PubSubSystem, string) {
+	t.Helper()
+	ps := pubsub.NewPubSubSystem()
+	srv := New(ps, nil, nil)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	go srv.Serve(lis)
+	t.Cleanup(func() {
+		srv.Close()
+		ps.Close()
+	})
+	return ps, lis.Addr().String()
+}
+
+func dialTest(t *testing.T, addr, clientID string) *Client {
+	t.Helper()
+	c, err := Dial(addr, Options{ClientID: clientID})
+	if err != nil {
+		t.Fatalf("Dial(%s) error = %v", clientID, err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func subscribeTest(t *testing.T, c *Client, filter string, qos byte) {
+	t.Helper()
+	granted, err := c.Subscribe(filter, qos)
+	if err != nil {
+		t.Fatalf("Subscribe(%s) error = %v", filter, err)
+	}
+	if granted != qos {
+		t.Fatalf("Subscribe(%s) granted QoS %d, want %d", filter, granted, qos)
+	}
+}
+
+func publishTest(t *testing.T, c *Client, topic, payload string, qos byte) {
+	t.Helper()
+	if err := c.Publish(topic, []byte(payload), qos); err != nil {
+		t.Fatalf("Publish(%s, QoS %d) error = %v", topic, qos, err)
+	}
+}
+
+// receive waits for the next message on a client's subscriptions
+func receive(t *testing.T, c *Client) Message {
+	t.Helper()
+	select {
+	case msg, ok := <-c.Messages():
+		if !ok {
+			t.Fatal("connection closed while waiting for a message")
+		}
+		return msg
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for a message")
+	}
+	return Message{}
+}
+
+func TestConnectAndPing(t *testing.T) {
+	_, addr := startTestServer(t)
+	c := dialTest(t, addr, "pinger")
+
+	if err := c.Ping(); err != nil {
+		t.Fatalf("Ping() error = %v", err)
+	}
+	if err := c.Disconnect(); err != nil {
+		t.Fatalf("Disconnect() error = %v", err)
+	}
+}
+
+func TestConnectAssignsClientID(t *testing.T) {
+	_, addr := startTestServer(t)
+	c := dialTest(t, addr, "")
+
+	if err := c.Ping(); err != nil {
+		t.Fatalf("Ping() error = %v", err)
+	}
+}
+
+func TestPublishQoS0And1(t *testing.T) {
+	_, addr := startTestServer(t)
+	sub := dialTest(t, addr, "sub")
+	pub := dialTest(t, addr, "pub")
+
+	subscribeTest(t, sub, "sensors/kitchen/temp", 1)
+
+	// QoS 0 is delivered at the subscription's QoS 1, QoS 1 returns after
+	// PUBACK
+	for _, qos := range []byte{0, 1} {
+		publishTest(t, pub, "sensors/kitchen/temp", "21", qos)
+		msg := receive(t, sub)
+		if msg.Topic != "sensors/kitchen/temp" || string(msg.Payload) != "21" || msg.QoS != 1 {
+			t.Errorf("received %s %q at QoS %d, want sensors/kitchen/temp \"21\" at QoS 1", msg.Topic, msg.Payload, msg.QoS)
+		}
+	}
+
+	// Publishing at QoS 2 is not supported by the client
+	if err := pub.Publish("sensors/kitchen/temp", []byte("x"), 2); err == nil {
+		t.Error("Publish at QoS 2 succeeded")
+	}
+}
+
+func TestWildcardSubscriptions(t *testing.T) {
+	_, addr := startTestServer(t)
+	sub := dialTest(t, addr, "sub")
+	pub := dialTest(t, addr, "pub")
+
+	subscribeTest(t, sub, "sensors/+/temp", 0)
+	subscribeTest(t, sub, "alerts/#", 0)
+
+	publishTest(t, pub, "sensors/kitchen/humidity", "40", 1) // Matches neither
+	publishTest(t, pub, "sensors/kitchen/temp", "21", 1)
+	if msg := receive(t, sub); msg.Topic != "sensors/kitchen/temp" {
+		t.Errorf("received %s, want sensors/kitchen/temp via +", msg.Topic)
+	}
+
+	publishTest(t, pub, "alerts/fire/floor1", "!", 1)
+	if msg := receive(t, sub); msg.Topic != "alerts/fire/floor1" {
+		t.Errorf("received %s, want alerts/fire/floor1 via #", msg.Topic)
+	}
+
+	// Filters using the server's own wildcard syntax are rejected
+	if _, err := sub.Subscribe("sensors.*", 0); err == nil {
+		t.Error("Subscribe(sensors.*) succeeded")
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	_, addr := startTestServer(t)
+	sub := dialTest(t, addr, "sub")
+	pub := dialTest(t, addr, "pub")
+
+	subscribeTest(t, sub, "news/sports", 0)
+	subscribeTest(t, sub, "news/weather", 0)
+	if err := sub.Unsubscribe("news/sports"); err != nil {
+		t.Fatalf("Unsubscribe() error = %v", err)
+	}
+
+	// Only the remaining subscription delivers
+	publishTest(t, pub, "news/sports", "goal", 1)
+	publishTest(t, pub, "news/weather", "rain", 1)
+	if msg := receive(t, sub); msg.Topic != "news/weather" {
+		t.Errorf("received %s after unsubscribing, want news/weather", msg.Topic)
+	}
+}
+
+func TestDeliveryBetweenMQTTAndWebSocket(t *testing.T) {
+	ps, addr := startTestServer(t)
+	wsServer := httptest.NewServer(http.HandlerFunc(handlers.NewWebSocketHandler(ps, nil).HandleWebSocket))
+	defer wsServer.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(wsServer.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("WebSocket dial error = %v", err)
+	}
+	defer ws.Close()
+
+	// An MQTT subscription creates the topic the WebSocket client joins
+	mqttClient := dialTest(t, addr, "shared-id")
+	subscribeTest(t, mqttClient, "chat/room", 1)
+
+	// The same client ID on WebSocket must not replace the MQTT subscription
+	ws.WriteJSON(models.ClientMessage{Type: "subscribe", Topic: "chat.room", ClientID: "shared-id", RequestID: "sub"})
+	readWebSocket(t, ws, "ack")
+
+	publishTest(t, mqttClient, "chat/room", `{"text":"from mqtt"}`, 1)
+	event := readWebSocket(t, ws, "event")
+	payload, ok := event.Message.Payload.(map[string]interface{})
+	if event.Topic != "chat.room" || !ok || payload["text"] != "from mqtt" {
+		t.Errorf("WebSocket received %s %v, want chat.room {text: from mqtt}", event.Topic, event.Message.Payload)
+	}
+	if msg := receive(t, mqttClient); string(msg.Payload) != `{"text":"from mqtt"}` {
+		t.Errorf("MQTT received its own message as %q", msg.Payload)
+	}
+
+	ws.WriteJSON(models.ClientMessage{Type: "publish", Topic: "chat.room", Message: &models.Message{Payload: "from websocket"}, RequestID: "pub"})
+	readWebSocket(t, ws, "ack")
+	if msg := receive(t, mqttClient); msg.Topic != "chat/room" || string(msg.Payload) != "from websocket" {
+		t.Errorf("MQTT received %s %q, want chat/room \"from websocket\"", msg.Topic, msg.Payload)
+	}
+}
+
+func TestWebSocketClientCannotTakeOverMQTTSubscription(t *testing.T) {
+	ps, addr := startTestServer(t)
+	wsServer := httptest.NewServer(http.HandlerFunc(handlers.NewWebSocketHandler(ps, nil).HandleWebSocket))
+	defer wsServer.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(wsServer.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("WebSocket dial error = %v", err)
+	}
+	defer ws.Close()
+
+	mqttClient := dialTest(t, addr, "victim")
+	subscribeTest(t, mqttClient, "chat/room", 1)
+	subscribeTest(t, mqttClient, "chat/#", 1)
+
+	// Neither the old mqtt-<client ID> key nor the subscriber's actual ID
+	// lets a WebSocket client replace or unsubscribe it
+	topic, _ := ps.GetTopic("chat.room")
+	topic.Mu.RLock()
+	var ids []string
+	for id := range topic.Subscribers {
+		ids = append(ids, id)
+	}
+	topic.Mu.RUnlock()
+	if len(ids) != 2 {
+		t.Fatalf("chat.room has subscribers %v, want the exact and pattern subscription", ids)
+	}
+
+	ws.WriteJSON(models.ClientMessage{Type: "subscribe", Topic: "chat.room", ClientID: "mqtt-victim", RequestID: "forged"})
+	readWebSocket(t, ws, "ack")
+	ws.WriteJSON(models.ClientMessage{Type: "unsubscribe", Topic: "chat.room", ClientID: "mqtt-victim", RequestID: "forged-unsub"})
+	readWebSocket(t, ws, "ack")
+	for _, id := range ids {
+		if clientID, pattern, found := strings.Cut(id, "@"); found {
+			ws.WriteJSON(models.ClientMessage{Type: "unsubscribe", Topic: pattern, ClientID: clientID, RequestID: "unsub-pattern"})
+			expectWebSocketError(t, ws, "BAD_REQUEST")
+			ws.WriteJSON(models.ClientMessage{Type: "subscribe", Topic: pattern, ClientID: clientID, RequestID: "sub-pattern"})
+			expectWebSocketError(t, ws, "BAD_REQUEST")
+			continue
+		}
+		ws.WriteJSON(models.ClientMessage{Type: "subscribe", Topic: "chat.room", ClientID: id, RequestID: "sub-id"})
+		expectWebSocketError(t, ws, "BAD_REQUEST")
+		ws.WriteJSON(models.ClientMessage{Type: "unsubscribe", Topic: "chat.room", ClientID: id, RequestID: "unsub-id"})
+		readWebSocket(t, ws, "ack")
+	}
+
+	// Both MQTT subscriptions still deliver
+	publishTest(t, mqttClient, "chat/room", "still here", 0)
+	for i := 0; i < 2; i++ {
+		if msg := receive(t, mqttClient); msg.Topic != "chat/room" || string(msg.Payload) != "still here" {
+			t.Errorf("MQTT received %s %q, want chat/room \"still here\"", msg.Topic, msg.Payload)
+		}
+	}
+}
+
+// expectWebSocketError reads server messages until an error arrives and
+// checks its code
+func expectWebSocketError(t *testing.T, ws *websocket.Conn, code string) {
+	t.Helper()
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		var msg models.ServerMessage
+		if err := ws.ReadJSON(&msg); err != nil {
+			t.Fatalf("WebSocket read waiting for error %s: %v", code, err)
+		}
+		if msg.Type == "ack" {
+			t.Fatalf("WebSocket request %s succeeded, want error %s", msg.RequestID, code)
+		}
+		if msg.Type == "error" {
+			if msg.Error.Code != code {
+				t.Fatalf("WebSocket error %s, want %s", msg.Error.Code, code)
+			}
+			return
+		}
+	}
+}
+
+// readWebSocket reads server messages until one of the given type arrives
+func readWebSocket(t *testing.T, ws *websocket.Conn, kind string) models.ServerMessage {
+	t.Helper()
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		var msg models.ServerMessage
+		if err := ws.ReadJSON(&msg); err != nil {
+			t.Fatalf("WebSocket read waiting for %s: %v", kind, err)
+		}
+		if msg.Type == "error" {
+			t.Fatalf("WebSocket error waiting for %s: %+v", kind, msg.Error)
+		}
+		if msg.Type == kind {
+			return msg
+		}
+	}
+}
@@ -0,0 +1,453 @@
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"pub-sub-system/auth"
+	"pub-sub-system/listener"
+	"pub-sub-system/metrics"
+	"pub-sub-system/models"
+	"pub-sub-system/pubsub"
+)
+
+// maxPacketIDs is how many outgoing QoS 1 messages can await PUBACK on one
+// connection, the number of non-zero packet IDs
+const maxPacketIDs = 65535
+
+// session is one connected MQTT client. Sessions are always clean: the
+// client's subscriptions end with its connection.
+type session struct {
+	server    *Server
+	id        string
+	subID     string // Client ID of the session's subscribers
+	conn      net.Conn
+	reader    *bufio.Reader
+	principal *auth.Principal
+	keepAlive time.Duration
+	will      *publish // Published unless the client sends DISCONNECT
+	done      chan struct{}
+	doneOnce  sync.Once
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	writeMu sync.Mutex
+
+	subsMu sync.Mutex
+	subs   map[string]*models.Subscriber // Keyed by MQTT topic filter
+
+	// Outgoing QoS 1 messages awaiting PUBACK. A redelivery reuses the
+	// packet ID of the first attempt, as the specification requires.
+	packetMu     sync.Mutex
+	nextPacketID uint16
+	packets      map[uint16]inFlightKey
+	packetIDs    map[inFlightKey]uint16
+}
+
+// inFlightKey identifies a message delivered to one subscription
+type inFlightKey struct {
+	sub       *models.Subscriber
+	messageID string
+}
+
+// newSession creates the session for an accepted CONNECT
+func newSession(server *Server, conn net.Conn, reader *bufio.Reader, id string, principal *auth.Principal, req *connect) *session {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &session{
+		server:    server,
+		id:        id,
+		subID:     "mqtt-" + uuid.New().String(),
+		conn:      conn,
+		reader:    reader,
+		principal: principal,
+		keepAlive: time.Duration(req.keepAlive) * time.Second,
+		will:      req.will,
+		done:      make(chan struct{}),
+		ctx:       ctx,
+		cancel:    cancel,
+		subs:      make(map[string]*models.Subscriber),
+		packets:   make(map[uint16]inFlightKey),
+		packetIDs: make(map[inFlightKey]uint16),
+	}
+}
+
+// run serves the session's packets until the connection ends, then removes
+// its subscriptions and publishes its will if it went away without
+// DISCONNECT
+func (s *session) run() {
+	defer s.close()
+
+	clean := s.serve()
+	s.cancel()
+	s.unsubscribeAll()
+
+	if !clean && s.will != nil && !s.server.listener.Closed() {
+		if err := s.publish(s.will); err != nil {
+			log.Printf("Failed to publish will of MQTT client %s: %v", s.id, err)
+		}
+	}
+}
+
+// close releases the session's client ID
+func (s *session) close() {
+	s.doneOnce.Do(func() {
+		s.cancel()
+		s.server.release(s)
+		close(s.done)
+	})
+}
+
+// serve reads packets until the connection fails, breaks the protocol or
+// keep-alive, or the client disconnects, which is reported as clean
+func (s *session) serve() bool {
+	for {
+		// The client must send something within one and a half keep-alive
+		// periods
+		deadline := time.Time{}
+		if s.keepAlive > 0 {
+			deadline = time.Now().Add(s.keepAlive * 3 / 2)
+		}
+		s.conn.SetReadDeadline(deadline)
+
+		p, err := readPacket(s.reader, maxPacketSize)
+		if err != nil {
+			return false
+		}
+
+		switch p.kind {
+		case packetPublish:
+			err = s.handlePublish(p)
+		case packetPuback:
+			err = s.handlePuback(p)
+		case packetSubscribe:
+			err = s.handleSubscribe(p)
+		case packetUnsubscribe:
+			err = s.handleUnsubscribe(p)
+		case packetPingreq:
+			err = s.write(packetPingresp, 0, nil)
+		case packetDisconnect:
+			return true
+		default:
+			err = fmt.Errorf("unexpected packet type %d", p.kind)
+		}
+
+		if err != nil {
+			log.Printf("Closing MQTT client %s: %v", s.id, err)
+			return false
+		}
+	}
+}
+
+// write sends one packet; writes from subscriber processors and the read
+// loop are serialized
+func (s *session) write(kind, flags byte, body []byte) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return writePacket(s.conn, kind, flags, body)
+}
+
+// handlePublish publishes a client's message and acknowledges it at QoS 1.
+// MQTT 3.1.1 cannot report a failed publish, so the connection is closed
+// instead, as brokers do for unauthorized publishes.
+func (s *session) handlePublish(p *packet) error {
+	pub, err := decodePublish(p)
+	if err != nil {
+		return err
+	}
+	if pub.qos == 2 {
+		return fmt.Errorf("QoS 2 is not supported")
+	}
+
+	if err := s.publish(pub); err != nil {
+		return fmt.Errorf("publish to %s failed: %w", pub.topic, err)
+	}
+	if pub.qos == 1 {
+		return s.write(packetPuback, 0, appendUint16(nil, pub.packetID))
+	}
+	return nil
+}
+
+// publish publishes an MQTT message to the matching pub/sub topic, creating
+// the topic if needed
+func (s *session) publish(pub *publish) error {
+	topic, err := topicFromMQTT(pub.topic, false)
+	if err != nil {
+		return err
+	}
+	if err := s.server.authorize(s, auth.ActionPublish, topic); err != nil {
+		metrics.Errors.WithLabelValues("FORBIDDEN").Inc()
+		return err
+	}
+	if err := s.server.ensureTopic(s, topic); err != nil {
+		return err
+	}
+
+	msg := &models.Message{Payload: listener.PayloadValue(pub.payload)}
+	if err := pubsub.PrepareMessage(msg); err != nil {
+		return err
+	}
+	if err := s.server.pubSubSystem.Publish(topic, msg); err != nil {
+		if e, ok := err.(*models.Error); ok {
+			metrics.Errors.WithLabelValues(e.Code).Inc()
+		}
+		return err
+	}
+	return nil
+}
+
+// handleSubscribe subscribes to each topic filter and reports the granted
+// QoS, or failure, for each in SUBACK
+func (s *session) handleSubscribe(p *packet) error {
+	if p.flags != 0x02 {
+		return errMalformed
+	}
+	packetID, filters, err := decodeSubscribe(p.body)
+	if err != nil {
+		return err
+	}
+
+	codes := make([]byte, 0, len(filters))
+	for _, f := range filters {
+		if f.qos > 2 {
+			return errMalformed
+		}
+		codes = append(codes, s.subscribe(f.filter, f.qos))
+	}
+	return s.write(packetSuback, 0, append(appendUint16(nil, packetID), codes...))
+}
+
+// subscribe attaches a new subscriber for one topic filter, replacing an
+// existing subscription to the same filter. QoS 2 is downgraded to 1, which
+// is delivered at least once and acknowledged with PUBACK.
+func (s *session) subscribe(filter string, qos byte) byte {
+	topic, err := topicFromMQTT(filter, true)
+	if err == nil {
+		err = s.server.authorize(s, auth.ActionSubscribe, topic)
+	}
+	if err != nil {
+		log.Printf("MQTT client %s cannot subscribe to %s: %v", s.id, filter, err)
+		return subackFailure
+	}
+
+	granted := qos
+	if granted > 1 {
+		granted = 1
+	}
+	// A previous subscription to the filter has its own subscriberConn, so
+	// it is removed first rather than replaced on the topic
+	s.subsMu.Lock()
+	previous := s.subs[filter]
+	delete(s.subs, filter)
+	s.subsMu.Unlock()
+	if previous != nil {
+		s.remove(previous)
+	}
+
+	conn := &subscriberConn{session: s, qos: granted}
+	var sub *models.Subscriber
+	if pubsub.IsPattern(topic) {
+		sub = s.server.subManager.NewSubscriber(pubsub.SubscriberKey(s.subID, topic), topic, conn)
+		if granted == 1 {
+			sub.Delivery = pubsub.DeliveryAtLeastOnce
+		}
+		conn.sub = sub
+		err = s.server.pubSubSystem.AddWildcardSubscriber(sub)
+	} else {
+		sub = s.server.subManager.NewSubscriber(s.subID, topic, conn)
+		if granted == 1 {
+			sub.Delivery = pubsub.DeliveryAtLeastOnce
+		}
+		conn.sub = sub
+		err = s.addSubscriber(topic, sub)
+	}
+	if err != nil {
+		log.Printf("MQTT client %s cannot subscribe to %s: %v", s.id, filter, err)
+		return subackFailure
+	}
+
+	s.subsMu.Lock()
+	s.subs[filter] = sub
+	s.subsMu.Unlock()
+
+	s.server.subManager.StartMessageProcessor(sub, s.ctx)
+	return granted
+}
+
+// addSubscriber attaches a subscriber to an exact topic, creating the topic
+// if needed
+func (s *session) addSubscriber(name string, sub *models.Subscriber) error {
+	if err := s.server.ensureTopic(s, name); err != nil {
+		return err
+	}
+	topic, exists := s.server.pubSubSystem.GetTopic(name)
+	if !exists {
+		return fmt.Errorf("topic %s does not exist", name)
+	}
+	return s.server.topicManager.AddSubscriber(topic, sub)
+}
+
+// handleUnsubscribe removes subscriptions by topic filter. Unknown filters
+// are acknowledged too.
+func (s *session) handleUnsubscribe(p *packet) error {
+	if p.flags != 0x02 {
+		return errMalformed
+	}
+	packetID, filters, err := decodeUnsubscribe(p.body)
+	if err != nil {
+		return err
+	}
+
+	for _, filter := range filters {
+		s.subsMu.Lock()
+		sub, exists := s.subs[filter]
+		delete(s.subs, filter)
+		s.subsMu.Unlock()
+		if exists {
+			s.remove(sub)
+		}
+	}
+	return s.write(packetUnsuback, 0, appendUint16(nil, packetID))
+}
+
+// unsubscribeAll removes every subscription when the connection ends
+func (s *session) unsubscribeAll() {
+	s.subsMu.Lock()
+	subs := s.subs
+	s.subs = make(map[string]*models.Subscriber)
+	s.subsMu.Unlock()
+
+	for _, sub := range subs {
+		s.remove(sub)
+	}
+}
+
+// remove detaches a subscriber from its topic or pattern
+func (s *session) remove(sub *models.Subscriber) {
+	if pubsub.IsPattern(sub.Topic) {
+		s.server.pubSubSystem.RemoveWildcardSubscribersByConn(sub.Conn)
+	} else if topic, exists := s.server.pubSubSystem.GetTopic(sub.Topic); exists {
+		s.server.topicManager.RemoveSubscribersByConn(topic, sub.Conn)
+	}
+	s.forget(sub)
+}
+
+// forget drops the packet IDs of a removed subscriber's unacknowledged
+// messages
+func (s *session) forget(sub *models.Subscriber) {
+	s.packetMu.Lock()
+	defer s.packetMu.Unlock()
+
+	for key, id := range s.packetIDs {
+		if key.sub == sub {
+			delete(s.packetIDs, key)
+			delete(s.packets, id)
+		}
+	}
+}
+
+// packetIDFor returns the packet ID to send a QoS 1 message with and whether
+// it is a redelivery
+func (s *session) packetIDFor(sub *models.Subscriber, messageID string) (uint16, bool, error) {
+	s.packetMu.Lock()
+	defer s.packetMu.Unlock()
+
+	key := inFlightKey{sub: sub, messageID: messageID}
+	if id, exists := s.packetIDs[key]; exists {
+		return id, true, nil
+	}
+
+	if len(s.packets) >= maxPacketIDs {
+		s.pruneLocked()
+		if len(s.packets) >= maxPacketIDs {
+			return 0, false, fmt.Errorf("too many unacknowledged messages")
+		}
+	}
+	for {
+		s.nextPacketID++
+		if _, used := s.packets[s.nextPacketID]; s.nextPacketID != 0 && !used {
+			break
+		}
+	}
+
+	s.packets[s.nextPacketID] = key
+	s.packetIDs[key] = s.nextPacketID
+	return s.nextPacketID, false, nil
+}
+
+// pruneLocked frees the packet IDs of messages that are no longer in flight
+// because they were dead-lettered after their last attempt. Callers must
+// hold packetMu.
+func (s *session) pruneLocked() {
+	for key, id := range s.packetIDs {
+		key.sub.InFlightMu.Lock()
+		_, inFlight := key.sub.InFlight[key.messageID]
+		key.sub.InFlightMu.Unlock()
+		if !inFlight {
+			delete(s.packetIDs, key)
+			delete(s.packets, id)
+		}
+	}
+}
+
+// handlePuback acknowledges the message sent with the packet's ID
+func (s *session) handlePuback(p *packet) error {
+	id, err := decodePacketID(p.body)
+	if err != nil {
+		return err
+	}
+
+	s.packetMu.Lock()
+	key, exists := s.packets[id]
+	delete(s.packets, id)
+	delete(s.packetIDs, key)
+	s.packetMu.Unlock()
+
+	if exists {
+		s.server.subManager.Ack(key.sub, key.messageID)
+	}
+	return nil
+}
+
+// subscriberConn adapts one subscription to models.WebSocketConn, sending
+// its events as PUBLISH packets at the subscription's QoS
+type subscriberConn struct {
+	session *session
+	sub     *models.Subscriber
+	qos     byte
+}
+
+// WriteJSON sends events. Error frames, such as SLOW_CONSUMER, are followed
+// by Close and info frames have no MQTT equivalent, so both are skipped.
+func (c *subscriberConn) WriteJSON(v interface{}) error {
+	msg, ok := v.(*models.ServerMessage)
+	if !ok || msg.Type != "event" || msg.Message == nil {
+		return nil
+	}
+
+	payload, err := listener.PayloadBytes(msg.Message.Payload)
+	if err != nil {
+		return err
+	}
+	pub := &publish{topic: topicToMQTT(msg.Topic), payload: payload, qos: c.qos}
+	if c.qos > 0 {
+		if pub.packetID, pub.dup, err = c.session.packetIDFor(c.sub, msg.Message.ID); err != nil {
+			return err
+		}
+	}
+
+	flags, body := encodePublish(pub)
+	return c.session.write(packetPublish, flags, body)
+}
+
+// Close disconnects the client
+func (c *subscriberConn) Close() error {
+	return c.session.conn.Close()
+}
@@ -0,0 +1,52 @@
+package mqtt
+
+import (
+	"fmt"
+	"strings"
+
+	"pub-sub-system/pubsub"
+)
+
+// MQTT separates topic levels with "/" and uses "+" and "#" as its single
+// and multi-level wildcards
+const (
+	levelSeparator  = "/"
+	singleLevelWild = "+"
+	multiLevelWild  = "#"
+)
+
+// topicFromMQTT converts an MQTT topic name, or a topic filter when filter
+// is set, to a pub/sub topic name or pattern: "/" becomes "." and the "+"
+// and "#" wildcards become "*" and ">". Names that would not survive the
+// round trip, such as ones containing ".", are rejected.
+func topicFromMQTT(name string, filter bool) (string, error) {
+	if strings.ContainsAny(name, ".*>") {
+		return "", fmt.Errorf("topic %q must not contain '.', '*' or '>'", name)
+	}
+
+	levels := strings.Split(name, levelSeparator)
+	for i, level := range levels {
+		switch {
+		case level == singleLevelWild && filter:
+			levels[i] = "*"
+		case level == multiLevelWild && filter:
+			levels[i] = ">"
+		case strings.ContainsAny(level, singleLevelWild+multiLevelWild):
+			if !filter {
+				return "", fmt.Errorf("topic name %q must not contain wildcards", name)
+			}
+			return "", fmt.Errorf("wildcards must occupy a whole level in %q", name)
+		}
+	}
+
+	topic := strings.Join(levels, ".")
+	if pubsub.IsPattern(topic) {
+		return topic, pubsub.ValidatePattern(topic)
+	}
+	return topic, pubsub.ValidateTopicName(topic)
+}
+
+// topicToMQTT converts a concrete pub/sub topic name to an MQTT topic name
+func topicToMQTT(name string) string {
+	return strings.ReplaceAll(name, ".", levelSeparator)
+}
@@ -0,0 +1,132 @@
+package pubsub
+
+import (
+	"sync/atomic"
+	"time"
+
+	"pub-sub-system/metrics"
+	"pub-sub-system/models"
+)
+
+// Backpressure policies applied when a subscriber's queue is full
+const (
+	BackpressureDisconnect = "disconnect"  // Send SLOW_CONSUMER and close the connection
+	BackpressureDropNewest = "drop_newest" // Discard the message being published
+	BackpressureDropOldest = "drop_oldest" // Discard the oldest queued message to make room
+	BackpressureBlock      = "block"       // Block the publisher up to a timeout, then drop
+)
+
+// ValidBackpressure reports whether policy is a supported backpressure policy
+func ValidBackpressure(policy string) bool {
+	switch policy {
+	case BackpressureDisconnect, BackpressureDropNewest, BackpressureDropOldest, BackpressureBlock:
+		return true
+	}
+	return false
+}
+
+// delivery is a subscriber selected to receive a message, with the
+// backpressure policy that applies to it
+type delivery struct {
+	sub     *models.Subscriber
+	policy  string
+	timeout time.Duration
+}
+
+// newDelivery resolves the policy and block timeout that apply to a
+// subscriber on a topic. Callers must hold topic.Mu.
+func newDelivery(topic *models.Topic, sub *models.Subscriber) delivery {
+	policy := sub.Backpressure
+	if policy == "" {
+		policy = topic.Settings.Backpressure
+	}
+	timeout := sub.BlockTimeout
+	if timeout <= 0 {
+		timeout = time.Duration(topic.Settings.BlockTimeoutMs) * time.Millisecond
+	}
+	return delivery{sub: sub, policy: policy, timeout: timeout}
+}
+
+// applyBackpressure handles a message that did not fit in a subscriber's
+// queue. It returns the messages the subscriber lost and whether the
+// subscriber must be disconnected. It is called without topic.Mu, since the
+// block policy may wait.
+func applyBackpressure(d delivery, msg *models.Message, start time.Time) ([]*models.Message, bool) {
+	switch d.policy {
+	case BackpressureDropNewest:
+		return []*models.Message{msg}, false
+	case BackpressureDropOldest:
+		return enqueueDropOldest(d.sub, msg), false
+	case BackpressureBlock:
+		if !enqueueBefore(d.sub, msg, start.Add(d.timeout)) {
+			return []*models.Message{msg}, false
+		}
+		return nil, false
+	default:
+		return []*models.Message{msg}, true
+	}
+}
+
+// enqueueDropOldest discards queued messages until msg fits and returns the
+// discarded messages
+func enqueueDropOldest(sub *models.Subscriber, msg *models.Message) []*models.Message {
+	sub.QueueMu.RLock()
+	defer sub.QueueMu.RUnlock()
+
+	var dropped []*models.Message
+	if sub.QueueClosed {
+		return dropped
+	}
+	for {
+		select {
+		case sub.Queue <- msg:
+			return dropped
+		default:
+		}
+
+		select {
+		case old := <-sub.Queue:
+			dropped = append(dropped, old)
+		default:
+		}
+	}
+}
+
+// enqueueBefore waits until msg fits in the queue or the deadline passes.
+// It gives up early, reporting success, when the subscriber is removed.
+func enqueueBefore(sub *models.Subscriber, msg *models.Message, deadline time.Time) bool {
+	wait := time.Until(deadline)
+	if wait <= 0 {
+		return false
+	}
+
+	sub.QueueMu.RLock()
+	defer sub.QueueMu.RUnlock()
+
+	if sub.QueueClosed {
+		return true
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case sub.Queue <- msg:
+		return true
+	case <-sub.Closing:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// recordDrops counts messages a subscriber lost to its backpressure policy.
+// Callers must hold topic.Mu for writing.
+func recordDrops(topic *models.Topic, sub *models.Subscriber, n int) {
+	if n == 0 {
+		return
+	}
+	topic.Dropped += int64(n)
+	metrics.Dropped.WithLabelValues(metrics.TopicLabel(topic.Name)).Add(float64(n))
+	atomic.AddInt64(&sub.Dropped, int64(n))
+}
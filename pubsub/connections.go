@@ -0,0 +1,64 @@
+package pubsub
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"pub-sub-system/metrics"
+
+	"github.com/google/uuid"
+)
+
+// ConnectionInfo describes an open client connection in /stats
+type ConnectionInfo struct {
+	ID          string    `json:"id"`
+	Protocol    string    `json:"protocol"` // Transport, e.g. "websocket"
+	Codec       string    `json:"codec"`    // Frame encoding negotiated by the client
+	ConnectedAt time.Time `json:"connected_at"`
+}
+
+// connectionRegistry tracks open client connections
+type connectionRegistry struct {
+	mu    sync.Mutex
+	conns map[string]ConnectionInfo
+}
+
+// TrackConnection records an open connection until the returned function is
+// called
+func (ps *PubSubSystem) TrackConnection(protocol, codec string) func() {
+	info := ConnectionInfo{
+		ID:          uuid.New().String(),
+		Protocol:    protocol,
+		Codec:       codec,
+		ConnectedAt: time.Now().UTC(),
+	}
+
+	ps.connections.mu.Lock()
+	ps.connections.conns[info.ID] = info
+	ps.connections.mu.Unlock()
+	metrics.Connections.WithLabelValues(protocol, codec).Inc()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			ps.connections.mu.Lock()
+			delete(ps.connections.conns, info.ID)
+			ps.connections.mu.Unlock()
+			metrics.Connections.WithLabelValues(protocol, codec).Dec()
+		})
+	}
+}
+
+// Connections returns the open connections, oldest first
+func (ps *PubSubSystem) Connections() []ConnectionInfo {
+	ps.connections.mu.Lock()
+	conns := make([]ConnectionInfo, 0, len(ps.connections.conns))
+	for _, info := range ps.connections.conns {
+		conns = append(conns, info)
+	}
+	ps.connections.mu.Unlock()
+
+	sort.Slice(conns, func(i, j int) bool { return conns[i].ConnectedAt.Before(conns[j].ConnectedAt) })
+	return conns
+}
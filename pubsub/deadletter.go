@@ -0,0 +1,125 @@
+package pubsub
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"pub-sub-system/metrics"
+	"pub-sub-system/models"
+)
+
+// Reasons recorded on dead-lettered messages
+const (
+	ReasonOverflow    = "overflow"     // Dropped by backpressure or a slow-consumer disconnect
+	ReasonMaxAttempts = "max_attempts" // Not acknowledged within the allowed delivery attempts
+	ReasonExpired     = "expired"      // TTL elapsed before delivery
+)
+
+// deadLetter hands a message a subscriber could not receive to the
+// subscriber's sink, if any. It must not be called with topic.Mu held.
+func deadLetter(sub *models.Subscriber, msg *models.Message, reason string) {
+	if sub.DeadLetters != nil {
+		sub.DeadLetters.DeadLetter(msg, sub.ID, reason)
+	}
+}
+
+// DeadLetter implements models.DeadLetterSink by publishing a wrapped copy of
+// the message to the dead-letter topic configured on its original topic.
+// Messages from topics without one, and dead-letter copies themselves, are
+// discarded.
+func (ps *PubSubSystem) DeadLetter(msg *models.Message, subscriberID, reason string) {
+	if msg.DeadLetter {
+		return
+	}
+
+	topic, exists := ps.GetTopic(msg.Topic)
+	if !exists {
+		return
+	}
+	topic.Mu.RLock()
+	target := topic.Settings.DeadLetterTopic
+	topic.Mu.RUnlock()
+	if target == "" || target == msg.Topic {
+		return
+	}
+
+	letter := &models.Message{
+		Payload: models.DeadLetter{
+			OriginalTopic:  msg.Topic,
+			SubscriberID:   subscriberID,
+			Reason:         reason,
+			DeadLetteredAt: time.Now().UTC(),
+			Message:        msg,
+		},
+	}
+	PrepareMessage(letter)
+	// The flag is stored and forwarded with the copy, so it survives WAL
+	// recovery and cluster forwarding
+	letter.DeadLetter = true
+	if err := ps.Publish(target, letter); err != nil {
+		log.Printf("Failed to dead-letter message %s from %s to %s: %v", msg.ID, msg.Topic, target, err)
+		return
+	}
+	metrics.DeadLetters.WithLabelValues(metrics.TopicLabel(msg.Topic), reason).Inc()
+}
+
+// ReplayDeadLetters republishes the original messages held in a dead-letter
+// topic to the topics they came from, keeping their IDs. Only messages whose
+// ID is listed are replayed, or every retained one when ids is empty. A
+// FORBIDDEN error is returned before anything is replayed if allowed rejects
+// one of the original topics. It returns the IDs of the replayed original
+// messages.
+func (ps *PubSubSystem) ReplayDeadLetters(dlqName string, ids []string, allowed func(topic string) bool) ([]string, error) {
+	topic, exists := ps.GetTopic(dlqName)
+	if !exists {
+		return nil, &models.Error{Code: "TOPIC_NOT_FOUND", Message: "Topic does not exist"}
+	}
+
+	wanted := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+	}
+
+	topic.Mu.RLock()
+	held := make([]*models.Message, 0, len(topic.Messages))
+	for _, msg := range topic.Messages {
+		if len(wanted) == 0 || wanted[msg.ID] {
+			held = append(held, msg)
+		}
+	}
+	topic.Mu.RUnlock()
+
+	letters := make([]models.DeadLetter, 0, len(held))
+	for _, msg := range held {
+		// Payloads recovered from disk or a peer are decoded JSON, so
+		// round-trip them to get a models.DeadLetter in every case
+		var letter models.DeadLetter
+		encoded, err := json.Marshal(msg.Payload)
+		if err == nil {
+			err = json.Unmarshal(encoded, &letter)
+		}
+		if err != nil || letter.Message == nil || letter.OriginalTopic == "" {
+			log.Printf("Skipping message %s in %s: not a dead letter", msg.ID, dlqName)
+			continue
+		}
+		if !allowed(letter.OriginalTopic) {
+			return nil, &models.Error{Code: "FORBIDDEN", Message: "Not allowed to publish to " + letter.OriginalTopic}
+		}
+		letters = append(letters, letter)
+	}
+
+	replayed := make([]string, 0, len(letters))
+	for _, letter := range letters {
+		original := &models.Message{ID: letter.Message.ID, Payload: letter.Message.Payload, ExpiresAt: letter.Message.ExpiresAt}
+		if original.Expired(time.Now()) {
+			log.Printf("Skipping message %s in %s: expired", original.ID, dlqName)
+			continue
+		}
+		if err := ps.Publish(letter.OriginalTopic, original); err != nil {
+			return replayed, err
+		}
+		replayed = append(replayed, original.ID)
+	}
+	return replayed, nil
+}
@@ -0,0 +1,69 @@
+package pubsub
+
+import (
+	"encoding/json"
+	"testing"
+
+	"pub-sub-system/models"
+)
+
+func TestDeadLetterFlagSurvivesEncoding(t *testing.T) {
+	ps := NewPubSubSystem()
+	defer ps.Close()
+
+	// orders dead-letters to orders.dlq, which dead-letters to orders.dlq2
+	for _, topic := range []struct{ name, dlq string }{
+		{"orders.dlq2", ""},
+		{"orders.dlq", "orders.dlq2"},
+		{"orders", "orders.dlq"},
+	} {
+		settings := ps.DefaultSettings()
+		settings.DeadLetterTopic = topic.dlq
+		if _, err := ps.NewTopicWithSettings(topic.name, settings, DefaultRetention()); err != nil {
+			t.Fatalf("NewTopicWithSettings(%s) error = %v", topic.name, err)
+		}
+	}
+
+	msg := &models.Message{Payload: "x"}
+	if err := PrepareMessage(msg); err != nil {
+		t.Fatalf("PrepareMessage() error = %v", err)
+	}
+	if err := ps.Publish("orders", msg); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	ps.DeadLetter(msg, "client", ReasonOverflow)
+
+	dlq, _ := ps.GetTopic("orders.dlq")
+	letters := ps.topicManager.GetLastMessages(dlq, 0)
+	if len(letters) != 1 || !letters[0].DeadLetter {
+		t.Fatalf("orders.dlq holds %d messages, want one flagged dead letter", len(letters))
+	}
+
+	// A copy recovered from the WAL or forwarded by a peer is decoded from
+	// JSON and must still not be dead-lettered again
+	encoded, err := json.Marshal(letters[0])
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	var decoded models.Message
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	decoded.Topic = "orders.dlq"
+	ps.DeadLetter(&decoded, "client", ReasonOverflow)
+
+	dlq2, _ := ps.GetTopic("orders.dlq2")
+	if n := len(ps.topicManager.GetLastMessages(dlq2, 0)); n != 0 {
+		t.Errorf("orders.dlq2 holds %d messages, want a decoded dead letter not to be dead-lettered again", n)
+	}
+}
+
+func TestPrepareMessageClearsDeadLetterFlag(t *testing.T) {
+	msg := &models.Message{Payload: "x", DeadLetter: true}
+	if err := PrepareMessage(msg); err != nil {
+		t.Fatalf("PrepareMessage() error = %v", err)
+	}
+	if msg.DeadLetter {
+		t.Error("client-supplied dead_letter flag was kept")
+	}
+}
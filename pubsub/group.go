@@ -0,0 +1,107 @@
+package pubsub
+
+import (
+	"log"
+	"sort"
+
+	"pub-sub-system/models"
+)
+
+// Consumer group balancing strategies, selected with CONSUMER_GROUP_STRATEGY
+const (
+	GroupRoundRobin  = "round_robin"
+	GroupLeastLoaded = "least_loaded"
+)
+
+// pickGroupMember chooses which member of a consumer group receives the next
+// message. Callers must hold topic.Mu for writing.
+func (tm *TopicManager) pickGroupMember(topic *models.Topic, group string, members []*models.Subscriber) *models.Subscriber {
+	// Sort for a stable rotation order since map iteration is random
+	sort.Slice(members, func(i, j int) bool { return members[i].ID < members[j].ID })
+
+	if topic.GroupCursors == nil {
+		topic.GroupCursors = make(map[string]int)
+	}
+	start := topic.GroupCursors[group] % len(members)
+	topic.GroupCursors[group] = start + 1
+
+	if tm.groupStrategy == GroupLeastLoaded {
+		best := members[start]
+		for i := 1; i < len(members); i++ {
+			candidate := members[(start+i)%len(members)]
+			if len(candidate.Queue) < len(best.Queue) {
+				best = candidate
+			}
+		}
+		return best
+	}
+
+	// Round robin, skipping members whose queue is already full
+	for i := 0; i < len(members); i++ {
+		candidate := members[(start+i)%len(members)]
+		if len(candidate.Queue) < cap(candidate.Queue) {
+			return candidate
+		}
+	}
+	return members[start]
+}
+
+// Redistribute hands messages that were queued or in flight for a departed
+// group member to the members that remain
+func (tm *TopicManager) Redistribute(topic *models.Topic, group string, msgs []*models.Message) {
+	for i, msg := range msgs {
+		topic.Mu.Lock()
+		members := make([]*models.Subscriber, 0)
+		matching := make([]*models.Subscriber, 0)
+		for _, sub := range topic.Subscribers {
+			if sub.Group == group {
+				members = append(members, sub)
+				if wants(sub, msg) {
+					matching = append(matching, sub)
+				}
+			}
+		}
+		if len(members) == 0 {
+			topic.Mu.Unlock()
+			log.Printf("Consumer group %s on topic %s has no members left; %d queued messages dropped", group, topic.Name, len(msgs)-i)
+			return
+		}
+		if len(matching) == 0 {
+			topic.Mu.Unlock()
+			continue // No remaining member's filter accepts it
+		}
+		target := tm.pickGroupMember(topic, group, matching)
+		delivered := enqueue(target, msg)
+		topic.Mu.Unlock()
+
+		if !delivered {
+			deadLetter(target, msg, ReasonOverflow)
+			tm.disconnectSlowConsumer(target)
+		}
+	}
+}
+
+// takePending removes and returns every message a subscriber has not yet
+// finished with: unacknowledged in-flight messages followed by whatever is
+// still buffered in its queue
+func takePending(sub *models.Subscriber) []*models.Message {
+	sub.InFlightMu.Lock()
+	pending := make([]*models.Message, 0, len(sub.InFlight)+len(sub.Queue))
+	for id, entry := range sub.InFlight {
+		pending = append(pending, entry.Message)
+		delete(sub.InFlight, id)
+	}
+	sub.InFlightMu.Unlock()
+
+	for {
+		select {
+		case msg := <-sub.Queue:
+			if msg == nil {
+				return pending
+			}
+			pending = append(pending, msg)
+		default:
+			return pending
+		}
+	}
+}
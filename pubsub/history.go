@@ -0,0 +1,153 @@
+package pubsub
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"pub-sub-system/models"
+)
+
+// HistoryRequest selects the stored messages replayed to a new subscriber.
+// ResumeFrom takes precedence over FromOffset, which takes precedence over
+// AfterID, which takes precedence over LastN.
+type HistoryRequest struct {
+	ResumeFrom *int64 // Like FromOffset, but replays what is still retained instead of failing
+	FromOffset *int64
+	AfterID    string
+	LastN      int
+}
+
+// requested reports whether any history was asked for
+func (req HistoryRequest) requested() bool {
+	return req.ResumeFrom != nil || req.FromOffset != nil || req.AfterID != "" || req.LastN > 0
+}
+
+// AddSubscriberWithHistory adds a subscriber to a topic and snapshots the
+// history it asked for under the same lock. The snapshot becomes the
+// subscriber's backlog, which its processor sends before any live message,
+// and live messages already covered by the snapshot are skipped, so replay
+// and live delivery neither overlap nor leave a gap.
+func (tm *TopicManager) AddSubscriberWithHistory(topic *models.Topic, sub *models.Subscriber, req HistoryRequest) error {
+	topic.Mu.Lock()
+	defer topic.Mu.Unlock()
+
+	var backlog []*models.Message
+	switch {
+	case req.ResumeFrom != nil:
+		backlog = messagesSince(topic, *req.ResumeFrom)
+	case req.FromOffset != nil:
+		var err error
+		if backlog, err = messagesFrom(topic, *req.FromOffset); err != nil {
+			return err
+		}
+	case req.AfterID != "":
+		backlog, _ = messagesAfter(topic, req.AfterID)
+	case req.LastN > 0:
+		backlog = lastMessages(topic, req.LastN)
+	}
+
+	if heldElsewhere(topic.Subscribers, sub) {
+		return errHeldElsewhere()
+	}
+	if atCapacity(topic, sub) {
+		return fmt.Errorf("maximum subscribers reached for topic")
+	}
+	applyQueueSize(sub, topic.Settings.QueueSize)
+
+	cursor := topic.NextOffset
+	if req.requested() {
+		sub.Backlog = append(sub.Backlog, backlog...)
+		skipBelow(sub, topic)
+		if len(backlog) > 0 {
+			cursor = backlog[0].Offset
+		}
+	}
+	initCursor(sub, topic.Name, cursor)
+	sub.DeadLetters = topic.DeadLetters
+	replaceSubscriber(topic, sub)
+	return nil
+}
+
+// skipBelow marks live messages on a topic that are already covered by a
+// subscriber's backlog. Callers must hold topic.Mu.
+func skipBelow(sub *models.Subscriber, topic *models.Topic) {
+	if sub.SkipBelow == nil {
+		sub.SkipBelow = make(map[string]int64)
+	}
+	sub.SkipBelow[topic.Name] = topic.NextOffset
+}
+
+// messagesSince returns the retained messages starting at an offset. Unlike
+// messagesFrom it never fails: an evicted offset starts at the oldest
+// retained message, and an offset beyond the next one (the topic was
+// recreated) replays the whole history. Callers must hold topic.Mu.
+func messagesSince(topic *models.Topic, offset int64) []*models.Message {
+	if offset > topic.NextOffset {
+		offset = 0
+	}
+	start := sort.Search(len(topic.Messages), func(i int) bool {
+		return topic.Messages[i].Offset >= offset
+	})
+	return unexpired(topic.Messages[start:], time.Now())
+}
+
+// lastMessages returns the last n unexpired messages. Callers must hold
+// topic.Mu.
+func lastMessages(topic *models.Topic, n int) []*models.Message {
+	if n <= 0 || n > len(topic.Messages) {
+		n = len(topic.Messages)
+	}
+
+	now := time.Now()
+	start := len(topic.Messages)
+	for found := 0; start > 0 && found < n; start-- {
+		if !topic.Messages[start-1].Expired(now) {
+			found++
+		}
+	}
+	return unexpired(topic.Messages[start:], now)
+}
+
+// messagesFrom returns the messages starting at an offset. Callers must hold
+// topic.Mu.
+func messagesFrom(topic *models.Topic, offset int64) ([]*models.Message, error) {
+	oldest := topic.NextOffset
+	if len(topic.Messages) > 0 {
+		oldest = topic.Messages[0].Offset
+	}
+
+	if offset < oldest || offset > topic.NextOffset {
+		return nil, &models.Error{
+			Code:    "OFFSET_OUT_OF_RANGE",
+			Message: fmt.Sprintf("offset %d is outside the available range [%d, %d]", offset, oldest, topic.NextOffset),
+		}
+	}
+
+	start := sort.Search(len(topic.Messages), func(i int) bool {
+		return topic.Messages[i].Offset >= offset
+	})
+	return unexpired(topic.Messages[start:], time.Now()), nil
+}
+
+// messagesAfter returns the messages stored after the message with the given
+// ID. Callers must hold topic.Mu.
+func messagesAfter(topic *models.Topic, messageID string) ([]*models.Message, bool) {
+	for i := len(topic.Messages) - 1; i >= 0; i-- {
+		if topic.Messages[i].ID == messageID {
+			return unexpired(topic.Messages[i+1:], time.Now()), true
+		}
+	}
+	return nil, false
+}
+
+// unexpired returns a copy of messages without those expired at now
+func unexpired(messages []*models.Message, now time.Time) []*models.Message {
+	result := make([]*models.Message, 0, len(messages))
+	for _, msg := range messages {
+		if !msg.Expired(now) {
+			result = append(result, msg)
+		}
+	}
+	return result
+}
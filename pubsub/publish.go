@@ -0,0 +1,119 @@
+package pubsub
+
+import (
+	"log"
+	"time"
+
+	"pub-sub-system/metrics"
+	"pub-sub-system/models"
+
+	"github.com/google/uuid"
+)
+
+// PrepareMessage assigns an ID to a message that has none and validates the
+// format of one that was provided, along with its expiry. The dead-letter
+// flag is cleared, since only the server marks dead-letter copies.
+func PrepareMessage(msg *models.Message) error {
+	msg.DeadLetter = false
+	if msg.TTLMs < 0 {
+		return &models.Error{Code: "BAD_REQUEST", Message: "message.ttl_ms must not be negative"}
+	}
+	if msg.TTLMs > 0 && msg.ExpiresAt != nil {
+		return &models.Error{Code: "BAD_REQUEST", Message: "message.ttl_ms and message.expires_at are mutually exclusive"}
+	}
+	if msg.Expired(time.Now()) {
+		return &models.Error{Code: "BAD_REQUEST", Message: "message.expires_at is in the past"}
+	}
+	if msg.DelayMs < 0 {
+		return &models.Error{Code: "BAD_REQUEST", Message: "message.delay_ms must not be negative"}
+	}
+	if msg.DelayMs > 0 && msg.DeliverAt != nil {
+		return &models.Error{Code: "BAD_REQUEST", Message: "message.delay_ms and message.deliver_at are mutually exclusive"}
+	}
+
+	if msg.ID == "" {
+		msg.ID = uuid.New().String()
+		return nil
+	}
+
+	// Validate UUID format if provided
+	if _, err := uuid.Parse(msg.ID); err != nil {
+		return &models.Error{Code: "BAD_REQUEST", Message: "message.id must be a valid UUID"}
+	}
+	return nil
+}
+
+// Publish stores a prepared message in a topic's history and broadcasts it
+// to the topic's subscribers. A message with a future deliver_at or a
+// delay_ms is held by the scheduler and published when due. Errors are
+// *models.Error values carrying the protocol error code.
+func (ps *PubSubSystem) Publish(topicName string, msg *models.Message) error {
+	if err := ps.ValidatePayload(topicName, msg); err != nil {
+		return err
+	}
+	if scheduled, err := ps.schedule(topicName, msg); scheduled || err != nil {
+		return err
+	}
+
+	if err := ps.publish(topicName, msg); err != nil {
+		return err
+	}
+
+	if replicator := ps.Replicator(); replicator != nil {
+		replicator.Published(topicName, msg)
+	}
+	return nil
+}
+
+// Receivers returns how many subscribers a message published to a topic is
+// delivered to on this node: subscribers whose filter matches it, including
+// pattern subscribers attached to the topic, with each consumer group
+// counted once
+func (ps *PubSubSystem) Receivers(topicName string, msg *models.Message) int {
+	topic, exists := ps.GetTopic(topicName)
+	if !exists {
+		return 0
+	}
+
+	topic.Mu.RLock()
+	defer topic.Mu.RUnlock()
+
+	count := 0
+	groups := make(map[string]bool)
+	for _, sub := range topic.Subscribers {
+		if !wants(sub, msg) {
+			continue
+		}
+		if sub.Group == "" {
+			count++
+		} else if !groups[sub.Group] {
+			groups[sub.Group] = true
+			count++
+		}
+	}
+	return count
+}
+
+// publish stores and broadcasts a message on this node only
+func (ps *PubSubSystem) publish(topicName string, msg *models.Message) error {
+	if IsPattern(topicName) {
+		return &models.Error{Code: "BAD_REQUEST", Message: "Cannot publish to a topic pattern"}
+	}
+
+	topic, exists := ps.GetTopic(topicName)
+	if !exists {
+		return &models.Error{Code: "TOPIC_NOT_FOUND", Message: "Topic does not exist"}
+	}
+
+	// Add message to topic history
+	if err := ps.topicManager.AddMessage(topic, msg); err != nil {
+		log.Printf("Error storing message for topic %s: %v", topicName, err)
+		return &models.Error{Code: "INTERNAL", Message: "Failed to store message"}
+	}
+
+	metrics.Publishes.WithLabelValues(metrics.TopicLabel(topicName)).Inc()
+
+	// Broadcast to all subscribers
+	ps.topicManager.Broadcast(topic, msg)
+	return nil
+}
@@ -0,0 +1,99 @@
+package pubsub
+
+import (
+	"path/filepath"
+	"testing"
+
+	"pub-sub-system/models"
+)
+
+// publishTest publishes a payload to a topic and returns the stored message
+func publishTest(t *testing.T, ps *PubSubSystem, topic string, payload interface{}) *models.Message {
+	t.Helper()
+	msg := &models.Message{Payload: payload}
+	if err := PrepareMessage(msg); err != nil {
+		t.Fatalf("PrepareMessage() error = %v", err)
+	}
+	if err := ps.Publish(topic, msg); err != nil {
+		t.Fatalf("Publish(%s) error = %v", topic, err)
+	}
+	return msg
+}
+
+func TestRecoveryRebuildsTopicsAndContinuesOffsets(t *testing.T) {
+	t.Setenv("WAL_DIR", t.TempDir())
+	t.Setenv("WAL_FSYNC", "never")
+
+	ps := NewPubSubSystem()
+	retention := DefaultRetention()
+	retention.MaxMessages = 10
+	if _, err := ps.NewTopicWithRetention("orders", retention); err != nil {
+		t.Fatalf("NewTopicWithRetention() error = %v", err)
+	}
+	if _, err := ps.NewTopic("empty"); err != nil {
+		t.Fatalf("NewTopic() error = %v", err)
+	}
+	for _, payload := range []string{"a", "b", "c"} {
+		publishTest(t, ps, "orders", payload)
+	}
+	if err := ps.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	ps = NewPubSubSystem()
+	defer ps.Close()
+
+	if got := len(ps.ListTopics()); got != 2 {
+		t.Fatalf("recovered %d topics, want 2", got)
+	}
+	topic, exists := ps.GetTopic("orders")
+	if !exists {
+		t.Fatal("topic orders was not recovered")
+	}
+	if topic.Retention.MaxMessages != 10 {
+		t.Errorf("recovered max_messages = %d, want 10", topic.Retention.MaxMessages)
+	}
+	history := ps.topicManager.GetLastMessages(topic, 10)
+	if len(history) != 3 {
+		t.Fatalf("recovered %d messages, want 3", len(history))
+	}
+	for i, msg := range history {
+		if msg.Offset != int64(i) || msg.Topic != "orders" {
+			t.Errorf("message %d recovered as %s offset %d", i, msg.Topic, msg.Offset)
+		}
+	}
+
+	if msg := publishTest(t, ps, "orders", "d"); msg.Offset != 3 {
+		t.Errorf("first offset after recovery = %d, want 3", msg.Offset)
+	}
+}
+
+func TestRecoveryDeletesSegmentsOnlyThroughRetention(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("WAL_DIR", dir)
+	t.Setenv("WAL_FSYNC", "never")
+	t.Setenv("WAL_SEGMENT_BYTES", "1") // One record per segment
+
+	ps := NewPubSubSystem()
+	retention := DefaultRetention()
+	retention.MaxMessages = 20
+	if _, err := ps.NewTopicWithRetention("orders", retention); err != nil {
+		t.Fatalf("NewTopicWithRetention() error = %v", err)
+	}
+	for i := 0; i < 12; i++ {
+		publishTest(t, ps, "orders", i)
+	}
+	ps.Close()
+
+	segments, _ := filepath.Glob(filepath.Join(dir, "t_orders", "*.seg"))
+	if len(segments) != 12 {
+		t.Fatalf("segments = %d, want 12 while every message is retained", len(segments))
+	}
+
+	ps = NewPubSubSystem()
+	defer ps.Close()
+	topic, _ := ps.GetTopic("orders")
+	if got := len(ps.topicManager.GetLastMessages(topic, 20)); got != 12 {
+		t.Errorf("recovered %d messages, want 12", got)
+	}
+}
@@ -0,0 +1,107 @@
+package pubsub
+
+import (
+	"pub-sub-system/models"
+)
+
+// Replicator propagates changes made on this node to the rest of a cluster.
+// Its methods are called after the change has been applied locally and must
+// not block.
+type Replicator interface {
+	TopicCreated(name string, settings models.TopicSettings, retention models.RetentionPolicy)
+	TopicUpdated(name string, settings models.TopicSettings, retention models.RetentionPolicy)
+	TopicDeleted(name string)
+	Published(topic string, msg *models.Message)
+}
+
+// SetReplicator installs the cluster replicator, or removes it when nil. It
+// may be called while the system is in use.
+func (ps *PubSubSystem) SetReplicator(replicator Replicator) {
+	if replicator == nil {
+		ps.replicator.Store(nil)
+		return
+	}
+	ps.replicator.Store(&replicator)
+}
+
+// Replicator returns the installed replicator, or nil outside cluster mode
+func (ps *PubSubSystem) Replicator() Replicator {
+	if replicator := ps.replicator.Load(); replicator != nil {
+		return *replicator
+	}
+	return nil
+}
+
+// ApplyRemoteTopic creates or updates a topic replicated from another node
+// without propagating it further
+func (ps *PubSubSystem) ApplyRemoteTopic(name string, settings models.TopicSettings, retention models.RetentionPolicy) error {
+	if _, exists := ps.GetTopic(name); !exists {
+		_, err := ps.createTopic(name, settings, retention)
+		if err == nil || err.Error() != "topic already exists" {
+			return err
+		}
+	}
+
+	labels := settings.Labels
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	_, err := ps.updateTopic(name, TopicUpdate{
+		MaxSubscribers: &settings.MaxSubscribers,
+		QueueSize:      &settings.QueueSize,
+		Backpressure:   &settings.Backpressure,
+		BlockTimeoutMs: &settings.BlockTimeoutMs,
+		DeadLetter:     &settings.DeadLetterTopic,
+		Schema:         &settings.Schema,
+		SchemaVersion:  &settings.SchemaVersion,
+		Description:    &settings.Description,
+		Labels:         labels,
+		Retention:      &retention,
+	})
+	return err
+}
+
+// ApplyRemoteDelete deletes a topic deleted on another node without
+// propagating it further. Deleting a topic that does not exist succeeds.
+func (ps *PubSubSystem) ApplyRemoteDelete(name string) error {
+	if err := ps.deleteTopic(name); err != nil && err.Error() != "topic not found" {
+		return err
+	}
+	return nil
+}
+
+// ApplyRemotePublish publishes a message forwarded by another node to the
+// local subscribers without forwarding it again. The message keeps its ID
+// but is assigned an offset in this node's history.
+func (ps *PubSubSystem) ApplyRemotePublish(topicName string, msg *models.Message) error {
+	return ps.publish(topicName, msg)
+}
+
+// LocalInterest returns the topics that have exact subscribers on this node
+// and the wildcard patterns subscribed to on this node
+func (ps *PubSubSystem) LocalInterest() ([]string, []string) {
+	ps.Mu.RLock()
+	defer ps.Mu.RUnlock()
+
+	topics := make([]string, 0)
+	for name, topic := range ps.Topics {
+		topic.Mu.RLock()
+		for _, sub := range topic.Subscribers {
+			if !IsPattern(sub.Topic) {
+				topics = append(topics, name)
+				break
+			}
+		}
+		topic.Mu.RUnlock()
+	}
+
+	seen := make(map[string]bool)
+	patterns := make([]string, 0)
+	for _, sub := range ps.Wildcards {
+		if !seen[sub.Topic] {
+			seen[sub.Topic] = true
+			patterns = append(patterns, sub.Topic)
+		}
+	}
+	return topics, patterns
+}
@@ -0,0 +1,149 @@
+package pubsub
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"pub-sub-system/env"
+	"pub-sub-system/metrics"
+	"pub-sub-system/models"
+)
+
+// DefaultRetention returns the retention policy applied to topics created
+// without one, configured by TOPIC_HISTORY_SIZE, TOPIC_MAX_AGE_MS and
+// TOPIC_MAX_BYTES
+func DefaultRetention() models.RetentionPolicy {
+	return models.RetentionPolicy{
+		MaxMessages: env.Int("TOPIC_HISTORY_SIZE", 100),
+		MaxAgeMs:    int64(env.Int("TOPIC_MAX_AGE_MS", 0)),
+		MaxBytes:    int64(env.Int("TOPIC_MAX_BYTES", 0)),
+	}
+}
+
+// ValidateRetention checks that a retention policy has no negative limits
+func ValidateRetention(policy models.RetentionPolicy) error {
+	if policy.MaxMessages < 0 || policy.MaxAgeMs < 0 || policy.MaxBytes < 0 {
+		return fmt.Errorf("retention limits must not be negative")
+	}
+	return nil
+}
+
+// topicMeta is the per-topic configuration persisted alongside the log
+type topicMeta struct {
+	Retention models.RetentionPolicy `json:"retention"`
+	Settings  *models.TopicSettings  `json:"settings,omitempty"` // Absent in logs written before settings existed
+}
+
+// payloadSize returns the encoded size of a message payload
+func payloadSize(msg *models.Message) int {
+	data, err := json.Marshal(msg.Payload)
+	if err != nil {
+		return 0
+	}
+	return len(data)
+}
+
+// enforceRetention evicts the oldest messages until the topic satisfies its
+// retention policy. Callers must hold topic.Mu for writing.
+func enforceRetention(topic *models.Topic, now time.Time) int {
+	policy := topic.Retention
+	evicted := 0
+
+	for len(topic.Messages) > 0 {
+		oldest := topic.Messages[0]
+		overCount := policy.MaxMessages > 0 && len(topic.Messages) > policy.MaxMessages
+		overBytes := policy.MaxBytes > 0 && topic.HistoryBytes > policy.MaxBytes
+		overAge := policy.MaxAgeMs > 0 && now.Sub(oldest.PublishedAt) > time.Duration(policy.MaxAgeMs)*time.Millisecond
+		if !overCount && !overBytes && !overAge {
+			break
+		}
+
+		topic.Messages[0] = nil
+		topic.Messages = topic.Messages[1:]
+		topic.HistoryBytes -= int64(oldest.Size)
+		evicted++
+	}
+	return evicted
+}
+
+// purgeExpired removes expired messages from anywhere in a topic's history,
+// since messages with different TTLs do not expire in offset order. Callers
+// must hold topic.Mu for writing.
+func purgeExpired(topic *models.Topic, now time.Time) int {
+	kept := topic.Messages[:0]
+	for _, msg := range topic.Messages {
+		if msg.Expired(now) {
+			topic.HistoryBytes -= int64(msg.Size)
+			continue
+		}
+		kept = append(kept, msg)
+	}
+
+	purged := len(topic.Messages) - len(kept)
+	for i := len(kept); i < len(topic.Messages); i++ {
+		topic.Messages[i] = nil
+	}
+	topic.Messages = kept
+	return purged
+}
+
+// truncateLog drops log segments that only hold messages no longer in the
+// topic's history. Callers must hold topic.Mu.
+func truncateLog(topic *models.Topic) {
+	if topic.Log == nil {
+		return
+	}
+
+	oldest := topic.NextOffset
+	if len(topic.Messages) > 0 {
+		oldest = topic.Messages[0].Offset
+	}
+	if err := topic.Log.TruncateBefore(oldest); err != nil {
+		log.Printf("Failed to truncate log for topic %s: %v", topic.Name, err)
+	}
+}
+
+// runRetentionSweeper periodically applies retention to every topic and
+// purges expired messages so that age limits and TTLs take effect on topics
+// that are no longer being published to
+func (ps *PubSubSystem) runRetentionSweeper(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			now := time.Now()
+			for _, topic := range ps.ListTopics() {
+				topic.Mu.Lock()
+				evicted := enforceRetention(topic, now)
+				if purged := purgeExpired(topic, now); purged > 0 {
+					metrics.Expired.WithLabelValues(metrics.TopicLabel(topic.Name)).Add(float64(purged))
+					evicted += purged
+				}
+				if evicted > 0 {
+					truncateLog(topic)
+				}
+				topic.Mu.Unlock()
+			}
+		case <-ps.stopSweeper:
+			return
+		}
+	}
+}
+
+// retentionUsage reports a topic's current history usage for /stats.
+// Callers must hold topic.Mu.
+func retentionUsage(topic *models.Topic) map[string]interface{} {
+	oldestAgeMs := int64(0)
+	if len(topic.Messages) > 0 {
+		oldestAgeMs = time.Since(topic.Messages[0].PublishedAt).Milliseconds()
+	}
+	return map[string]interface{}{
+		"messages":      len(topic.Messages),
+		"bytes":         topic.HistoryBytes,
+		"oldest_age_ms": oldestAgeMs,
+	}
+}
@@ -0,0 +1,256 @@
+package pubsub
+
+import (
+	"container/heap"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"pub-sub-system/models"
+)
+
+// ScheduledMessage is a message held until its delivery time
+type ScheduledMessage struct {
+	Topic     string          `json:"topic"`
+	DeliverAt time.Time       `json:"deliver_at"`
+	Message   *models.Message `json:"message"`
+	index     int             // Position in the scheduler's heap
+}
+
+// scheduleHeap orders scheduled messages by delivery time
+type scheduleHeap []*ScheduledMessage
+
+func (h scheduleHeap) Len() int           { return len(h) }
+func (h scheduleHeap) Less(i, j int) bool { return h[i].DeliverAt.Before(h[j].DeliverAt) }
+func (h scheduleHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *scheduleHeap) Push(x interface{}) {
+	entry := x.(*ScheduledMessage)
+	entry.index = len(*h)
+	*h = append(*h, entry)
+}
+
+func (h *scheduleHeap) Pop() interface{} {
+	old := *h
+	entry := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	entry.index = -1
+	return entry
+}
+
+// scheduler holds delayed messages in a min-heap and publishes each one when
+// it becomes due. Scheduled messages are kept in memory only.
+type scheduler struct {
+	mu      sync.Mutex
+	queue   scheduleHeap
+	byKey   map[string]*ScheduledMessage // Keyed by scheduleKey
+	max     int
+	wake    chan struct{}
+	stop    chan struct{}
+	publish func(topic string, msg *models.Message)
+}
+
+func newScheduler(max int, publish func(topic string, msg *models.Message)) *scheduler {
+	return &scheduler{
+		byKey:   make(map[string]*ScheduledMessage),
+		max:     max,
+		wake:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		publish: publish,
+	}
+}
+
+// scheduleKey identifies a scheduled message; IDs only need to be unique
+// within a topic
+func scheduleKey(topic, id string) string {
+	return topic + "\x00" + id
+}
+
+// add schedules a message for publishing to a topic at deliverAt
+func (s *scheduler) add(topic string, msg *models.Message, deliverAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := scheduleKey(topic, msg.ID)
+	if _, exists := s.byKey[key]; exists {
+		return &models.Error{Code: "BAD_REQUEST", Message: "A message with this ID is already scheduled"}
+	}
+	if len(s.queue) >= s.max {
+		return &models.Error{Code: "BAD_REQUEST", Message: "Maximum scheduled messages reached"}
+	}
+
+	entry := &ScheduledMessage{Topic: topic, DeliverAt: deliverAt, Message: msg}
+	heap.Push(&s.queue, entry)
+	s.byKey[key] = entry
+
+	// Wake the loop if this message is now the next one due
+	if entry.index == 0 {
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// cancel removes a scheduled message and reports whether it was pending
+func (s *scheduler) cancel(topic, id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, exists := s.byKey[scheduleKey(topic, id)]
+	if !exists {
+		return false
+	}
+	heap.Remove(&s.queue, entry.index)
+	delete(s.byKey, scheduleKey(topic, id))
+	return true
+}
+
+// cancelTopic removes every message scheduled for a topic
+func (s *scheduler) cancelTopic(topic string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, entry := range s.byKey {
+		if entry.Topic == topic {
+			heap.Remove(&s.queue, entry.index)
+			delete(s.byKey, key)
+		}
+	}
+}
+
+// pending returns the messages scheduled for a topic, earliest first
+func (s *scheduler) pending(topic string) []*ScheduledMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make([]*ScheduledMessage, 0)
+	for _, entry := range s.queue {
+		if entry.Topic == topic {
+			// Copy so callers can read them while the scheduler publishes
+			snapshot := *entry
+			msg := *entry.Message
+			snapshot.Message = &msg
+			result = append(result, &snapshot)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].DeliverAt.Before(result[j].DeliverAt) })
+	return result
+}
+
+// count returns the number of scheduled messages per topic
+func (s *scheduler) count() map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counts := make(map[string]int)
+	for _, entry := range s.queue {
+		counts[entry.Topic]++
+	}
+	return counts
+}
+
+// run publishes messages as they become due until close is called
+func (s *scheduler) run() {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		due := s.takeDue(time.Now())
+		for _, entry := range due {
+			s.publish(entry.Topic, entry.Message)
+		}
+
+		s.mu.Lock()
+		wait := time.Hour
+		if len(s.queue) > 0 {
+			wait = time.Until(s.queue[0].DeliverAt)
+		}
+		s.mu.Unlock()
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+
+		select {
+		case <-timer.C:
+		case <-s.wake:
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// takeDue removes and returns the messages due at now
+func (s *scheduler) takeDue(now time.Time) []*ScheduledMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	due := make([]*ScheduledMessage, 0)
+	for len(s.queue) > 0 && !s.queue[0].DeliverAt.After(now) {
+		entry := heap.Pop(&s.queue).(*ScheduledMessage)
+		delete(s.byKey, scheduleKey(entry.Topic, entry.Message.ID))
+		due = append(due, entry)
+	}
+	return due
+}
+
+// close stops the scheduler; messages still pending are discarded
+func (s *scheduler) close() {
+	close(s.stop)
+}
+
+// schedule holds a message with a future delivery time instead of
+// publishing it. It reports whether the message was scheduled.
+func (ps *PubSubSystem) schedule(topicName string, msg *models.Message) (bool, error) {
+	if msg.DelayMs > 0 && msg.DeliverAt == nil {
+		deliverAt := time.Now().UTC().Add(time.Duration(msg.DelayMs) * time.Millisecond)
+		msg.DeliverAt = &deliverAt
+	}
+	if msg.DeliverAt == nil || !msg.DeliverAt.After(time.Now()) {
+		return false, nil
+	}
+
+	if IsPattern(topicName) {
+		return false, &models.Error{Code: "BAD_REQUEST", Message: "Cannot publish to a topic pattern"}
+	}
+	if _, exists := ps.GetTopic(topicName); !exists {
+		return false, &models.Error{Code: "TOPIC_NOT_FOUND", Message: "Topic does not exist"}
+	}
+	if err := ps.scheduler.add(topicName, msg, *msg.DeliverAt); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// publishScheduled publishes a message whose delivery time has come
+func (ps *PubSubSystem) publishScheduled(topicName string, msg *models.Message) {
+	if err := ps.Publish(topicName, msg); err != nil {
+		log.Printf("Failed to publish scheduled message %s to %s: %v", msg.ID, topicName, err)
+	}
+}
+
+// ScheduledMessages returns the messages waiting to be published to a topic
+func (ps *PubSubSystem) ScheduledMessages(topicName string) ([]*ScheduledMessage, error) {
+	if _, exists := ps.GetTopic(topicName); !exists {
+		return nil, &models.Error{Code: "TOPIC_NOT_FOUND", Message: "Topic does not exist"}
+	}
+	return ps.scheduler.pending(topicName), nil
+}
+
+// CancelScheduled discards a message waiting to be published to a topic and
+// reports whether it was still pending
+func (ps *PubSubSystem) CancelScheduled(topicName, messageID string) bool {
+	return ps.scheduler.cancel(topicName, messageID)
+}
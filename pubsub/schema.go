@@ -0,0 +1,64 @@
+package pubsub
+
+import (
+	"fmt"
+
+	"pub-sub-system/models"
+	"pub-sub-system/schema"
+)
+
+// ValidatePayload checks a message's payload against the schema bound to a
+// topic. Mismatches return a SCHEMA_VIOLATION error carrying the failing
+// path. Topics without a schema, and unknown topics, pass.
+func (ps *PubSubSystem) ValidatePayload(topicName string, msg *models.Message) error {
+	topic, exists := ps.GetTopic(topicName)
+	if !exists {
+		return nil
+	}
+	topic.Mu.RLock()
+	subject, version := topic.Settings.Schema, topic.Settings.SchemaVersion
+	topic.Mu.RUnlock()
+	if subject == "" {
+		return nil
+	}
+
+	used, err := ps.Schemas.Validate(subject, version, msg.Payload)
+	if err == nil {
+		return nil
+	}
+	if violation, ok := err.(*schema.Violation); ok {
+		return &models.Error{
+			Code:    "SCHEMA_VIOLATION",
+			Message: fmt.Sprintf("Payload does not match schema %s version %d at %s: %s", subject, used, violation.Path, violation.Message),
+			Path:    violation.Path,
+		}
+	}
+	// The bound schema is missing, e.g. on a node it was not registered on;
+	// reject rather than let unchecked payloads through
+	return &models.Error{Code: "SCHEMA_VIOLATION", Message: err.Error()}
+}
+
+// checkSchemaBinding verifies that the schema a topic is being bound to is
+// registered
+func (ps *PubSubSystem) checkSchemaBinding(settings models.TopicSettings) error {
+	if settings.Schema == "" {
+		return nil
+	}
+	if _, exists := ps.Schemas.Version(settings.Schema, settings.SchemaVersion); !exists {
+		return fmt.Errorf("invalid settings: %s is not registered", schema.Describe(settings.Schema, settings.SchemaVersion))
+	}
+	return nil
+}
+
+// DeleteSchema removes a registry subject unless a topic is bound to it
+func (ps *PubSubSystem) DeleteSchema(subject string) error {
+	for _, topic := range ps.ListTopics() {
+		topic.Mu.RLock()
+		bound := topic.Settings.Schema == subject
+		topic.Mu.RUnlock()
+		if bound {
+			return fmt.Errorf("schema is bound to topic %s", topic.Name)
+		}
+	}
+	return ps.Schemas.Delete(subject)
+}
@@ -0,0 +1,225 @@
+package pubsub
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"pub-sub-system/env"
+	"pub-sub-system/models"
+
+	"github.com/google/uuid"
+)
+
+// sessionTakeoverTimeout bounds how long a resume waits for the connection
+// that still holds the session to shut down
+const sessionTakeoverTimeout = 5 * time.Second
+
+// Session is the set of subscriptions a WebSocket client keeps across
+// reconnects. While a connection holds the session its subscriptions live on
+// topics as usual; once the connection drops they are parked here for the
+// grace period.
+type Session struct {
+	Token     string
+	Principal string // Only the same principal may resume the session
+
+	conn          models.WebSocketConn // nil while detached
+	detached      chan struct{}        // Closed when conn lets go of the session
+	subscriptions []*models.Subscriber // Parked while detached
+	expiresAt     time.Time
+}
+
+// SessionStore issues session tokens and keeps detached sessions until they
+// are resumed or their grace period ends
+type SessionStore struct {
+	grace    time.Duration
+	mu       sync.Mutex
+	sessions map[string]*Session
+}
+
+// NewSessionStore creates a session store. SESSION_GRACE_MS sets how long a
+// disconnected client's subscriptions are kept; 0 disables sessions.
+func NewSessionStore() *SessionStore {
+	store := &SessionStore{sessions: make(map[string]*Session)}
+	if env.Int("SESSION_GRACE_MS", 30000) != 0 {
+		store.grace = env.Interval("SESSION_GRACE_MS", 30000)
+	}
+	return store
+}
+
+// Enabled reports whether sessions are issued
+func (s *SessionStore) Enabled() bool {
+	return s.grace > 0
+}
+
+// Open issues a new session held by conn
+func (s *SessionStore) Open(principal string, conn models.WebSocketConn) *Session {
+	session := &Session{
+		Token:     uuid.New().String(),
+		Principal: principal,
+		conn:      conn,
+		detached:  make(chan struct{}),
+	}
+
+	s.mu.Lock()
+	s.sessions[session.Token] = session
+	s.mu.Unlock()
+	return session
+}
+
+// Resume hands a session and its parked subscriptions to conn. A session
+// still held by another connection is taken over: that connection is closed
+// and its subscriptions are parked first. The second result is false if the
+// token is unknown, has expired or belongs to another principal.
+func (s *SessionStore) Resume(token, principal string, conn models.WebSocketConn) (*Session, []*models.Subscriber, bool) {
+	s.mu.Lock()
+	session, exists := s.sessions[token]
+	if !exists || session.Principal != principal {
+		s.mu.Unlock()
+		return nil, nil, false
+	}
+
+	if session.conn != nil {
+		previous, detached := session.conn, session.detached
+		s.mu.Unlock()
+
+		previous.Close()
+		select {
+		case <-detached:
+		case <-time.After(sessionTakeoverTimeout):
+			return nil, nil, false
+		}
+
+		s.mu.Lock()
+		if s.sessions[token] != session || session.conn != nil {
+			s.mu.Unlock()
+			return nil, nil, false // Expired or resumed by someone else meanwhile
+		}
+	}
+	defer s.mu.Unlock()
+
+	subs := session.subscriptions
+	session.subscriptions = nil
+	session.conn = conn
+	session.detached = make(chan struct{})
+	return session, subs, true
+}
+
+// Detach parks the subscriptions removed from a closed connection on its
+// session and starts the grace period
+func (s *SessionStore) Detach(session *Session, subs []*models.Subscriber) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session.conn = nil
+	session.subscriptions = subs
+	session.expiresAt = time.Now().Add(s.grace)
+	close(session.detached)
+
+	time.AfterFunc(s.grace, func() { s.expire(session) })
+}
+
+// expire drops a session whose grace period ended without a resume. A
+// timer left over from an earlier detach finds the session attached again
+// or its deadline moved and leaves it alone.
+func (s *SessionStore) expire(session *Session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if session.conn != nil || time.Now().Before(session.expiresAt) || s.sessions[session.Token] != session {
+		return
+	}
+	delete(s.sessions, session.Token)
+	if len(session.subscriptions) > 0 {
+		log.Printf("Session %s expired with %d subscriptions", session.Token, len(session.subscriptions))
+	}
+}
+
+// Resubscribe creates a subscriber on conn with the options and resume
+// cursors of one parked by a session. Unacknowledged at-least-once messages
+// carry over and are redelivered as soon as the processor starts; consumer
+// group members already handed theirs to the group when they disconnected.
+func (sm *SubscriberManager) Resubscribe(old *models.Subscriber, conn models.WebSocketConn) *models.Subscriber {
+	sub := sm.NewSubscriber(old.ID, old.Topic, conn)
+	sub.Group = old.Group
+	sub.Delivery = old.Delivery
+	sub.AckTimeout = old.AckTimeout
+	sub.Backpressure = old.Backpressure
+	sub.BlockTimeout = old.BlockTimeout
+	sub.Filter = old.Filter
+
+	old.CursorMu.Lock()
+	for topic, offset := range old.Cursors {
+		sub.Cursors[topic] = offset
+	}
+	old.CursorMu.Unlock()
+
+	if old.Group == "" {
+		old.InFlightMu.Lock()
+		for id, entry := range old.InFlight {
+			sub.InFlight[id] = &models.InFlight{Message: entry.Message, Attempt: entry.Attempt, Sent: entry.Sent}
+		}
+		sub.Sends = old.Sends
+		old.InFlightMu.Unlock()
+	}
+	return sub
+}
+
+// RestoreSubscriber registers a subscriber created by Resubscribe. An
+// ungrouped subscriber gets a backlog of everything still retained on each
+// topic from its cursor onwards, snapshotted under the topic lock so replay
+// and live delivery neither overlap nor leave a gap. Consumer group members
+// rejoin without a backlog, since the rest of the group covered for them.
+func (ps *PubSubSystem) RestoreSubscriber(sub *models.Subscriber) error {
+	if IsPattern(sub.Topic) {
+		return ps.restoreWildcard(sub)
+	}
+
+	topic, exists := ps.GetTopic(sub.Topic)
+	if !exists {
+		return &models.Error{Code: "TOPIC_NOT_FOUND", Message: "Topic " + sub.Topic + " no longer exists"}
+	}
+
+	history := HistoryRequest{}
+	if sub.Group == "" {
+		cursor := sub.Cursors[sub.Topic]
+		history.ResumeFrom = &cursor
+	}
+	return ps.topicManager.AddSubscriberWithHistory(topic, sub, history)
+}
+
+// restoreWildcard is RestoreSubscriber for pattern subscriptions. Topics
+// without a cursor appeared while the client was away, so their whole
+// retained history is replayed.
+func (ps *PubSubSystem) restoreWildcard(sub *models.Subscriber) error {
+	ps.Mu.Lock()
+	defer ps.Mu.Unlock()
+
+	if heldElsewhere(ps.Wildcards, sub) {
+		return errHeldElsewhere()
+	}
+	if old, exists := ps.Wildcards[sub.ID]; exists {
+		ps.detachWildcard(old)
+		closeQueue(old)
+	}
+
+	sub.DeadLetters = ps
+	ps.Wildcards[sub.ID] = sub
+	for name, topic := range ps.Topics {
+		if !MatchTopic(sub.Topic, name) {
+			continue
+		}
+
+		// Replay only on topics the subscriber could rejoin; a full topic
+		// would otherwise replay messages to a subscriber that is not
+		// attached. The cursor is read first since attaching initializes it.
+		topic.Mu.Lock()
+		cursor := sub.Cursors[name]
+		if attachWildcardLocked(topic, sub) && sub.Group == "" {
+			sub.Backlog = append(sub.Backlog, messagesSince(topic, cursor)...)
+			skipBelow(sub, topic)
+		}
+		topic.Mu.Unlock()
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package pubsub
+
+import (
+	"testing"
+	"time"
+
+	"pub-sub-system/models"
+)
+
+// closingConn is a connection that runs onClose when it is closed, the way
+// a handler detaches its session once the connection drops
+type closingConn struct {
+	nopConn
+	onClose func()
+}
+
+func (c *closingConn) Close() error {
+	go c.onClose()
+	return nil
+}
+
+func TestSessionStoreGraceSetting(t *testing.T) {
+	tests := []struct {
+		value   string
+		enabled bool
+		grace   time.Duration
+	}{
+		{"", true, 30 * time.Second},
+		{"250", true, 250 * time.Millisecond},
+		{"0", false, 0},
+		{"-5", true, 30 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Setenv("SESSION_GRACE_MS", tt.value)
+		store := NewSessionStore()
+		if store.Enabled() != tt.enabled || store.grace != tt.grace {
+			t.Errorf("SESSION_GRACE_MS=%q: enabled %v grace %v, want %v %v", tt.value, store.Enabled(), store.grace, tt.enabled, tt.grace)
+		}
+	}
+}
+
+func TestSessionResume(t *testing.T) {
+	t.Setenv("SESSION_GRACE_MS", "60000")
+	store := NewSessionStore()
+	session := store.Open("alice", nopConn{})
+	parked := []*models.Subscriber{{ID: "client", Topic: "orders"}}
+	store.Detach(session, parked)
+
+	if _, _, ok := store.Resume("unknown", "alice", nopConn{}); ok {
+		t.Error("Resume() accepted an unknown token")
+	}
+	if _, _, ok := store.Resume(session.Token, "mallory", nopConn{}); ok {
+		t.Error("Resume() accepted another principal")
+	}
+
+	resumed, subs, ok := store.Resume(session.Token, "alice", taggedConn{tag: 1})
+	if !ok || resumed != session || len(subs) != 1 || subs[0] != parked[0] {
+		t.Fatalf("Resume() = %v, %v, %v, want the session with its parked subscriber", resumed, subs, ok)
+	}
+	if session.conn != (taggedConn{tag: 1}) || session.subscriptions != nil {
+		t.Errorf("resumed session holds %v with %d parked subscriptions", session.conn, len(session.subscriptions))
+	}
+
+	// A timer from the first detach leaves the session alone once it was
+	// detached again with a later deadline
+	store.Detach(session, nil)
+	store.expire(session)
+	if _, _, ok := store.Resume(session.Token, "alice", nopConn{}); !ok {
+		t.Error("stale expiry dropped a session within its grace period")
+	}
+}
+
+func TestSessionExpires(t *testing.T) {
+	t.Setenv("SESSION_GRACE_MS", "20")
+	store := NewSessionStore()
+	session := store.Open("alice", nopConn{})
+	store.Detach(session, []*models.Subscriber{{ID: "client", Topic: "orders"}})
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		store.mu.Lock()
+		_, exists := store.sessions[session.Token]
+		store.mu.Unlock()
+		if !exists {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("session was not dropped after its grace period")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, _, ok := store.Resume(session.Token, "alice", nopConn{}); ok {
+		t.Error("Resume() accepted an expired session")
+	}
+}
+
+func TestSessionResumeTakesOverHeldSession(t *testing.T) {
+	t.Setenv("SESSION_GRACE_MS", "60000")
+	store := NewSessionStore()
+	parked := []*models.Subscriber{{ID: "client", Topic: "orders"}}
+
+	var session *Session
+	closed := make(chan struct{})
+	previous := &closingConn{onClose: func() {
+		close(closed)
+		store.Detach(session, parked)
+	}}
+	session = store.Open("alice", previous)
+
+	resumed, subs, ok := store.Resume(session.Token, "alice", nopConn{})
+	select {
+	case <-closed:
+	default:
+		t.Fatal("Resume() did not close the connection holding the session")
+	}
+	if !ok || resumed != session || len(subs) != 1 || subs[0] != parked[0] {
+		t.Errorf("Resume() = %v, %v, %v, want the subscriptions parked by the previous connection", resumed, subs, ok)
+	}
+}
+
+func TestRestoreSubscriberReplaysFromCursor(t *testing.T) {
+	ps := NewPubSubSystem()
+	defer ps.Close()
+
+	for _, name := range []string{"orders", "orders.eu"} {
+		if _, err := ps.NewTopic(name); err != nil {
+			t.Fatalf("NewTopic(%s) error = %v", name, err)
+		}
+		for i := 0; i < 4; i++ {
+			publishTest(t, ps, name, i)
+		}
+	}
+
+	sm := NewSubscriberManager()
+	old := sm.NewSubscriber("client", "orders", nopConn{})
+	old.Cursors["orders"] = 2
+	sub := sm.Resubscribe(old, nopConn{})
+	if err := ps.RestoreSubscriber(sub); err != nil {
+		t.Fatalf("RestoreSubscriber() error = %v", err)
+	}
+	if len(sub.Backlog) != 2 || sub.Backlog[0].Offset != 2 || sub.SkipBelow["orders"] != 4 {
+		t.Errorf("backlog %v SkipBelow %d, want offsets 2 and 3 with live messages from 4", backlogOffsets(sub), sub.SkipBelow["orders"])
+	}
+
+	// A wildcard subscription replays each topic from its own cursor, and
+	// the whole history of topics it has no cursor for
+	old = sm.NewSubscriber(SubscriberKey("client", "orders.*"), "orders.*", nopConn{})
+	if _, err := ps.NewTopic("orders.us"); err != nil {
+		t.Fatalf("NewTopic(orders.us) error = %v", err)
+	}
+	publishTest(t, ps, "orders.us", 0)
+	old.Cursors["orders.eu"] = 3
+	sub = sm.Resubscribe(old, nopConn{})
+	if err := ps.RestoreSubscriber(sub); err != nil {
+		t.Fatalf("RestoreSubscriber() wildcard error = %v", err)
+	}
+	counts := make(map[string]int)
+	for _, msg := range sub.Backlog {
+		counts[msg.Topic]++
+	}
+	if len(counts) != 2 || counts["orders.eu"] != 1 || counts["orders.us"] != 1 {
+		t.Errorf("wildcard backlog per topic = %v, want 1 from orders.eu and 1 from orders.us", counts)
+	}
+
+	ps.DeleteTopic("orders")
+	sub = sm.Resubscribe(sm.NewSubscriber("client", "orders", nopConn{}), nopConn{})
+	if err := ps.RestoreSubscriber(sub); err == nil {
+		t.Error("RestoreSubscriber() succeeded for a deleted topic")
+	}
+}
+
+func TestResubscribeCarriesInFlightMessages(t *testing.T) {
+	sm := NewSubscriberManager()
+	old := sm.NewSubscriber("client", "orders", nopConn{})
+	old.Delivery = DeliveryAtLeastOnce
+	old.AckTimeout = time.Minute
+	for _, id := range []string{"m1", "m2"} {
+		if err := sm.send(old, &models.Message{ID: id, Topic: "orders"}); err != nil {
+			t.Fatalf("send(%s) error = %v", id, err)
+		}
+	}
+
+	sub := sm.Resubscribe(old, nopConn{})
+	if got := inFlightIDs(sub); len(got) != 2 || sub.Delivery != DeliveryAtLeastOnce || sub.AckTimeout != time.Minute {
+		t.Errorf("resubscribed in flight %v delivery %q timeout %v", got, sub.Delivery, sub.AckTimeout)
+	}
+	// Cumulative acks keep their order across the resume
+	if !sm.AckThrough(sub, "m2") || len(inFlightIDs(sub)) != 0 {
+		t.Errorf("in flight after AckThrough(m2) = %v, want none", inFlightIDs(sub))
+	}
+
+	// Group members hand their messages to the group instead
+	old.Group = "workers"
+	if sub := sm.Resubscribe(old, nopConn{}); len(inFlightIDs(sub)) != 0 || sub.Group != "workers" {
+		t.Errorf("group member resubscribed with in flight %v group %q", inFlightIDs(sub), sub.Group)
+	}
+}
+
+// backlogOffsets lists the offsets of a subscriber's backlog
+func backlogOffsets(sub *models.Subscriber) []int64 {
+	offsets := make([]int64, len(sub.Backlog))
+	for i, msg := range sub.Backlog {
+		offsets[i] = msg.Offset
+	}
+	return offsets
+}
@@ -0,0 +1,190 @@
+package pubsub
+
+import (
+	"fmt"
+	"time"
+
+	"pub-sub-system/env"
+	"pub-sub-system/models"
+)
+
+// TopicUpdate is a partial change to a topic's settings. Nil fields are left
+// unchanged; Labels replaces the whole label set when non-nil and Retention
+// replaces the whole retention policy.
+type TopicUpdate struct {
+	MaxSubscribers *int                    `json:"max_subscribers,omitempty"`
+	QueueSize      *int                    `json:"queue_size,omitempty"`
+	HistorySize    *int                    `json:"history_size,omitempty"`
+	Backpressure   *string                 `json:"backpressure,omitempty"`
+	BlockTimeoutMs *int64                  `json:"block_timeout_ms,omitempty"`
+	DeadLetter     *string                 `json:"dead_letter_topic,omitempty"`
+	Schema         *string                 `json:"schema,omitempty"`
+	SchemaVersion  *int                    `json:"schema_version,omitempty"`
+	Description    *string                 `json:"description,omitempty"`
+	Labels         map[string]string       `json:"labels,omitempty"`
+	Retention      *models.RetentionPolicy `json:"retention,omitempty"`
+}
+
+// DefaultSettings returns the settings applied to topics created without
+// them, configured by MAX_SUBSCRIBERS_PER_TOPIC, SUBSCRIBER_QUEUE_SIZE,
+// TOPIC_HISTORY_SIZE and BACKPRESSURE_BLOCK_TIMEOUT_MS
+func (ps *PubSubSystem) DefaultSettings() models.TopicSettings {
+	return models.TopicSettings{
+		MaxSubscribers: ps.MaxSubscribers,
+		QueueSize:      env.Int("SUBSCRIBER_QUEUE_SIZE", 100),
+		HistorySize:    DefaultRetention().MaxMessages,
+		Backpressure:   BackpressureDisconnect,
+		BlockTimeoutMs: int64(env.Int("BACKPRESSURE_BLOCK_TIMEOUT_MS", 1000)),
+	}
+}
+
+// ValidateSettings checks that topic settings are usable
+func ValidateSettings(settings models.TopicSettings) error {
+	if settings.MaxSubscribers <= 0 {
+		return fmt.Errorf("max_subscribers must be positive")
+	}
+	if settings.QueueSize <= 0 {
+		return fmt.Errorf("queue_size must be positive")
+	}
+	if settings.HistorySize < 0 {
+		return fmt.Errorf("history_size must not be negative")
+	}
+	if !ValidBackpressure(settings.Backpressure) {
+		return fmt.Errorf("unknown backpressure policy %q", settings.Backpressure)
+	}
+	if settings.BlockTimeoutMs < 0 {
+		return fmt.Errorf("block_timeout_ms must not be negative")
+	}
+	if settings.DeadLetterTopic != "" {
+		if err := ValidateTopicName(settings.DeadLetterTopic); err != nil {
+			return fmt.Errorf("dead_letter_topic: %v", err)
+		}
+	}
+	if settings.SchemaVersion < 0 {
+		return fmt.Errorf("schema_version must not be negative")
+	}
+	if settings.SchemaVersion > 0 && settings.Schema == "" {
+		return fmt.Errorf("schema_version requires schema")
+	}
+	return nil
+}
+
+// ApplyTopicUpdate applies an update to copies of a topic's settings and
+// retention policy, keeping HistorySize and Retention.MaxMessages in step
+func ApplyTopicUpdate(settings models.TopicSettings, retention models.RetentionPolicy, update TopicUpdate) (models.TopicSettings, models.RetentionPolicy) {
+	if update.Retention != nil {
+		retention = *update.Retention
+	}
+	if update.MaxSubscribers != nil {
+		settings.MaxSubscribers = *update.MaxSubscribers
+	}
+	if update.QueueSize != nil {
+		settings.QueueSize = *update.QueueSize
+	}
+	if update.HistorySize != nil {
+		retention.MaxMessages = *update.HistorySize
+	}
+	if update.Backpressure != nil {
+		settings.Backpressure = *update.Backpressure
+	}
+	if update.BlockTimeoutMs != nil {
+		settings.BlockTimeoutMs = *update.BlockTimeoutMs
+	}
+	if update.DeadLetter != nil {
+		settings.DeadLetterTopic = *update.DeadLetter
+	}
+	if update.Schema != nil {
+		settings.Schema = *update.Schema
+	}
+	if update.SchemaVersion != nil {
+		settings.SchemaVersion = *update.SchemaVersion
+	}
+	if update.Description != nil {
+		settings.Description = *update.Description
+	}
+	if update.Labels != nil {
+		settings.Labels = make(map[string]string, len(update.Labels))
+		for k, v := range update.Labels {
+			settings.Labels[k] = v
+		}
+	}
+	settings.HistorySize = retention.MaxMessages
+	return settings, retention
+}
+
+// UpdateTopic changes the settings of a live topic. Queue size changes apply
+// to subscriptions made afterwards; a smaller history takes effect at once.
+func (ps *PubSubSystem) UpdateTopic(name string, update TopicUpdate) (*models.Topic, error) {
+	if update.Schema != nil || update.SchemaVersion != nil {
+		topic, exists := ps.GetTopic(name)
+		if !exists {
+			return nil, fmt.Errorf("topic not found")
+		}
+		topic.Mu.RLock()
+		settings, _ := ApplyTopicUpdate(topic.Settings, topic.Retention, update)
+		topic.Mu.RUnlock()
+		if err := ps.checkSchemaBinding(settings); err != nil {
+			return nil, err
+		}
+	}
+
+	topic, err := ps.updateTopic(name, update)
+	if err != nil {
+		return nil, err
+	}
+
+	if replicator := ps.Replicator(); replicator != nil {
+		topic.Mu.RLock()
+		settings, retention := topic.Settings, topic.Retention
+		topic.Mu.RUnlock()
+		replicator.TopicUpdated(name, settings, retention)
+	}
+	return topic, nil
+}
+
+// updateTopic changes a topic's settings on this node only
+func (ps *PubSubSystem) updateTopic(name string, update TopicUpdate) (*models.Topic, error) {
+	topic, exists := ps.GetTopic(name)
+	if !exists {
+		return nil, fmt.Errorf("topic not found")
+	}
+
+	topic.Mu.Lock()
+	defer topic.Mu.Unlock()
+
+	settings, retention := ApplyTopicUpdate(topic.Settings, topic.Retention, update)
+	if err := ValidateSettings(settings); err != nil {
+		return nil, fmt.Errorf("invalid settings: %v", err)
+	}
+	if err := ValidateRetention(retention); err != nil {
+		return nil, fmt.Errorf("invalid retention: %v", err)
+	}
+
+	if topic.Log != nil {
+		if err := topic.Log.WriteMeta(topicMeta{Retention: retention, Settings: &settings}); err != nil {
+			return nil, fmt.Errorf("failed to write topic metadata: %w", err)
+		}
+	}
+
+	topic.Settings = settings
+	topic.Retention = retention
+	if enforceRetention(topic, time.Now()) > 0 {
+		truncateLog(topic)
+	}
+	return topic, nil
+}
+
+// TopicInfo describes a topic's settings and current state for the REST API
+func (ps *PubSubSystem) TopicInfo(topic *models.Topic) map[string]interface{} {
+	topic.Mu.RLock()
+	defer topic.Mu.RUnlock()
+
+	return map[string]interface{}{
+		"name":        topic.Name,
+		"settings":    topic.Settings,
+		"retention":   topic.Retention,
+		"subscribers": len(topic.Subscribers),
+		"messages":    len(topic.Messages),
+		"next_offset": topic.NextOffset,
+	}
+}
@@ -3,56 +3,162 @@ package pubsub
 import (
 	"context"
 	"log"
+	"sort"
+	"sync/atomic"
 	"time"
 
+	"pub-sub-system/env"
+	"pub-sub-system/metrics"
 	"pub-sub-system/models"
 )
 
+// Delivery modes a subscriber can choose when subscribing
+const (
+	DeliveryAtMostOnce  = "at_most_once"
+	DeliveryAtLeastOnce = "at_least_once"
+)
+
+// redeliveryCheckInterval is how often unacknowledged messages are checked
+const redeliveryCheckInterval = 250 * time.Millisecond
+
 // SubscriberManager provides methods to work with Subscriber models
-type SubscriberManager struct{}
+type SubscriberManager struct {
+	ackTimeout  time.Duration
+	maxAttempts int
+	maxInFlight int
+	queueSize   int
+
+	dropReportInterval time.Duration
+}
 
 // NewSubscriberManager creates a new subscriber manager
 func NewSubscriberManager() *SubscriberManager {
-	return &SubscriberManager{}
+	return &SubscriberManager{
+		ackTimeout:  env.Interval("ACK_TIMEOUT_MS", 30000),
+		maxAttempts: env.Int("MAX_DELIVERY_ATTEMPTS", 5),
+		maxInFlight: env.Int("MAX_IN_FLIGHT", 100),
+		queueSize:   env.Int("SUBSCRIBER_QUEUE_SIZE", 100),
+
+		dropReportInterval: env.Interval("DROP_REPORT_INTERVAL_MS", 5000),
+	}
 }
 
 // NewSubscriber creates a new subscriber
 func (sm *SubscriberManager) NewSubscriber(id, topic string, conn models.WebSocketConn) *models.Subscriber {
-	queueSize := 100 // Fixed: Use constant until env var issue resolved
+	queueSize := sm.queueSize
 	return &models.Subscriber{
 		ID:       id,
 		Conn:     conn,
 		Topic:    topic,
 		Queue:    make(chan *models.Message, queueSize), // Bounded queue
 		MaxQueue: queueSize,
+		Closing:  make(chan struct{}),
+		Delivery: DeliveryAtMostOnce,
+		InFlight: make(map[string]*models.InFlight),
+		Cursors:  make(map[string]int64),
 	}
 }
 
+// applyQueueSize resizes a subscriber's queue to a topic's configured size.
+// It must only be called before the subscriber is registered anywhere.
+func applyQueueSize(sub *models.Subscriber, size int) {
+	if size <= 0 || size == sub.MaxQueue {
+		return
+	}
+	sub.Queue = make(chan *models.Message, size)
+	sub.MaxQueue = size
+}
+
+// closeQueue closes a subscriber's queue so its processor stops. Senders
+// blocked on the full queue are woken first, and nothing is sent to the
+// queue afterwards.
+func closeQueue(sub *models.Subscriber) {
+	sub.SignalClosing()
+
+	sub.QueueMu.Lock()
+	defer sub.QueueMu.Unlock()
+
+	if !sub.QueueClosed {
+		sub.QueueClosed = true
+		close(sub.Queue)
+	}
+}
+
+// ValidDelivery reports whether mode is a supported delivery mode
+func ValidDelivery(mode string) bool {
+	return mode == DeliveryAtMostOnce || mode == DeliveryAtLeastOnce
+}
+
 // StartMessageProcessor starts processing messages for a subscriber
 func (sm *SubscriberManager) StartMessageProcessor(sub *models.Subscriber, ctx context.Context) {
 	go func() {
-		defer func() {
-			sub.Conn.Close()
-			// Remove from topic
-			// This will be handled by the topic manager
-		}()
+		atLeastOnce := sub.Delivery == DeliveryAtLeastOnce
+		if atLeastOnce && sub.AckTimeout <= 0 {
+			sub.AckTimeout = sm.ackTimeout
+		}
+
+		var redeliver <-chan time.Time
+		if atLeastOnce {
+			ticker := time.NewTicker(redeliveryCheckInterval)
+			defer ticker.Stop()
+			redeliver = ticker.C
+		}
+
+		dropReport := time.NewTicker(sm.dropReportInterval)
+		defer dropReport.Stop()
+		var reported int64
+
+		// Replay history before any live message
+		backlog := sub.Backlog
+		sub.Backlog = nil
+		for _, msg := range backlog {
+			if !wants(sub, msg) || sm.discardExpired(sub, msg) {
+				continue
+			}
+			if err := sm.send(sub, msg); err != nil {
+				log.Printf("Error sending message to subscriber %s: %v", sub.ID, err)
+				sub.Conn.Close()
+				return
+			}
+		}
 
 		for {
+			// Stop reading new messages while too many are unacknowledged
+			queue := sub.Queue
+			if atLeastOnce && sm.inFlightCount(sub) >= sm.maxInFlight {
+				queue = nil
+			}
+
 			select {
-			case msg := <-sub.Queue:
+			case msg := <-queue:
 				if msg == nil {
 					return // Channel closed
 				}
-
-				serverMsg := &models.ServerMessage{
-					Type:    "event",
-					Topic:   sub.Topic,
-					Message: msg,
-					TS:      time.Now().UTC().Format(time.RFC3339),
+				if msg.Offset < sub.SkipBelow[msg.Topic] {
+					continue // Already sent as part of the backlog
+				}
+				if sm.discardExpired(sub, msg) {
+					continue
 				}
 
-				if err := sub.Conn.WriteJSON(serverMsg); err != nil {
+				if err := sm.send(sub, msg); err != nil {
 					log.Printf("Error sending message to subscriber %s: %v", sub.ID, err)
+					sub.Conn.Close()
+					return
+				}
+				metrics.PublishToWrite.WithLabelValues(metrics.TopicLabel(msg.Topic)).Observe(time.Since(msg.PublishedAt).Seconds())
+
+			case <-redeliver:
+				if err := sm.redeliverExpired(sub); err != nil {
+					log.Printf("Error redelivering message to subscriber %s: %v", sub.ID, err)
+					sub.Conn.Close()
+					return
+				}
+
+			case <-dropReport.C:
+				if err := sm.reportDrops(sub, &reported); err != nil {
+					log.Printf("Error sending drop report to subscriber %s: %v", sub.ID, err)
+					sub.Conn.Close()
 					return
 				}
 
@@ -62,3 +168,216 @@ func (sm *SubscriberManager) StartMessageProcessor(sub *models.Subscriber, ctx c
 		}
 	}()
 }
+
+// reportDrops sends an info frame with the subscriber's total drop count if
+// messages were dropped since the last report
+func (sm *SubscriberManager) reportDrops(sub *models.Subscriber, reported *int64) error {
+	dropped := atomic.LoadInt64(&sub.Dropped)
+	if dropped == *reported {
+		return nil
+	}
+	*reported = dropped
+
+	return sub.Conn.WriteJSON(&models.ServerMessage{
+		Type:    "info",
+		Topic:   sub.Topic,
+		Msg:     "Messages dropped due to backpressure",
+		Dropped: dropped,
+		TS:      time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// discardExpired reports whether a message expired before it could be
+// written to the subscriber, dead-lettering it if so
+func (sm *SubscriberManager) discardExpired(sub *models.Subscriber, msg *models.Message) bool {
+	if !msg.Expired(time.Now()) {
+		return false
+	}
+	metrics.Expired.WithLabelValues(metrics.TopicLabel(msg.Topic)).Inc()
+	deadLetter(sub, msg, ReasonExpired)
+	return true
+}
+
+// send writes an event to the subscriber and, in at-least-once mode, records
+// it as in flight until the client acknowledges it
+func (sm *SubscriberManager) send(sub *models.Subscriber, msg *models.Message) error {
+	// Wildcard subscribers see the concrete topic name
+	topicName := sub.Topic
+	if msg.Topic != "" {
+		topicName = msg.Topic
+	}
+
+	serverMsg := &models.ServerMessage{
+		Type:         "event",
+		Topic:        topicName,
+		Message:      msg,
+		TS:           time.Now().UTC().Format(time.RFC3339),
+		SubscriberID: sub.ID,
+	}
+
+	if sub.Delivery == DeliveryAtLeastOnce {
+		sub.InFlightMu.Lock()
+		entry, exists := sub.InFlight[msg.ID]
+		if !exists {
+			entry = &models.InFlight{Message: msg}
+			sub.InFlight[msg.ID] = entry
+		}
+		entry.Attempt++
+		entry.Deadline = time.Now().Add(sub.AckTimeout)
+		sub.Sends++
+		entry.Sent = sub.Sends
+		serverMsg.Attempt = entry.Attempt
+		sub.InFlightMu.Unlock()
+	}
+
+	if err := sub.Conn.WriteJSON(serverMsg); err != nil {
+		return err
+	}
+	advanceCursor(sub, msg)
+	metrics.Deliveries.WithLabelValues(metrics.TopicLabel(topicName)).Inc()
+	return nil
+}
+
+// initCursor sets where a subscriber's resume cursor on a topic starts,
+// keeping a cursor carried over from a resumed session
+func initCursor(sub *models.Subscriber, topic string, offset int64) {
+	sub.CursorMu.Lock()
+	defer sub.CursorMu.Unlock()
+
+	if _, exists := sub.Cursors[topic]; !exists {
+		sub.Cursors[topic] = offset
+	}
+}
+
+// advanceCursor records that a message has been written to the subscriber.
+// Redeliveries of older messages never move the cursor back.
+func advanceCursor(sub *models.Subscriber, msg *models.Message) {
+	sub.CursorMu.Lock()
+	defer sub.CursorMu.Unlock()
+
+	if next := msg.Offset + 1; next > sub.Cursors[msg.Topic] {
+		sub.Cursors[msg.Topic] = next
+	}
+}
+
+// redeliverExpired resends in-flight messages whose ack deadline has passed,
+// dropping those that have used up their delivery attempts or whose TTL has
+// elapsed
+func (sm *SubscriberManager) redeliverExpired(sub *models.Subscriber) error {
+	now := time.Now()
+
+	sub.InFlightMu.Lock()
+	due := make([]*models.InFlight, 0)
+	exhausted := make([]*models.Message, 0)
+	expired := make([]*models.Message, 0)
+	for id, entry := range sub.InFlight {
+		if now.Before(entry.Deadline) {
+			continue
+		}
+		if entry.Message.Expired(now) {
+			delete(sub.InFlight, id)
+			expired = append(expired, entry.Message)
+			continue
+		}
+		if entry.Attempt >= sm.maxAttempts {
+			log.Printf("Dropping message %s for subscriber %s after %d delivery attempts", id, sub.ID, entry.Attempt)
+			delete(sub.InFlight, id)
+			exhausted = append(exhausted, entry.Message)
+			continue
+		}
+		due = append(due, entry)
+	}
+	sub.InFlightMu.Unlock()
+
+	for _, msg := range exhausted {
+		deadLetter(sub, msg, ReasonMaxAttempts)
+	}
+	for _, msg := range expired {
+		sm.discardExpired(sub, msg)
+	}
+
+	// Redeliver oldest first
+	sort.Slice(due, func(i, j int) bool { return due[i].Deadline.Before(due[j].Deadline) })
+	for _, entry := range due {
+		if err := sm.send(sub, entry.Message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// inFlightCount returns the number of unacknowledged messages
+func (sm *SubscriberManager) inFlightCount(sub *models.Subscriber) int {
+	sub.InFlightMu.Lock()
+	defer sub.InFlightMu.Unlock()
+	return len(sub.InFlight)
+}
+
+// Ack marks an in-flight message as processed by the client
+func (sm *SubscriberManager) Ack(sub *models.Subscriber, messageID string) bool {
+	sub.InFlightMu.Lock()
+	defer sub.InFlightMu.Unlock()
+
+	if _, exists := sub.InFlight[messageID]; !exists {
+		return false
+	}
+	delete(sub.InFlight, messageID)
+	return true
+}
+
+// Nack asks for an in-flight message to be redelivered without waiting for
+// its ack timeout
+func (sm *SubscriberManager) Nack(sub *models.Subscriber, messageID string) bool {
+	sub.InFlightMu.Lock()
+	defer sub.InFlightMu.Unlock()
+
+	entry, exists := sub.InFlight[messageID]
+	if !exists {
+		return false
+	}
+	entry.Deadline = time.Time{}
+	return true
+}
+
+// AckThrough acknowledges an in-flight message together with every in-flight
+// message last sent before it, for clients that acknowledge cumulatively
+func (sm *SubscriberManager) AckThrough(sub *models.Subscriber, messageID string) bool {
+	sub.InFlightMu.Lock()
+	defer sub.InFlightMu.Unlock()
+
+	ids, exists := sentThrough(sub, messageID)
+	for _, id := range ids {
+		delete(sub.InFlight, id)
+	}
+	return exists
+}
+
+// NackThrough is Nack for a message and every in-flight message last sent
+// before it
+func (sm *SubscriberManager) NackThrough(sub *models.Subscriber, messageID string) bool {
+	sub.InFlightMu.Lock()
+	defer sub.InFlightMu.Unlock()
+
+	ids, exists := sentThrough(sub, messageID)
+	for _, id := range ids {
+		sub.InFlight[id].Deadline = time.Time{}
+	}
+	return exists
+}
+
+// sentThrough returns the IDs of the in-flight messages last sent no later
+// than the given one, and false if that one is not in flight. Callers must
+// hold sub.InFlightMu.
+func sentThrough(sub *models.Subscriber, messageID string) ([]string, bool) {
+	last, exists := sub.InFlight[messageID]
+	if !exists {
+		return nil, false
+	}
+	ids := make([]string, 0, len(sub.InFlight))
+	for id, entry := range sub.InFlight {
+		if entry.Sent <= last.Sent {
+			ids = append(ids, id)
+		}
+	}
+	return ids, true
+}
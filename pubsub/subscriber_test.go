@@ -0,0 +1,68 @@
+package pubsub
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"pub-sub-system/models"
+)
+
+// inFlightIDs returns the sorted IDs of a subscriber's unacknowledged messages
+func inFlightIDs(sub *models.Subscriber) []string {
+	sub.InFlightMu.Lock()
+	defer sub.InFlightMu.Unlock()
+
+	ids := make([]string, 0, len(sub.InFlight))
+	for id := range sub.InFlight {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestAckThroughCoversMessagesSentBefore(t *testing.T) {
+	sm := NewSubscriberManager()
+	sub := sm.NewSubscriber("client", "orders", nopConn{})
+	sub.Delivery = DeliveryAtLeastOnce
+	sub.AckTimeout = time.Minute
+
+	msgs := make(map[string]*models.Message)
+	for _, id := range []string{"m1", "m2", "m3", "m4"} {
+		msgs[id] = &models.Message{ID: id, Topic: "orders"}
+	}
+	// m1 is redelivered after m3, so it was last sent after m3
+	for _, id := range []string{"m1", "m2", "m3", "m1", "m4"} {
+		if err := sm.send(sub, msgs[id]); err != nil {
+			t.Fatalf("send(%s) error = %v", id, err)
+		}
+	}
+
+	if !sm.AckThrough(sub, "m3") {
+		t.Fatal("AckThrough(m3) = false")
+	}
+	if got := inFlightIDs(sub); len(got) != 2 || got[0] != "m1" || got[1] != "m4" {
+		t.Errorf("in flight after AckThrough(m3) = %v, want [m1 m4]", got)
+	}
+	if sm.AckThrough(sub, "m2") {
+		t.Error("AckThrough(m2) = true for an acknowledged message")
+	}
+
+	if !sm.NackThrough(sub, "m1") {
+		t.Fatal("NackThrough(m1) = false")
+	}
+	sub.InFlightMu.Lock()
+	m1Due, m4Due := sub.InFlight["m1"].Deadline.IsZero(), sub.InFlight["m4"].Deadline.IsZero()
+	sub.InFlightMu.Unlock()
+	if !m1Due || m4Due {
+		t.Errorf("after NackThrough(m1) m1 due = %v, m4 due = %v, want only m1", m1Due, m4Due)
+	}
+
+	// Individual acks leave other messages alone
+	if !sm.Ack(sub, "m4") {
+		t.Fatal("Ack(m4) = false")
+	}
+	if got := inFlightIDs(sub); len(got) != 1 || got[0] != "m1" {
+		t.Errorf("in flight after Ack(m4) = %v, want [m1]", got)
+	}
+}
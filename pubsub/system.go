@@ -2,12 +2,18 @@ package pubsub
 
 import (
 	"fmt"
+	"log"
 	"os"
-	"strconv"
+	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"pub-sub-system/env"
+	"pub-sub-system/metrics"
 	"pub-sub-system/models"
+	"pub-sub-system/schema"
+	"pub-sub-system/storage"
 )
 
 // PubSubSystem manages the entire pub/sub system
@@ -17,33 +23,186 @@ type PubSubSystem struct {
 	Mu             sync.RWMutex
 	MaxTopics      int
 	MaxSubscribers int
+	Store          *storage.Store // nil when persistence is disabled
+	Wildcards      map[string]*models.Subscriber
+	replicator     atomic.Pointer[Replicator] // nil outside cluster mode
+	Schemas        *schema.Registry
+	topicManager   *TopicManager
+	scheduler      *scheduler
+	connections    connectionRegistry
+	stopSweeper    chan struct{}
 }
 
-// NewPubSubSystem creates a new pub/sub system
+// NewPubSubSystem creates a new pub/sub system. When WAL_DIR is set, topic
+// history is persisted to disk and recovered from it on startup.
 func NewPubSubSystem() *PubSubSystem {
-	maxTopics := getEnvInt("MAX_TOPICS", 100)
-	maxSubscribers := getEnvInt("MAX_SUBSCRIBERS_PER_TOPIC", 100)
+	maxTopics := env.Int("MAX_TOPICS", 100)
+	maxSubscribers := env.Int("MAX_SUBSCRIBERS_PER_TOPIC", 100)
 
-	return &PubSubSystem{
+	ps := &PubSubSystem{
 		Topics:         make(map[string]*models.Topic),
 		StartTime:      time.Now(),
 		MaxTopics:      maxTopics,
 		MaxSubscribers: maxSubscribers,
+		Wildcards:      make(map[string]*models.Subscriber),
+		topicManager:   NewTopicManager(),
+		connections:    connectionRegistry{conns: make(map[string]ConnectionInfo)},
+		stopSweeper:    make(chan struct{}),
 	}
+
+	compatibility := os.Getenv("SCHEMA_COMPATIBILITY")
+	if compatibility == "" {
+		compatibility = schema.CompatibilityBackward
+	}
+	registryPath := ""
+	if dir := os.Getenv("WAL_DIR"); dir != "" {
+		registryPath = filepath.Join(dir, "schemas.json")
+	}
+	schemas, err := schema.Open(registryPath, compatibility)
+	if err != nil {
+		log.Fatalf("Failed to open schema registry: %v", err)
+	}
+	ps.Schemas = schemas
+
+	if dir := os.Getenv("WAL_DIR"); dir != "" {
+		store, err := storage.Open(storage.Options{
+			Dir:           dir,
+			Fsync:         storage.FsyncPolicy(os.Getenv("WAL_FSYNC")),
+			FsyncInterval: env.Interval("WAL_FSYNC_INTERVAL_MS", 1000),
+			SegmentBytes:  int64(env.Int("WAL_SEGMENT_BYTES", 8<<20)),
+		})
+		if err != nil {
+			log.Fatalf("Failed to open write-ahead log in %s: %v", dir, err)
+		}
+		ps.Store = store
+
+		if err := ps.recover(); err != nil {
+			log.Fatalf("Failed to recover topics from %s: %v", dir, err)
+		}
+	}
+
+	ps.scheduler = newScheduler(env.Int("MAX_SCHEDULED_MESSAGES", 10000), ps.publishScheduled)
+	go ps.scheduler.run()
+
+	go ps.runRetentionSweeper(env.Interval("RETENTION_SWEEP_INTERVAL_MS", 5000))
+
+	return ps
 }
 
-// getEnvInt gets an environment variable as an integer with a default value
-func getEnvInt(key string, defaultValue int) int {
-	if val := os.Getenv(key); val != "" {
-		if intVal, err := strconv.Atoi(val); err == nil {
-			return intVal
+// recover rebuilds topics and their message history from the write-ahead log
+func (ps *PubSubSystem) recover() error {
+	names, err := ps.Store.Topics()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		topicLog, err := ps.Store.OpenTopic(name)
+		if err != nil {
+			return fmt.Errorf("topic %s: %w", name, err)
+		}
+
+		topic := &models.Topic{
+			Name:        name,
+			Subscribers: make(map[string]*models.Subscriber),
+			Messages:    make([]*models.Message, 0),
+			Retention:   DefaultRetention(),
+			Settings:    ps.DefaultSettings(),
+			Log:         topicLog,
+			DeadLetters: ps,
+		}
+
+		var meta topicMeta
+		if found, err := topicLog.ReadMeta(&meta); err != nil {
+			log.Printf("Ignoring unreadable metadata for topic %s: %v", name, err)
+		} else if found {
+			topic.Retention = meta.Retention
+			if meta.Settings != nil {
+				topic.Settings = *meta.Settings
+			}
 		}
+		topic.Settings.HistorySize = topic.Retention.MaxMessages
+
+		recoveredAt := time.Now().UTC()
+		if err := topicLog.Replay(func(msg *models.Message) {
+			msg.Topic = name
+			msg.Size = payloadSize(msg)
+			// Records written before offsets and timestamps existed
+			if msg.Offset < topic.NextOffset {
+				msg.Offset = topic.NextOffset
+			}
+			if msg.PublishedAt.IsZero() {
+				msg.PublishedAt = recoveredAt
+			}
+			topic.NextOffset = msg.Offset + 1
+			topic.Messages = append(topic.Messages, msg)
+			topic.HistoryBytes += int64(msg.Size)
+			enforceRetention(topic, recoveredAt)
+		}); err != nil {
+			return fmt.Errorf("topic %s: %w", name, err)
+		}
+		truncateLog(topic)
+
+		ps.Topics[name] = topic
+		metrics.AddTopic(name)
+		log.Printf("Recovered topic %s with %d messages", name, len(topic.Messages))
 	}
-	return defaultValue
+	return nil
 }
 
-// NewTopic creates a new topic
+// Close stops background work and flushes the write-ahead log, if any
+func (ps *PubSubSystem) Close() error {
+	close(ps.stopSweeper)
+	ps.scheduler.close()
+
+	if ps.Store == nil {
+		return nil
+	}
+	return ps.Store.Close()
+}
+
+// NewTopic creates a new topic with the default settings and retention policy
 func (ps *PubSubSystem) NewTopic(name string) (*models.Topic, error) {
+	return ps.NewTopicWithRetention(name, DefaultRetention())
+}
+
+// NewTopicWithRetention creates a new topic with the given retention policy
+func (ps *PubSubSystem) NewTopicWithRetention(name string, retention models.RetentionPolicy) (*models.Topic, error) {
+	settings := ps.DefaultSettings()
+	settings.HistorySize = retention.MaxMessages
+	return ps.NewTopicWithSettings(name, settings, retention)
+}
+
+// NewTopicWithSettings creates a new topic with the given settings and
+// retention policy. settings.HistorySize must match retention.MaxMessages.
+func (ps *PubSubSystem) NewTopicWithSettings(name string, settings models.TopicSettings, retention models.RetentionPolicy) (*models.Topic, error) {
+	if err := ps.checkSchemaBinding(settings); err != nil {
+		return nil, err
+	}
+
+	topic, err := ps.createTopic(name, settings, retention)
+	if err != nil {
+		return nil, err
+	}
+
+	if replicator := ps.Replicator(); replicator != nil {
+		replicator.TopicCreated(name, settings, retention)
+	}
+	return topic, nil
+}
+
+// createTopic creates a topic on this node only
+func (ps *PubSubSystem) createTopic(name string, settings models.TopicSettings, retention models.RetentionPolicy) (*models.Topic, error) {
+	if err := ValidateSettings(settings); err != nil {
+		return nil, fmt.Errorf("invalid settings: %v", err)
+	}
+	if err := ValidateRetention(retention); err != nil {
+		return nil, fmt.Errorf("invalid retention: %v", err)
+	}
+	if err := ValidateTopicName(name); err != nil {
+		return nil, fmt.Errorf("invalid topic name: %v", err)
+	}
+
 	ps.Mu.Lock()
 	defer ps.Mu.Unlock()
 
@@ -59,15 +218,48 @@ func (ps *PubSubSystem) NewTopic(name string) (*models.Topic, error) {
 		Name:        name,
 		Subscribers: make(map[string]*models.Subscriber),
 		Messages:    make([]*models.Message, 0),
-		MaxMessages: 100,
+		Retention:   retention,
+		Settings:    settings,
+		DeadLetters: ps,
+	}
+
+	if ps.Store != nil {
+		topicLog, err := ps.Store.OpenTopic(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open topic log: %w", err)
+		}
+		if err := topicLog.WriteMeta(topicMeta{Retention: retention, Settings: &settings}); err != nil {
+			return nil, fmt.Errorf("failed to write topic metadata: %w", err)
+		}
+		topic.Log = topicLog
+	}
+
+	// Attach wildcard subscribers that were waiting for this topic
+	for _, sub := range ps.Wildcards {
+		if MatchTopic(sub.Topic, name) {
+			attachWildcard(topic, sub)
+		}
 	}
 
 	ps.Topics[name] = topic
+	metrics.AddTopic(name)
 	return topic, nil
 }
 
 // DeleteTopic deletes a topic and unsubscribes all subscribers
 func (ps *PubSubSystem) DeleteTopic(name string) error {
+	if err := ps.deleteTopic(name); err != nil {
+		return err
+	}
+
+	if replicator := ps.Replicator(); replicator != nil {
+		replicator.TopicDeleted(name)
+	}
+	return nil
+}
+
+// deleteTopic deletes a topic on this node only
+func (ps *PubSubSystem) deleteTopic(name string) error {
 	ps.Mu.Lock()
 	defer ps.Mu.Unlock()
 
@@ -76,13 +268,27 @@ func (ps *PubSubSystem) DeleteTopic(name string) error {
 		return fmt.Errorf("topic not found")
 	}
 
-	// Close all subscriber connections
-	for _, sub := range topic.Subscribers {
-		close(sub.Queue)
+	// Close all subscriber connections; wildcard subscribers only lose this topic
+	topic.Mu.Lock()
+	for id, sub := range topic.Subscribers {
+		delete(topic.Subscribers, id)
+		if IsPattern(sub.Topic) {
+			continue
+		}
+		closeQueue(sub)
 		sub.Conn.Close()
 	}
+	topic.Mu.Unlock()
 
 	delete(ps.Topics, name)
+	ps.scheduler.cancelTopic(name)
+	metrics.ForgetTopic(name)
+
+	if ps.Store != nil {
+		if err := ps.Store.DeleteTopic(name); err != nil {
+			log.Printf("Failed to remove log for topic %s: %v", name, err)
+		}
+	}
 	return nil
 }
 
@@ -102,18 +308,63 @@ func (ps *PubSubSystem) GetStats() map[string]interface{} {
 
 	stats := make(map[string]interface{})
 	topicStats := make(map[string]interface{})
+	scheduled := ps.scheduler.count()
 
 	for name, topic := range ps.Topics {
+		topic.Mu.RLock()
+		groups := make(map[string]int)
+		drops := make(map[string]int64)
+		for id, sub := range topic.Subscribers {
+			if sub.Group != "" {
+				groups[sub.Group]++
+			}
+			if dropped := atomic.LoadInt64(&sub.Dropped); dropped > 0 {
+				drops[id] = dropped
+			}
+		}
 		topicStats[name] = map[string]interface{}{
 			"messages":    len(topic.Messages),
 			"subscribers": len(topic.Subscribers),
+			"groups":      groups,
+			"retention":   topic.Retention,
+			"usage":       retentionUsage(topic),
+			"dropped":     topic.Dropped,
+			"drops":       drops,
+			"scheduled":   scheduled[name],
 		}
+		topic.Mu.RUnlock()
 	}
 
 	stats["topics"] = topicStats
+	stats["connections"] = ps.Connections()
 	return stats
 }
 
+// MetricsSnapshot reports topics, subscribers and queue depths for the
+// Prometheus gauges
+func (ps *PubSubSystem) MetricsSnapshot() []metrics.TopicSnapshot {
+	ps.Mu.RLock()
+	defer ps.Mu.RUnlock()
+
+	topics := make([]metrics.TopicSnapshot, 0, len(ps.Topics))
+	for name, topic := range ps.Topics {
+		topic.Mu.RLock()
+		snapshot := metrics.TopicSnapshot{
+			Name:        name,
+			Subscribers: make([]metrics.SubscriberSnapshot, 0, len(topic.Subscribers)),
+		}
+		for id, sub := range topic.Subscribers {
+			snapshot.Subscribers = append(snapshot.Subscribers, metrics.SubscriberSnapshot{
+				ID:         id,
+				QueueDepth: len(sub.Queue),
+			})
+		}
+		topic.Mu.RUnlock()
+		topics = append(topics, snapshot)
+	}
+	return topics
+}
+
 // GetHealth returns system health information
 func (ps *PubSubSystem) GetHealth() map[string]interface{} {
 	ps.Mu.RLock()
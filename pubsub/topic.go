@@ -2,29 +2,55 @@ package pubsub
 
 import (
 	"fmt"
+	"os"
 	"time"
 
+	"pub-sub-system/metrics"
 	"pub-sub-system/models"
 )
 
 // TopicManager provides methods to work with Topic models
-type TopicManager struct{}
+type TopicManager struct {
+	groupStrategy string
+}
 
 // NewTopicManager creates a new topic manager
 func NewTopicManager() *TopicManager {
-	return &TopicManager{}
+	strategy := os.Getenv("CONSUMER_GROUP_STRATEGY")
+	if strategy != GroupLeastLoaded {
+		strategy = GroupRoundRobin
+	}
+	return &TopicManager{groupStrategy: strategy}
 }
 
-// AddMessage adds a message to a topic's history
-func (tm *TopicManager) AddMessage(topic *models.Topic, msg *models.Message) {
+// AddMessage assigns the next offset to a message and adds it to a topic's
+// history, writing it through to the topic's log first when persistence is
+// enabled. The topic's retention policy is applied afterwards.
+func (tm *TopicManager) AddMessage(topic *models.Topic, msg *models.Message) error {
 	topic.Mu.Lock()
 	defer topic.Mu.Unlock()
 
-	maxMessages := 100 // Fixed: Use constant until env var issue resolved
+	msg.Topic = topic.Name
+	msg.Offset = topic.NextOffset
+	msg.PublishedAt = time.Now().UTC()
+	if msg.TTLMs > 0 && msg.ExpiresAt == nil {
+		expiresAt := msg.PublishedAt.Add(time.Duration(msg.TTLMs) * time.Millisecond)
+		msg.ExpiresAt = &expiresAt
+	}
+	msg.Size = payloadSize(msg)
+	if topic.Log != nil {
+		if err := topic.Log.Append(msg); err != nil {
+			return fmt.Errorf("failed to persist message: %w", err)
+		}
+	}
+	topic.NextOffset++
+
 	topic.Messages = append(topic.Messages, msg)
-	if len(topic.Messages) > maxMessages {
-		topic.Messages = topic.Messages[1:] // Remove oldest message
+	topic.HistoryBytes += int64(msg.Size)
+	if enforceRetention(topic, msg.PublishedAt) > 0 {
+		truncateLog(topic)
 	}
+	return nil
 }
 
 // GetLastMessages returns the last N messages
@@ -32,13 +58,27 @@ func (tm *TopicManager) GetLastMessages(topic *models.Topic, n int) []*models.Me
 	topic.Mu.RLock()
 	defer topic.Mu.RUnlock()
 
-	if n <= 0 || n > len(topic.Messages) {
-		n = len(topic.Messages)
-	}
+	return lastMessages(topic, n)
+}
 
-	result := make([]*models.Message, n)
-	copy(result, topic.Messages[len(topic.Messages)-n:])
-	return result
+// GetMessagesFrom returns the messages starting at the given offset. An
+// OFFSET_OUT_OF_RANGE error is returned if the offset has been evicted from
+// history or has not been assigned yet.
+func (tm *TopicManager) GetMessagesFrom(topic *models.Topic, offset int64) ([]*models.Message, error) {
+	topic.Mu.RLock()
+	defer topic.Mu.RUnlock()
+
+	return messagesFrom(topic, offset)
+}
+
+// GetMessagesAfter returns the messages published after the message with the
+// given ID. The second result is false if that message is no longer in the
+// topic's history.
+func (tm *TopicManager) GetMessagesAfter(topic *models.Topic, messageID string) ([]*models.Message, bool) {
+	topic.Mu.RLock()
+	defer topic.Mu.RUnlock()
+
+	return messagesAfter(topic, messageID)
 }
 
 // AddSubscriber adds a subscriber to a topic
@@ -46,53 +86,221 @@ func (tm *TopicManager) AddSubscriber(topic *models.Topic, sub *models.Subscribe
 	topic.Mu.Lock()
 	defer topic.Mu.Unlock()
 
-	maxSubs := 100 // Fixed: Use constant until env var issue resolved
-	if len(topic.Subscribers) >= maxSubs {
+	if heldElsewhere(topic.Subscribers, sub) {
+		return errHeldElsewhere()
+	}
+	if atCapacity(topic, sub) {
 		return fmt.Errorf("maximum subscribers reached for topic")
 	}
+	applyQueueSize(sub, topic.Settings.QueueSize)
 
-	topic.Subscribers[sub.ID] = sub
+	initCursor(sub, topic.Name, topic.NextOffset)
+	sub.DeadLetters = topic.DeadLetters
+	replaceSubscriber(topic, sub)
 	return nil
 }
 
-// RemoveSubscriber removes a subscriber from a topic
-func (tm *TopicManager) RemoveSubscriber(topic *models.Topic, subID string) {
+// atCapacity reports whether adding sub would exceed the topic's subscriber
+// limit; replacing a subscriber with the same ID does not count. Callers
+// must hold topic.Mu.
+func atCapacity(topic *models.Topic, sub *models.Subscriber) bool {
+	if _, replacing := topic.Subscribers[sub.ID]; replacing {
+		return false
+	}
+	return len(topic.Subscribers) >= topic.Settings.MaxSubscribers
+}
+
+// heldElsewhere reports whether a subscriber with sub's ID belongs to another
+// connection. Subscribers are only replaced by their own connection, so a
+// client cannot take over another's subscription by reusing its ID.
+func heldElsewhere(subs map[string]*models.Subscriber, sub *models.Subscriber) bool {
+	old, exists := subs[sub.ID]
+	return exists && old.Conn != sub.Conn
+}
+
+// errHeldElsewhere is the error for a subscription whose ID is taken by
+// another connection
+func errHeldElsewhere() error {
+	return &models.Error{Code: "BAD_REQUEST", Message: "client_id is already subscribed on another connection"}
+}
+
+// replaceSubscriber registers sub on a topic. A previous subscriber with the
+// same ID, which heldElsewhere has checked belongs to the same connection,
+// has its queue closed so its processor stops, instead of being left running
+// with nothing to feed it. Callers must hold topic.Mu for writing.
+func replaceSubscriber(topic *models.Topic, sub *models.Subscriber) {
+	if old, exists := topic.Subscribers[sub.ID]; exists && old != sub {
+		closeQueue(old)
+	}
+	topic.Subscribers[sub.ID] = sub
+}
+
+// GetSubscriber returns a topic's subscriber by ID
+func (tm *TopicManager) GetSubscriber(topic *models.Topic, subID string) (*models.Subscriber, bool) {
+	topic.Mu.RLock()
+	defer topic.Mu.RUnlock()
+
+	sub, exists := topic.Subscribers[subID]
+	return sub, exists
+}
+
+// RemoveSubscriber removes a connection's subscriber from a topic and
+// reports whether it existed; a subscriber with the ID held by another
+// connection is left alone. Messages still queued or unacknowledged for a
+// consumer group member are handed to the remaining members.
+func (tm *TopicManager) RemoveSubscriber(topic *models.Topic, subID string, conn models.WebSocketConn) bool {
+	topic.Mu.RLock()
+	sub, exists := topic.Subscribers[subID]
+	topic.Mu.RUnlock()
+	if !exists || sub.Conn != conn {
+		return false
+	}
+	tm.removeSubscriber(topic, sub)
+	return true
+}
+
+// removeSubscriber removes sub from a topic unless it has already been
+// removed or replaced
+func (tm *TopicManager) removeSubscriber(topic *models.Topic, sub *models.Subscriber) {
 	topic.Mu.Lock()
-	defer topic.Mu.Unlock()
+	if topic.Subscribers[sub.ID] != sub {
+		topic.Mu.Unlock()
+		return
+	}
+
+	delete(topic.Subscribers, sub.ID)
+	// Close first so a concurrent Broadcast cannot queue a message after the
+	// pending ones were taken
+	closeQueue(sub)
+	var pending []*models.Message
+	if sub.Group != "" {
+		pending = takePending(sub)
+	}
+	topic.Mu.Unlock()
 
-	if sub, exists := topic.Subscribers[subID]; exists {
-		close(sub.Queue)
-		delete(topic.Subscribers, subID)
+	if len(pending) > 0 {
+		tm.Redistribute(topic, sub.Group, pending)
 	}
 }
 
-// Broadcast sends a message to all subscribers of a topic
-func (tm *TopicManager) Broadcast(topic *models.Topic, msg *models.Message) {
+// RemoveSubscribersByConn removes every exact subscription on a topic that
+// belongs to the given connection and returns the removed subscribers
+func (tm *TopicManager) RemoveSubscribersByConn(topic *models.Topic, conn models.WebSocketConn) []*models.Subscriber {
 	topic.Mu.RLock()
-	subscribers := make([]*models.Subscriber, 0, len(topic.Subscribers))
+	subs := make([]*models.Subscriber, 0)
 	for _, sub := range topic.Subscribers {
-		subscribers = append(subscribers, sub)
+		if sub.Conn == conn && !IsPattern(sub.Topic) {
+			subs = append(subs, sub)
+		}
 	}
 	topic.Mu.RUnlock()
 
-	// Send message to each subscriber
-	for _, sub := range subscribers {
-		select {
-		case sub.Queue <- msg:
-			// Message sent successfully
-		default:
-			// Queue is full, send SLOW_CONSUMER error
-			errorMsg := &models.ServerMessage{
-				Type: "error",
-				Error: &models.Error{
-					Code:    "SLOW_CONSUMER",
-					Message: "Subscriber queue overflow",
-				},
-				TS: time.Now().UTC().Format(time.RFC3339),
-			}
-			sub.Conn.WriteJSON(errorMsg)
-			// Close connection for slow consumer
-			sub.Conn.Close()
+	for _, sub := range subs {
+		tm.removeSubscriber(topic, sub)
+	}
+	return subs
+}
+
+// Broadcast sends a message to all ungrouped subscribers of a topic and to
+// one member of each consumer group
+func (tm *TopicManager) Broadcast(topic *models.Topic, msg *models.Message) {
+	topic.Mu.Lock()
+	deliveries := make([]delivery, 0, len(topic.Subscribers))
+	groups := make(map[string][]*models.Subscriber)
+	for _, sub := range topic.Subscribers {
+		if !wants(sub, msg) {
+			continue
+		}
+		if sub.Group == "" {
+			deliveries = append(deliveries, newDelivery(topic, sub))
+		} else {
+			groups[sub.Group] = append(groups[sub.Group], sub)
+		}
+	}
+	for group, members := range groups {
+		deliveries = append(deliveries, newDelivery(topic, tm.pickGroupMember(topic, group, members)))
+	}
+	topic.Mu.Unlock()
+
+	// Enqueue outside the lock, since the block policy may wait for a slow
+	// subscriber; a subscriber removed meanwhile is skipped. Full queues are
+	// handled by the subscriber's backpressure policy; block timeouts share
+	// one start time so a publish waits at most the longest timeout.
+	start := time.Now()
+	overflowed := make([]*models.Subscriber, 0)
+	lost := make(map[*models.Subscriber][]*models.Message)
+	drops := make(map[*models.Subscriber]int)
+	for _, d := range deliveries {
+		if enqueue(d.sub, msg) {
+			continue
 		}
+		dropped, disconnect := applyBackpressure(d, msg, start)
+		if len(dropped) > 0 {
+			lost[d.sub] = dropped
+		}
+		if disconnect {
+			overflowed = append(overflowed, d.sub)
+		} else if len(dropped) > 0 {
+			drops[d.sub] = len(dropped)
+		}
+	}
+
+	if len(drops) > 0 {
+		topic.Mu.Lock()
+		for sub, n := range drops {
+			recordDrops(topic, sub, n)
+		}
+		topic.Mu.Unlock()
+	}
+
+	// Dead-letter outside the lock since it publishes to another topic
+	for sub, msgs := range lost {
+		for _, m := range msgs {
+			deadLetter(sub, m, ReasonOverflow)
+		}
+	}
+	for _, sub := range overflowed {
+		metrics.SlowConsumerDisconnects.WithLabelValues(metrics.TopicLabel(topic.Name)).Inc()
+		tm.disconnectSlowConsumer(sub)
+	}
+}
+
+// wants reports whether a message passes a subscriber's filter
+func wants(sub *models.Subscriber, msg *models.Message) bool {
+	return sub.Filter == nil || sub.Filter.Match(msg)
+}
+
+// enqueue adds a message to a subscriber's queue without blocking and
+// reports whether there was room. A subscriber whose queue was closed
+// meanwhile no longer wants messages, so that counts as success.
+func enqueue(sub *models.Subscriber, msg *models.Message) bool {
+	sub.QueueMu.RLock()
+	defer sub.QueueMu.RUnlock()
+
+	if sub.QueueClosed {
+		return true
+	}
+	select {
+	case sub.Queue <- msg:
+		return true
+	default:
+		return false
+	}
+}
+
+// disconnectSlowConsumer sends SLOW_CONSUMER and closes the connection of a
+// subscriber whose queue overflowed
+func (tm *TopicManager) disconnectSlowConsumer(sub *models.Subscriber) {
+	errorMsg := &models.ServerMessage{
+		Type: "error",
+		Error: &models.Error{
+			Code:    "SLOW_CONSUMER",
+			Message: "Subscriber queue overflow",
+		},
+		TS: time.Now().UTC().Format(time.RFC3339),
 	}
+	metrics.Errors.WithLabelValues("SLOW_CONSUMER").Inc()
+	sub.Conn.WriteJSON(errorMsg)
+	// Close connection for slow consumer
+	sub.Conn.Close()
 }
@@ -0,0 +1,130 @@
+package pubsub
+
+import (
+	"testing"
+	"time"
+
+	"pub-sub-system/models"
+)
+
+// nopConn is a subscriber connection that discards everything
+type nopConn struct{}
+
+func (nopConn) WriteJSON(v interface{}) error { return nil }
+func (nopConn) Close() error                  { return nil }
+
+// taggedConn is a nopConn that compares equal only to a conn with the same tag
+type taggedConn struct {
+	nopConn
+	tag int
+}
+
+func TestBroadcastBlockPolicyDoesNotHoldTopicLock(t *testing.T) {
+	ps := NewPubSubSystem()
+	defer ps.Close()
+
+	settings := ps.DefaultSettings()
+	settings.QueueSize = 1
+	settings.Backpressure = BackpressureBlock
+	settings.BlockTimeoutMs = 5000
+	topic, err := ps.NewTopicWithSettings("orders", settings, DefaultRetention())
+	if err != nil {
+		t.Fatalf("NewTopicWithSettings() error = %v", err)
+	}
+
+	// A subscriber without a processor fills its queue with one message
+	subManager := NewSubscriberManager()
+	slow := subManager.NewSubscriber("slow", "orders", nopConn{})
+	if err := ps.topicManager.AddSubscriber(topic, slow); err != nil {
+		t.Fatalf("AddSubscriber() error = %v", err)
+	}
+	publish := func() error {
+		msg := &models.Message{Payload: "x"}
+		if err := PrepareMessage(msg); err != nil {
+			return err
+		}
+		return ps.Publish("orders", msg)
+	}
+	if err := publish(); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+
+	published := make(chan error, 1)
+	go func() { published <- publish() }()
+
+	// The second publish waits for the slow subscriber, but the topic stays
+	// available meanwhile
+	time.Sleep(50 * time.Millisecond)
+	subscribed := make(chan error, 1)
+	go func() {
+		subscribed <- ps.topicManager.AddSubscriber(topic, subManager.NewSubscriber("other", "orders", nopConn{}))
+	}()
+	select {
+	case err := <-subscribed:
+		if err != nil {
+			t.Fatalf("AddSubscriber() error = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("AddSubscriber blocked behind a publish waiting for a slow subscriber")
+	}
+
+	// Removing the slow subscriber releases the waiting publish
+	ps.topicManager.RemoveSubscriber(topic, "slow", slow.Conn)
+	select {
+	case err := <-published:
+		if err != nil {
+			t.Fatalf("Publish() error = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("publish still blocked after its subscriber was removed")
+	}
+}
+
+func TestSubscriberIDIsOnlyReplacedOrRemovedByItsConnection(t *testing.T) {
+	ps := NewPubSubSystem()
+	defer ps.Close()
+
+	topic, err := ps.NewTopic("orders")
+	if err != nil {
+		t.Fatalf("NewTopic() error = %v", err)
+	}
+	subManager := NewSubscriberManager()
+	owner, other := taggedConn{tag: 1}, taggedConn{tag: 2}
+
+	sub := subManager.NewSubscriber("client", "orders", owner)
+	if err := ps.topicManager.AddSubscriber(topic, sub); err != nil {
+		t.Fatalf("AddSubscriber() error = %v", err)
+	}
+	if err := ps.topicManager.AddSubscriber(topic, subManager.NewSubscriber("client", "orders", other)); err == nil {
+		t.Fatal("AddSubscriber() from another connection replaced the subscriber")
+	}
+	if ps.topicManager.RemoveSubscriber(topic, "client", other) {
+		t.Fatal("RemoveSubscriber() from another connection removed the subscriber")
+	}
+	if got, _ := ps.topicManager.GetSubscriber(topic, "client"); got != sub {
+		t.Fatal("subscriber was replaced by another connection")
+	}
+
+	// The owning connection can replace and remove it
+	replacement := subManager.NewSubscriber("client", "orders", owner)
+	if err := ps.topicManager.AddSubscriber(topic, replacement); err != nil {
+		t.Fatalf("AddSubscriber() from the same connection error = %v", err)
+	}
+	if !ps.topicManager.RemoveSubscriber(topic, "client", owner) {
+		t.Fatal("RemoveSubscriber() from the same connection = false")
+	}
+
+	pattern := subManager.NewSubscriber(SubscriberKey("client", "orders.*"), "orders.*", owner)
+	if err := ps.AddWildcardSubscriber(pattern); err != nil {
+		t.Fatalf("AddWildcardSubscriber() error = %v", err)
+	}
+	if err := ps.AddWildcardSubscriber(subManager.NewSubscriber(SubscriberKey("client", "orders.*"), "orders.*", other)); err == nil {
+		t.Fatal("AddWildcardSubscriber() from another connection replaced the subscriber")
+	}
+	if ps.RemoveWildcardSubscriber("client", "orders.*", other) {
+		t.Fatal("RemoveWildcardSubscriber() from another connection removed the subscriber")
+	}
+	if !ps.RemoveWildcardSubscriber("client", "orders.*", owner) {
+		t.Fatal("RemoveWildcardSubscriber() from the same connection = false")
+	}
+}
@@ -0,0 +1,297 @@
+package pubsub
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"pub-sub-system/models"
+)
+
+// Topic names are dot-separated hierarchies such as "orders.eu.de".
+// Subscription patterns may use "*" to match exactly one level and ">" as
+// the last level to match one or more remaining levels.
+const (
+	levelSeparator  = "."
+	singleLevelWild = "*"
+	multiLevelWild  = ">"
+)
+
+// IsPattern reports whether a subscription topic contains wildcards
+func IsPattern(topic string) bool {
+	return strings.Contains(topic, singleLevelWild) || strings.Contains(topic, multiLevelWild)
+}
+
+// ValidateTopicName checks that a concrete topic name is well formed
+func ValidateTopicName(name string) error {
+	if name == "" {
+		return fmt.Errorf("topic name is required")
+	}
+	if IsPattern(name) {
+		return fmt.Errorf("topic name must not contain wildcards")
+	}
+	for _, level := range strings.Split(name, levelSeparator) {
+		if level == "" {
+			return fmt.Errorf("topic name must not contain empty levels")
+		}
+	}
+	return nil
+}
+
+// ValidatePattern checks that a wildcard subscription pattern is well formed
+func ValidatePattern(pattern string) error {
+	levels := strings.Split(pattern, levelSeparator)
+	for i, level := range levels {
+		switch {
+		case level == "":
+			return fmt.Errorf("pattern must not contain empty levels")
+		case level == multiLevelWild && i != len(levels)-1:
+			return fmt.Errorf("%q is only allowed as the last level", multiLevelWild)
+		case level != singleLevelWild && level != multiLevelWild && IsPattern(level):
+			return fmt.Errorf("wildcards must occupy a whole level")
+		}
+	}
+	return nil
+}
+
+// MatchTopic reports whether a concrete topic name matches a pattern
+func MatchTopic(pattern, name string) bool {
+	patternLevels := strings.Split(pattern, levelSeparator)
+	nameLevels := strings.Split(name, levelSeparator)
+
+	for i, level := range patternLevels {
+		if level == multiLevelWild {
+			return len(nameLevels) > i
+		}
+		if i >= len(nameLevels) {
+			return false
+		}
+		if level != singleLevelWild && level != nameLevels[i] {
+			return false
+		}
+	}
+	return len(patternLevels) == len(nameLevels)
+}
+
+// PatternCovers reports whether every topic matched by sub, a topic name or
+// pattern, is also matched by pattern
+func PatternCovers(pattern, sub string) bool {
+	patternLevels := strings.Split(pattern, levelSeparator)
+	subLevels := strings.Split(sub, levelSeparator)
+
+	for i, level := range patternLevels {
+		if level == multiLevelWild {
+			return len(subLevels) > i
+		}
+		if i >= len(subLevels) {
+			return false
+		}
+		switch {
+		case subLevels[i] == multiLevelWild:
+			return false // sub can match more levels than pattern allows
+		case level == singleLevelWild:
+			continue
+		case level != subLevels[i]:
+			return false
+		}
+	}
+	return len(patternLevels) == len(subLevels)
+}
+
+// SubscriberKey returns the key a subscriber is stored under in
+// Topic.Subscribers. Exact subscriptions keep using the client ID so that
+// existing unsubscribe requests continue to work.
+func SubscriberKey(clientID, topic string) string {
+	if IsPattern(topic) {
+		return clientID + "@" + topic
+	}
+	return clientID
+}
+
+// AddWildcardSubscriber registers a pattern subscriber and attaches it to
+// every existing topic it matches. Topics created later are attached in
+// NewTopic. A previous subscription with the same client ID and pattern is
+// replaced if it belongs to the same connection.
+func (ps *PubSubSystem) AddWildcardSubscriber(sub *models.Subscriber) error {
+	return ps.AddWildcardSubscriberWithHistory(sub, HistoryRequest{})
+}
+
+// AddWildcardSubscriberWithHistory is AddWildcardSubscriber with replay. The
+// history asked for is snapshotted from each matching topic under the same
+// lock that attaches the subscriber, as AddSubscriberWithHistory does, so
+// replay and live delivery neither overlap nor leave a gap. AfterID and
+// LastN apply per topic; offsets are per topic, so ResumeFrom and
+// FromOffset are ignored.
+func (ps *PubSubSystem) AddWildcardSubscriberWithHistory(sub *models.Subscriber, req HistoryRequest) error {
+	if err := ValidatePattern(sub.Topic); err != nil {
+		return err
+	}
+
+	ps.Mu.Lock()
+	defer ps.Mu.Unlock()
+
+	if heldElsewhere(ps.Wildcards, sub) {
+		return errHeldElsewhere()
+	}
+	if old, exists := ps.Wildcards[sub.ID]; exists {
+		ps.detachWildcard(old)
+		closeQueue(old)
+	}
+
+	sub.DeadLetters = ps
+	ps.Wildcards[sub.ID] = sub
+	for name, topic := range ps.Topics {
+		if !MatchTopic(sub.Topic, name) {
+			continue
+		}
+
+		topic.Mu.Lock()
+		if attachWildcardLocked(topic, sub) && (req.AfterID != "" || req.LastN > 0) {
+			if req.AfterID != "" {
+				backlog, _ := messagesAfter(topic, req.AfterID)
+				sub.Backlog = append(sub.Backlog, backlog...)
+			} else {
+				sub.Backlog = append(sub.Backlog, lastMessages(topic, req.LastN)...)
+			}
+			skipBelow(sub, topic)
+		}
+		topic.Mu.Unlock()
+	}
+	return nil
+}
+
+// GetWildcardSubscriber returns a client's subscription to a pattern
+func (ps *PubSubSystem) GetWildcardSubscriber(clientID, pattern string) (*models.Subscriber, bool) {
+	ps.Mu.RLock()
+	defer ps.Mu.RUnlock()
+
+	sub, exists := ps.Wildcards[SubscriberKey(clientID, pattern)]
+	return sub, exists
+}
+
+// RemoveWildcardSubscriber detaches a connection's pattern subscriber from
+// all topics and stops its delivery. It reports false if there is none; a
+// subscriber with the client ID held by another connection is left alone.
+func (ps *PubSubSystem) RemoveWildcardSubscriber(clientID, pattern string, conn models.WebSocketConn) bool {
+	ps.Mu.Lock()
+	sub, exists := ps.Wildcards[SubscriberKey(clientID, pattern)]
+	if !exists || sub.Conn != conn {
+		ps.Mu.Unlock()
+		return false
+	}
+	pending := ps.removeWildcard(sub)
+	ps.Mu.Unlock()
+
+	ps.redistributeWildcard(sub, pending)
+	return true
+}
+
+// RemoveWildcardSubscribersByConn removes every pattern subscription that
+// belongs to the given connection and returns the removed subscribers
+func (ps *PubSubSystem) RemoveWildcardSubscribersByConn(conn models.WebSocketConn) []*models.Subscriber {
+	ps.Mu.Lock()
+	removed := make(map[*models.Subscriber][]*models.Message)
+	for _, sub := range ps.Wildcards {
+		if sub.Conn == conn {
+			removed[sub] = ps.removeWildcard(sub)
+		}
+	}
+	ps.Mu.Unlock()
+
+	subs := make([]*models.Subscriber, 0, len(removed))
+	for sub, pending := range removed {
+		ps.redistributeWildcard(sub, pending)
+		subs = append(subs, sub)
+	}
+	return subs
+}
+
+// removeWildcard unregisters and closes a pattern subscriber, returning any
+// messages still queued for it when it belongs to a consumer group. Callers
+// must hold ps.Mu.
+func (ps *PubSubSystem) removeWildcard(sub *models.Subscriber) []*models.Message {
+	ps.detachWildcard(sub)
+	delete(ps.Wildcards, sub.ID)
+
+	// Close first so a concurrent Broadcast cannot queue a message after the
+	// pending ones were taken
+	closeQueue(sub)
+	var pending []*models.Message
+	if sub.Group != "" {
+		pending = takePending(sub)
+	}
+	return pending
+}
+
+// redistributeWildcard hands a departed group member's queued messages back
+// to the group on each message's concrete topic
+func (ps *PubSubSystem) redistributeWildcard(sub *models.Subscriber, pending []*models.Message) {
+	if len(pending) == 0 {
+		return
+	}
+
+	for _, msg := range pending {
+		if topic, exists := ps.GetTopic(msg.Topic); exists {
+			ps.topicManager.Redistribute(topic, sub.Group, []*models.Message{msg})
+		}
+	}
+}
+
+// ListTopics returns all topics
+func (ps *PubSubSystem) ListTopics() []*models.Topic {
+	ps.Mu.RLock()
+	defer ps.Mu.RUnlock()
+
+	topics := make([]*models.Topic, 0, len(ps.Topics))
+	for _, topic := range ps.Topics {
+		topics = append(topics, topic)
+	}
+	return topics
+}
+
+// GetMatchingTopics returns all topics whose names match a pattern
+func (ps *PubSubSystem) GetMatchingTopics(pattern string) []*models.Topic {
+	ps.Mu.RLock()
+	defer ps.Mu.RUnlock()
+
+	topics := make([]*models.Topic, 0)
+	for name, topic := range ps.Topics {
+		if MatchTopic(pattern, name) {
+			topics = append(topics, topic)
+		}
+	}
+	return topics
+}
+
+// detachWildcard removes a pattern subscriber from every topic. Callers
+// must hold ps.Mu.
+func (ps *PubSubSystem) detachWildcard(sub *models.Subscriber) {
+	for _, topic := range ps.Topics {
+		topic.Mu.Lock()
+		if topic.Subscribers[sub.ID] == sub {
+			delete(topic.Subscribers, sub.ID)
+		}
+		topic.Mu.Unlock()
+	}
+}
+
+// attachWildcard adds a pattern subscriber to a single topic, respecting the
+// topic's subscriber limit
+func attachWildcard(topic *models.Topic, sub *models.Subscriber) {
+	topic.Mu.Lock()
+	defer topic.Mu.Unlock()
+
+	attachWildcardLocked(topic, sub)
+}
+
+// attachWildcardLocked is attachWildcard for callers that hold topic.Mu for
+// writing. It reports whether the subscriber was attached.
+func attachWildcardLocked(topic *models.Topic, sub *models.Subscriber) bool {
+	if len(topic.Subscribers) >= topic.Settings.MaxSubscribers {
+		log.Printf("Not attaching %s to topic %s: maximum subscribers reached", sub.ID, topic.Name)
+		return false
+	}
+	initCursor(sub, topic.Name, topic.NextOffset)
+	topic.Subscribers[sub.ID] = sub
+	return true
+}
@@ -0,0 +1,50 @@
+package pubsub
+
+import (
+	"testing"
+
+	"pub-sub-system/models"
+)
+
+func TestAddWildcardSubscriberWithHistorySkipsReplayedMessages(t *testing.T) {
+	ps := NewPubSubSystem()
+	defer ps.Close()
+
+	for _, name := range []string{"orders.eu", "orders.us", "billing"} {
+		if _, err := ps.NewTopic(name); err != nil {
+			t.Fatalf("NewTopic(%s) error = %v", name, err)
+		}
+		for i := 0; i < 3; i++ {
+			msg := &models.Message{Payload: i}
+			if err := PrepareMessage(msg); err != nil {
+				t.Fatalf("PrepareMessage() error = %v", err)
+			}
+			if err := ps.Publish(name, msg); err != nil {
+				t.Fatalf("Publish() error = %v", err)
+			}
+		}
+	}
+
+	sub := NewSubscriberManager().NewSubscriber(SubscriberKey("client", "orders.*"), "orders.*", nopConn{})
+	if err := ps.AddWildcardSubscriberWithHistory(sub, HistoryRequest{LastN: 2}); err != nil {
+		t.Fatalf("AddWildcardSubscriberWithHistory() error = %v", err)
+	}
+
+	if len(sub.Backlog) != 4 {
+		t.Fatalf("backlog has %d messages, want 2 from each matching topic", len(sub.Backlog))
+	}
+	for _, msg := range sub.Backlog {
+		if msg.Topic == "billing" {
+			t.Errorf("backlog contains message from non-matching topic %s", msg.Topic)
+		}
+	}
+	// Live messages already in the backlog are skipped by the processor
+	for _, name := range []string{"orders.eu", "orders.us"} {
+		if got := sub.SkipBelow[name]; got != 3 {
+			t.Errorf("SkipBelow[%s] = %d, want 3", name, got)
+		}
+	}
+	if _, exists := sub.SkipBelow["billing"]; exists {
+		t.Error("SkipBelow set for non-matching topic billing")
+	}
+}
@@ -0,0 +1,120 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Limits on commands read from clients
+const (
+	maxLineLength = 64 << 10 // Inline commands and array and bulk headers
+	maxBulkLength = 1 << 20
+	maxArgs       = 1024
+)
+
+// protocolError is a malformed command; the connection is closed after
+// replying to it, as Redis does
+type protocolError struct {
+	msg string
+}
+
+func (e *protocolError) Error() string {
+	return "Protocol error: " + e.msg
+}
+
+// readCommand reads one command, either a RESP array of bulk strings as sent
+// by client libraries and redis-cli or an inline command typed by hand. An
+// empty inline command or array returns no arguments.
+func readCommand(r *bufio.Reader) ([][]byte, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		return bytes.Fields(line), nil
+	}
+
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n > maxArgs {
+		return nil, &protocolError{msg: "invalid multibulk length"}
+	}
+	if n <= 0 {
+		// Redis treats *0 and *-1 as an empty command
+		return nil, nil
+	}
+
+	args := make([][]byte, 0, n)
+	for i := 0; i < n; i++ {
+		header, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(header) == 0 || header[0] != '$' {
+			return nil, &protocolError{msg: fmt.Sprintf("expected '$', got '%s'", header)}
+		}
+		size, err := strconv.Atoi(string(header[1:]))
+		if err != nil || size < 0 || size > maxBulkLength {
+			return nil, &protocolError{msg: "invalid bulk length"}
+		}
+
+		bulk := make([]byte, size+2)
+		if _, err := io.ReadFull(r, bulk); err != nil {
+			return nil, err
+		}
+		if bulk[size] != '\r' || bulk[size+1] != '\n' {
+			return nil, &protocolError{msg: "bulk string not terminated by CRLF"}
+		}
+		args = append(args, bulk[:size])
+	}
+	return args, nil
+}
+
+// readLine reads a line without its CRLF or LF terminator
+func readLine(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return nil, &protocolError{msg: "too big inline request"}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	line = bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))
+	return append([]byte(nil), line...), nil
+}
+
+// appendSimple appends a simple string reply such as +OK
+func appendSimple(b []byte, s string) []byte {
+	return append(append(append(b, '+'), s...), "\r\n"...)
+}
+
+// appendError appends an error reply. The first word is the error code, as
+// in "ERR unknown command" or "FORBIDDEN publish not allowed".
+func appendError(b []byte, s string) []byte {
+	return append(append(append(b, '-'), s...), "\r\n"...)
+}
+
+// appendInt appends an integer reply
+func appendInt(b []byte, n int64) []byte {
+	return append(strconv.AppendInt(append(b, ':'), n, 10), "\r\n"...)
+}
+
+// appendBulk appends a bulk string reply
+func appendBulk(b []byte, data []byte) []byte {
+	b = strconv.AppendInt(append(b, '$'), int64(len(data)), 10)
+	return append(append(append(b, "\r\n"...), data...), "\r\n"...)
+}
+
+// appendNull appends the null bulk string
+func appendNull(b []byte) []byte {
+	return append(b, "$-1\r\n"...)
+}
+
+// appendArray appends the header of an array reply of n elements
+func appendArray(b []byte, n int) []byte {
+	return append(strconv.AppendInt(append(b, '*'), int64(n), 10), "\r\n"...)
+}
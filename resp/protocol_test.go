@@ -0,0 +1,67 @@
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadCommand(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{"array", "*2\r\n$7\r\nPUBLISH\r\n$0\r\n\r\n", []string{"PUBLISH", ""}},
+		{"inline", "SUBSCRIBE a.b  c\r\n", []string{"SUBSCRIBE", "a.b", "c"}},
+		{"inline without CR", "PING\n", []string{"PING"}},
+		{"empty inline", "\r\n", nil},
+		{"empty array", "*0\r\n", nil},
+		{"null array", "*-1\r\n", nil},
+		{"negative array", "*-5\r\n", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, err := readCommand(bufio.NewReader(strings.NewReader(tt.input)))
+			if err != nil {
+				t.Fatalf("readCommand() error = %v", err)
+			}
+			var got []string
+			for _, arg := range args {
+				got = append(got, string(arg))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readCommand() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadCommandMalformed(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"non-numeric array length", "*x\r\n"},
+		{"too many arguments", "*1025\r\n"},
+		{"missing bulk header", "*1\r\n+PING\r\n"},
+		{"non-numeric bulk length", "*1\r\n$x\r\n"},
+		{"negative bulk length", "*1\r\n$-1\r\n"},
+		{"bulk too long", "*1\r\n$1048577\r\n"},
+		{"bulk without CRLF", "*1\r\n$4\r\nPINGxx"},
+		{"line too long", strings.Repeat("a", maxLineLength+1) + "\r\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := readCommand(bufio.NewReaderSize(strings.NewReader(tt.input), maxLineLength))
+			var protoErr *protocolError
+			if !errors.As(err, &protoErr) {
+				t.Errorf("readCommand() error = %v, want a protocol error", err)
+			}
+		})
+	}
+}
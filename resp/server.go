@@ -0,0 +1,76 @@
+package resp
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/url"
+
+	"pub-sub-system/auth"
+	"pub-sub-system/listener"
+	"pub-sub-system/pubsub"
+)
+
+// Server is a Redis-compatible listener speaking enough RESP2 for
+// PUBLISH, SUBSCRIBE and PSUBSCRIBE, backed by the pub/sub topics, so Redis
+// clients and tools exchange messages with clients of the other APIs
+type Server struct {
+	pubSubSystem  *pubsub.PubSubSystem
+	topicManager  *pubsub.TopicManager
+	subManager    *pubsub.SubscriberManager
+	authenticator auth.Authenticator // nil disables authentication
+	authorizer    *auth.Authorizer   // nil allows every request
+
+	listener listener.Listener
+}
+
+// New creates the Redis listener. When authentication is enabled, clients
+// send their API key or JWT with AUTH before any other command.
+func New(pubSubSystem *pubsub.PubSubSystem, authenticator auth.Authenticator, authorizer *auth.Authorizer) *Server {
+	return &Server{
+		pubSubSystem:  pubSubSystem,
+		topicManager:  pubsub.NewTopicManager(),
+		subManager:    pubsub.NewSubscriberManager(),
+		authenticator: authenticator,
+		authorizer:    authorizer,
+	}
+}
+
+// Serve accepts connections on lis until Close is called
+func (s *Server) Serve(lis net.Listener) error {
+	return s.listener.Serve(lis, s.handle)
+}
+
+// Close stops accepting connections and disconnects every client
+func (s *Server) Close() error {
+	return s.listener.Close()
+}
+
+// handle runs one client connection
+func (s *Server) handle(conn net.Conn) {
+	defer s.pubSubSystem.TrackConnection("redis", "resp2")()
+
+	sess := newSession(s, conn, bufio.NewReaderSize(conn, maxLineLength))
+	sess.run()
+}
+
+// authenticate checks the password sent with AUTH
+func (s *Server) authenticate(password string) (*auth.Principal, error) {
+	// The authenticator reads HTTP headers, so pass the password as a
+	// bearer token
+	r := &http.Request{Header: make(http.Header), URL: &url.URL{}}
+	r.Header.Set("Authorization", "Bearer "+password)
+	return s.authenticator.Authenticate(r)
+}
+
+// ensureTopic makes sure a topic exists, creating it if the client may.
+// Redis channels need no creating, so the first publish or subscribe to a
+// name creates the topic.
+func (s *Server) ensureTopic(sess *session, name string) error {
+	return listener.EnsureTopic(s.pubSubSystem, name, func() error {
+		if !s.authorizer.Allowed(sess.principal, auth.ActionCreate, name) {
+			return forbidden(auth.ActionCreate, name)
+		}
+		return nil
+	})
+}
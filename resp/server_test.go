@@ -0,0 +1,123 @@
+package resp
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"pub-sub-system/pubsub"
+)
+
+// testClient is a raw RESP connection to the listener
+type testClient struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dialTest(t *testing.T, addr string) *testClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testClient{t: t, conn: conn, reader: bufio.NewReader(conn)}
+}
+
+// send writes a command as an array of bulk strings
+func (c *testClient) send(args ...string) {
+	c.t.Helper()
+	cmd := fmt.Sprintf("*%d\r\n", len(args))
+	for _, arg := range args {
+		cmd += fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := c.conn.Write([]byte(cmd)); err != nil {
+		c.t.Fatalf("write: %v", err)
+	}
+}
+
+// expect reads reply lines, without CRLF, and compares them joined by "|"
+func (c *testClient) expect(want string) {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	lines := make([]string, 0)
+	for i := 0; i < strings.Count(want, "|")+1; i++ {
+		line, err := c.reader.ReadString('\n')
+		if err != nil {
+			c.t.Fatalf("read after %q: %v", lines, err)
+		}
+		lines = append(lines, strings.TrimSuffix(line, "\r\n"))
+	}
+	if got := strings.Join(lines, "|"); got != want {
+		c.t.Fatalf("reply = %q, want %q", got, want)
+	}
+}
+
+// readPush reads an array of bulk strings and returns them joined by spaces
+func (c *testClient) readPush() string {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	args, err := readCommand(c.reader)
+	if err != nil {
+		c.t.Fatalf("read push: %v", err)
+	}
+	parts := make([]string, 0, len(args))
+	for _, arg := range args {
+		parts = append(parts, string(arg))
+	}
+	return strings.Join(parts, " ")
+}
+
+func startTestServer(t *testing.T) (*pubsub.PubSubSystem, string) {
+	t.Helper()
+	ps := pubsub.NewPubSubSystem()
+	srv := New(ps, nil, nil)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	go srv.Serve(lis)
+	t.Cleanup(func() { srv.Close() })
+	return ps, lis.Addr().String()
+}
+
+func TestPublishSubscribe(t *testing.T) {
+	_, addr := startTestServer(t)
+	sub := dialTest(t, addr)
+	pub := dialTest(t, addr)
+
+	sub.send("SUBSCRIBE", "orders.eu")
+	sub.expect("*3|$9|subscribe|$9|orders.eu|:1")
+	sub.send("PSUBSCRIBE", "orders.*")
+	sub.expect("*3|$10|psubscribe|$8|orders.*|:2")
+
+	// The count includes the pattern subscriber
+	pub.send("PUBLISH", "orders.eu", "hello")
+	pub.expect(":2")
+	// Exact and pattern deliveries arrive in either order
+	pushes := map[string]bool{sub.readPush(): true, sub.readPush(): true}
+	for _, want := range []string{"message orders.eu hello", "pmessage orders.* orders.eu hello"} {
+		if !pushes[want] {
+			t.Errorf("pushes = %v, missing %q", pushes, want)
+		}
+	}
+
+	sub.send("UNSUBSCRIBE")
+	sub.expect("*3|$11|unsubscribe|$9|orders.eu|:1")
+	pub.send("PUBLISH", "orders.eu", "again")
+	pub.expect(":1")
+	sub.expect("*4|$8|pmessage|$8|orders.*|$9|orders.eu|$5|again")
+}
+
+func TestNegativeMultibulkLength(t *testing.T) {
+	_, addr := startTestServer(t)
+	c := dialTest(t, addr)
+
+	c.conn.Write([]byte("*-5\r\n"))
+	c.send("PING")
+	c.expect("+PONG")
+}
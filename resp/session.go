@@ -0,0 +1,470 @@
+package resp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	"pub-sub-system/auth"
+	"pub-sub-system/listener"
+	"pub-sub-system/metrics"
+	"pub-sub-system/models"
+	"pub-sub-system/pubsub"
+
+	"github.com/google/uuid"
+)
+
+// session is one Redis client connection
+type session struct {
+	server        *Server
+	id            string // Subscriber ID of the connection's subscriptions
+	conn          net.Conn
+	reader        *bufio.Reader
+	principal     *auth.Principal
+	authenticated bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	writeMu sync.Mutex
+
+	mu       sync.Mutex
+	channels map[string]*models.Subscriber // Keyed by topic
+	patterns map[string]*models.Subscriber // Keyed by topic pattern
+}
+
+// newSession creates the session for an accepted connection
+func newSession(server *Server, conn net.Conn, reader *bufio.Reader) *session {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &session{
+		server:        server,
+		id:            "redis-" + uuid.New().String(),
+		conn:          conn,
+		reader:        reader,
+		authenticated: server.authenticator == nil,
+		ctx:           ctx,
+		cancel:        cancel,
+		channels:      make(map[string]*models.Subscriber),
+		patterns:      make(map[string]*models.Subscriber),
+	}
+}
+
+// run serves commands until the connection ends or the client quits, then
+// removes its subscriptions
+func (s *session) run() {
+	defer func() {
+		s.cancel()
+		s.unsubscribeAll(s.channelNames(), false)
+		s.unsubscribeAll(s.patternNames(), true)
+	}()
+
+	for {
+		args, err := readCommand(s.reader)
+		if err != nil {
+			var protoErr *protocolError
+			if errors.As(err, &protoErr) {
+				s.write(appendError(nil, "ERR "+protoErr.Error()))
+			} else if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				log.Printf("Redis client read error: %v", err)
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		if !s.handleCommand(strings.ToUpper(string(args[0])), args[1:]) {
+			return
+		}
+	}
+}
+
+// handleCommand executes one command and returns false once the connection
+// should close. A connection with subscriptions only accepts the commands
+// that manage them, PING and QUIT, as in RESP2.
+func (s *session) handleCommand(name string, args [][]byte) bool {
+	switch name {
+	case "QUIT":
+		s.write(appendSimple(nil, "OK"))
+		return false
+	case "AUTH":
+		s.handleAuth(args)
+		return true
+	}
+
+	if !s.authenticated {
+		s.write(appendError(nil, "NOAUTH Authentication required."))
+		return true
+	}
+
+	if s.subscribed() {
+		switch name {
+		case "SUBSCRIBE", "PSUBSCRIBE", "UNSUBSCRIBE", "PUNSUBSCRIBE", "PING":
+		default:
+			s.write(appendError(nil, "ERR Can't execute '"+strings.ToLower(name)+"': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT are allowed in this context"))
+			return true
+		}
+	}
+
+	switch name {
+	case "PING":
+		s.handlePing(args)
+	case "PUBLISH":
+		s.handlePublish(args)
+	case "SUBSCRIBE", "PSUBSCRIBE":
+		if len(args) == 0 {
+			s.write(wrongArgs(name))
+			return true
+		}
+		for _, arg := range args {
+			s.subscribe(string(arg), name == "PSUBSCRIBE")
+		}
+	case "UNSUBSCRIBE":
+		s.unsubscribe(args, false)
+	case "PUNSUBSCRIBE":
+		s.unsubscribe(args, true)
+	default:
+		s.write(appendError(nil, "ERR unknown command '"+strings.ToLower(name)+"'"))
+	}
+	return true
+}
+
+// handleAuth authenticates the connection with "AUTH password" or
+// "AUTH username password". The password is an API key or JWT and the
+// username is ignored. Without authentication configured AUTH always
+// succeeds, so clients configured with a password still connect.
+func (s *session) handleAuth(args [][]byte) {
+	if len(args) != 1 && len(args) != 2 {
+		s.write(wrongArgs("AUTH"))
+		return
+	}
+	if s.server.authenticator == nil {
+		s.write(appendSimple(nil, "OK"))
+		return
+	}
+
+	principal, err := s.server.authenticate(string(args[len(args)-1]))
+	if err != nil {
+		s.write(appendError(nil, "WRONGPASS invalid username-password pair or user is disabled."))
+		return
+	}
+	s.principal = principal
+	s.authenticated = true
+	s.write(appendSimple(nil, "OK"))
+}
+
+// handlePing replies PONG, or with the argument; with subscriptions the
+// reply is a "pong" push like a message
+func (s *session) handlePing(args [][]byte) {
+	if len(args) > 1 {
+		s.write(wrongArgs("PING"))
+		return
+	}
+
+	if s.subscribed() {
+		reply := appendBulk(appendArray(nil, 2), []byte("pong"))
+		if len(args) == 1 {
+			reply = appendBulk(reply, args[0])
+		} else {
+			reply = appendBulk(reply, nil)
+		}
+		s.write(reply)
+		return
+	}
+
+	if len(args) == 1 {
+		s.write(appendBulk(nil, args[0]))
+		return
+	}
+	s.write(appendSimple(nil, "PONG"))
+}
+
+// handlePublish publishes a message to the topic named by the channel and
+// replies with the number of subscribers it was delivered to, counting
+// pattern subscribers and each consumer group once
+func (s *session) handlePublish(args [][]byte) {
+	if len(args) != 2 {
+		s.write(wrongArgs("PUBLISH"))
+		return
+	}
+	channel := string(args[0])
+
+	msg := &models.Message{Payload: listener.PayloadValue(args[1])}
+
+	var err error
+	if nameErr := pubsub.ValidateTopicName(channel); nameErr != nil {
+		err = &models.Error{Code: "BAD_REQUEST", Message: "Invalid channel: " + nameErr.Error()}
+	} else if !s.server.authorizer.Allowed(s.principal, auth.ActionPublish, channel) {
+		err = forbidden(auth.ActionPublish, channel)
+	}
+	if err == nil {
+		err = s.server.ensureTopic(s, channel)
+	}
+	if err == nil {
+		if err = pubsub.PrepareMessage(msg); err == nil {
+			err = s.server.pubSubSystem.Publish(channel, msg)
+		}
+	}
+	if err != nil {
+		s.write(errorReply(err))
+		return
+	}
+
+	s.write(appendInt(nil, int64(s.server.pubSubSystem.Receivers(channel, msg))))
+}
+
+// subscribe subscribes to a channel, the topic of the same name, or with
+// PSUBSCRIBE to a topic pattern using the topic wildcards "*" and ">".
+// Subscribing again to the same channel or pattern only confirms it.
+func (s *session) subscribe(name string, pattern bool) {
+	kind := "subscribe"
+	if pattern {
+		kind = "psubscribe"
+	}
+
+	s.mu.Lock()
+	_, exists := s.channels[name]
+	if pattern {
+		_, exists = s.patterns[name]
+	}
+	s.mu.Unlock()
+	if exists {
+		s.write(s.subscriptionReply(kind, name))
+		return
+	}
+
+	sub, err := s.addSubscriber(name, pattern)
+	if err != nil {
+		s.write(errorReply(err))
+		return
+	}
+
+	s.mu.Lock()
+	if pattern {
+		s.patterns[name] = sub
+	} else {
+		s.channels[name] = sub
+	}
+	s.mu.Unlock()
+
+	// Confirm before the processor can push the first message
+	s.write(s.subscriptionReply(kind, name))
+	s.server.subManager.StartMessageProcessor(sub, s.ctx)
+}
+
+// addSubscriber attaches a subscriber for a channel or pattern
+func (s *session) addSubscriber(name string, pattern bool) (*models.Subscriber, error) {
+	if pattern && !pubsub.IsPattern(name) {
+		return nil, &models.Error{Code: "BAD_REQUEST", Message: "Pattern must use the topic wildcards '*' or '>'; use SUBSCRIBE for " + name}
+	}
+	if !pattern && pubsub.IsPattern(name) {
+		return nil, &models.Error{Code: "BAD_REQUEST", Message: "Use PSUBSCRIBE for pattern " + name}
+	}
+	if !s.server.authorizer.Allowed(s.principal, auth.ActionSubscribe, name) {
+		return nil, forbidden(auth.ActionSubscribe, name)
+	}
+
+	conn := &subscriberConn{session: s}
+	if pattern {
+		if err := pubsub.ValidatePattern(name); err != nil {
+			return nil, &models.Error{Code: "BAD_REQUEST", Message: "Invalid topic pattern: " + err.Error()}
+		}
+		sub := s.server.subManager.NewSubscriber(pubsub.SubscriberKey(s.id, name), name, conn)
+		conn.pattern = name
+		if err := s.server.pubSubSystem.AddWildcardSubscriber(sub); err != nil {
+			return nil, err
+		}
+		return sub, nil
+	}
+
+	if err := pubsub.ValidateTopicName(name); err != nil {
+		return nil, &models.Error{Code: "BAD_REQUEST", Message: "Invalid channel: " + err.Error()}
+	}
+	if err := s.server.ensureTopic(s, name); err != nil {
+		return nil, err
+	}
+	topic, exists := s.server.pubSubSystem.GetTopic(name)
+	if !exists {
+		return nil, &models.Error{Code: "TOPIC_NOT_FOUND", Message: "Topic does not exist"}
+	}
+	sub := s.server.subManager.NewSubscriber(s.id, name, conn)
+	if err := s.server.topicManager.AddSubscriber(topic, sub); err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+// unsubscribe removes the named channels or patterns, or all of them when
+// none are named, confirming each with the remaining subscription count
+func (s *session) unsubscribe(args [][]byte, pattern bool) {
+	names := make([]string, 0, len(args))
+	for _, arg := range args {
+		names = append(names, string(arg))
+	}
+	if len(names) == 0 {
+		if pattern {
+			names = s.patternNames()
+		} else {
+			names = s.channelNames()
+		}
+	}
+
+	kind := "unsubscribe"
+	if pattern {
+		kind = "punsubscribe"
+	}
+	if len(names) == 0 {
+		reply := appendNull(appendBulk(appendArray(nil, 3), []byte(kind)))
+		s.write(appendInt(reply, int64(s.count())))
+		return
+	}
+
+	for _, name := range names {
+		s.remove(name, pattern)
+		s.write(s.subscriptionReply(kind, name))
+	}
+}
+
+// unsubscribeAll removes subscriptions without replying, when the
+// connection ends
+func (s *session) unsubscribeAll(names []string, pattern bool) {
+	for _, name := range names {
+		s.remove(name, pattern)
+	}
+}
+
+// remove detaches the subscriber of a channel or pattern, if any
+func (s *session) remove(name string, pattern bool) {
+	s.mu.Lock()
+	subs := s.channels
+	if pattern {
+		subs = s.patterns
+	}
+	sub, exists := subs[name]
+	delete(subs, name)
+	s.mu.Unlock()
+	if !exists {
+		return
+	}
+
+	if pattern {
+		s.server.pubSubSystem.RemoveWildcardSubscribersByConn(sub.Conn)
+	} else if topic, found := s.server.pubSubSystem.GetTopic(name); found {
+		s.server.topicManager.RemoveSubscribersByConn(topic, sub.Conn)
+	}
+}
+
+// subscriptionReply is the push confirming a (p)subscribe or (p)unsubscribe
+func (s *session) subscriptionReply(kind, name string) []byte {
+	reply := appendBulk(appendBulk(appendArray(nil, 3), []byte(kind)), []byte(name))
+	return appendInt(reply, int64(s.count()))
+}
+
+// subscribed reports whether the connection has any subscription
+func (s *session) subscribed() bool {
+	return s.count() > 0
+}
+
+// count returns the number of channels and patterns subscribed to
+func (s *session) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.channels) + len(s.patterns)
+}
+
+// channelNames returns the subscribed channels in order
+func (s *session) channelNames() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return sortedKeys(s.channels)
+}
+
+// patternNames returns the subscribed patterns in order
+func (s *session) patternNames() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return sortedKeys(s.patterns)
+}
+
+// write sends a reply; writes from subscriber processors and the command
+// loop are serialized
+func (s *session) write(reply []byte) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	_, err := s.conn.Write(reply)
+	return err
+}
+
+// subscriberConn adapts one subscription to models.WebSocketConn, pushing
+// its events as message or pmessage replies
+type subscriberConn struct {
+	session *session
+	pattern string // Empty for channel subscriptions
+}
+
+// WriteJSON pushes events. Error frames, such as SLOW_CONSUMER, are
+// followed by Close and info frames have no RESP equivalent, so both are
+// skipped.
+func (c *subscriberConn) WriteJSON(v interface{}) error {
+	msg, ok := v.(*models.ServerMessage)
+	if !ok || msg.Type != "event" || msg.Message == nil {
+		return nil
+	}
+
+	payload, err := listener.PayloadBytes(msg.Message.Payload)
+	if err != nil {
+		return err
+	}
+
+	var reply []byte
+	if c.pattern != "" {
+		reply = appendBulk(appendBulk(appendArray(nil, 4), []byte("pmessage")), []byte(c.pattern))
+	} else {
+		reply = appendBulk(appendArray(nil, 3), []byte("message"))
+	}
+	reply = appendBulk(appendBulk(reply, []byte(msg.Topic)), payload)
+	return c.session.write(reply)
+}
+
+// Close disconnects the client
+func (c *subscriberConn) Close() error {
+	return c.session.conn.Close()
+}
+
+// errorReply encodes an error as an error reply, using the error code of a
+// *models.Error as the Redis error prefix
+func errorReply(err error) []byte {
+	e, ok := err.(*models.Error)
+	if !ok {
+		return appendError(nil, "ERR "+err.Error())
+	}
+	metrics.Errors.WithLabelValues(e.Code).Inc()
+	return appendError(nil, e.Code+" "+e.Message)
+}
+
+// forbidden is the error for an action the policy does not allow
+func forbidden(action, topic string) error {
+	return &models.Error{Code: "FORBIDDEN", Message: action + " not allowed on topic " + topic}
+}
+
+// wrongArgs is the reply to a command with the wrong number of arguments
+func wrongArgs(name string) []byte {
+	return appendError(nil, "ERR wrong number of arguments for '"+strings.ToLower(name)+"' command")
+}
+
+// sortedKeys returns the keys of a subscription map in order
+func sortedKeys(subs map[string]*models.Subscriber) []string {
+	names := make([]string, 0, len(subs))
+	for name := range subs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,176 @@
+package schema
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Compatibility modes checked when a new version is registered
+const (
+	CompatibilityNone     = "none"     // Any change is allowed
+	CompatibilityBackward = "backward" // Consumers using the new version can read data written with the previous one
+	CompatibilityForward  = "forward"  // Consumers using the previous version can read data written with the new one
+	CompatibilityFull     = "full"     // Both backward and forward
+)
+
+// ValidCompatibility reports whether mode is a known compatibility mode
+func ValidCompatibility(mode string) bool {
+	switch mode {
+	case CompatibilityNone, CompatibilityBackward, CompatibilityForward, CompatibilityFull:
+		return true
+	}
+	return false
+}
+
+// CheckCompatibility returns the problems that prevent next from replacing
+// previous under a compatibility mode. The check is conservative: a change
+// it cannot prove safe is reported.
+func CheckCompatibility(mode string, previous, next *Schema) []string {
+	problems := make([]string, 0)
+	if mode == CompatibilityBackward || mode == CompatibilityFull {
+		problems = append(problems, accepts(next, previous, "$")...)
+	}
+	if mode == CompatibilityForward || mode == CompatibilityFull {
+		problems = append(problems, accepts(previous, next, "$")...)
+	}
+	return problems
+}
+
+// accepts returns why reader may reject a value that writer allows; none
+// means every value valid under writer is valid under reader
+func accepts(reader, writer *Schema, path string) []string {
+	problems := make([]string, 0)
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, path+": "+fmt.Sprintf(format, args...))
+	}
+
+	if len(reader.Type) > 0 {
+		if len(writer.Type) == 0 {
+			add("type narrowed to %v from any type", []string(reader.Type))
+		}
+		for _, name := range writer.Type {
+			if !reader.Type.acceptsType(name) {
+				add("type %s is no longer accepted", name)
+			}
+		}
+	}
+
+	if len(reader.Enum) > 0 {
+		if len(writer.Enum) == 0 {
+			add("enum added")
+		}
+		for _, value := range writer.Enum {
+			if !containsValue(reader.Enum, value) {
+				add("enum value %v removed", value)
+			}
+		}
+	}
+
+	lowerBound(add, "minimum", reader.Minimum, writer.Minimum)
+	upperBound(add, "maximum", reader.Maximum, writer.Maximum)
+	lowerBound(add, "exclusiveMinimum", reader.ExclusiveMinimum, writer.ExclusiveMinimum)
+	upperBound(add, "exclusiveMaximum", reader.ExclusiveMaximum, writer.ExclusiveMaximum)
+	lowerBound(add, "minLength", intBound(reader.MinLength), intBound(writer.MinLength))
+	upperBound(add, "maxLength", intBound(reader.MaxLength), intBound(writer.MaxLength))
+	lowerBound(add, "minItems", intBound(reader.MinItems), intBound(writer.MinItems))
+	upperBound(add, "maxItems", intBound(reader.MaxItems), intBound(writer.MaxItems))
+
+	if reader.Pattern != "" && reader.Pattern != writer.Pattern {
+		add("pattern changed to %q", reader.Pattern)
+	}
+
+	if reader.Items != nil {
+		if writer.Items == nil {
+			problems = append(problems, accepts(reader.Items, &Schema{}, path+"[]")...)
+		} else {
+			problems = append(problems, accepts(reader.Items, writer.Items, path+"[]")...)
+		}
+	}
+
+	problems = append(problems, acceptsObject(reader, writer, path)...)
+	return problems
+}
+
+// acceptsObject compares the object keywords of two schemas
+func acceptsObject(reader, writer *Schema, path string) []string {
+	problems := make([]string, 0)
+
+	for _, name := range reader.Required {
+		if !contains(writer.Required, name) {
+			problems = append(problems, fmt.Sprintf("%s: property is now required", childPath(path, name)))
+		}
+	}
+
+	writerClosed := writer.AdditionalProperties != nil && !*writer.AdditionalProperties
+	readerClosed := reader.AdditionalProperties != nil && !*reader.AdditionalProperties
+	if readerClosed && !writerClosed {
+		problems = append(problems, fmt.Sprintf("%s: additional properties are no longer allowed", path))
+	}
+
+	names := make([]string, 0, len(reader.Properties)+len(writer.Properties))
+	for name := range reader.Properties {
+		names = append(names, name)
+	}
+	for name := range writer.Properties {
+		if _, seen := reader.Properties[name]; !seen {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		readerProp, inReader := reader.Properties[name]
+		writerProp, inWriter := writer.Properties[name]
+		switch {
+		case inReader && inWriter:
+			problems = append(problems, accepts(readerProp, writerProp, childPath(path, name))...)
+		case inReader && !writerClosed:
+			// The writer may send any value under an undeclared name
+			problems = append(problems, accepts(readerProp, &Schema{}, childPath(path, name))...)
+		case inWriter && readerClosed:
+			problems = append(problems, fmt.Sprintf("%s: property is no longer allowed", childPath(path, name)))
+		}
+	}
+	return problems
+}
+
+// acceptsType reports whether the list accepts values of type name
+func (t typeList) acceptsType(name string) bool {
+	for _, candidate := range t {
+		if candidate == name || (candidate == "number" && name == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// lowerBound reports a reader minimum that rejects values the writer allows
+func lowerBound(add func(string, ...interface{}), keyword string, reader, writer *float64) {
+	if reader != nil && (writer == nil || *writer < *reader) {
+		add("%s raised to %v", keyword, *reader)
+	}
+}
+
+// upperBound reports a reader maximum that rejects values the writer allows
+func upperBound(add func(string, ...interface{}), keyword string, reader, writer *float64) {
+	if reader != nil && (writer == nil || *writer > *reader) {
+		add("%s lowered to %v", keyword, *reader)
+	}
+}
+
+func intBound(n *int) *float64 {
+	if n == nil {
+		return nil
+	}
+	f := float64(*n)
+	return &f
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,127 @@
+package schema
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckCompatibility(t *testing.T) {
+	tests := []struct {
+		name     string
+		previous string
+		next     string
+		backward []string // Expected problems, each matched as a substring
+		forward  []string
+	}{
+		{
+			name:     "identical",
+			previous: `{"type": "object", "properties": {"id": {"type": "string"}}, "required": ["id"]}`,
+			next:     `{"type": "object", "properties": {"id": {"type": "string"}}, "required": ["id"]}`,
+		},
+		{
+			name:     "required property added",
+			previous: `{"type": "object", "properties": {"id": {"type": "string"}}}`,
+			next:     `{"type": "object", "properties": {"id": {"type": "string"}}, "required": ["id"]}`,
+			backward: []string{"$.id: property is now required"},
+		},
+		{
+			name:     "unconstrained optional property added",
+			previous: `{"type": "object"}`,
+			next:     `{"type": "object", "properties": {"note": {}}}`,
+		},
+		{
+			name:     "constrained optional property added to an open object",
+			previous: `{"type": "object"}`,
+			next:     `{"type": "object", "properties": {"note": {"type": "string"}}}`,
+			backward: []string{"$.note: type narrowed"},
+		},
+		{
+			name:     "property added to a closed object",
+			previous: `{"type": "object", "additionalProperties": false}`,
+			next:     `{"type": "object", "properties": {"note": {"type": "string"}}, "additionalProperties": false}`,
+			forward:  []string{"$.note: property is no longer allowed"},
+		},
+		{
+			name:     "object closed",
+			previous: `{"type": "object"}`,
+			next:     `{"type": "object", "additionalProperties": false}`,
+			backward: []string{"$: additional properties are no longer allowed"},
+		},
+		{
+			name:     "type widened",
+			previous: `{"type": "integer"}`,
+			next:     `{"type": "number"}`,
+			forward:  []string{"$: type number is no longer accepted"},
+		},
+		{
+			name:     "type narrowed",
+			previous: `{"type": ["string", "null"]}`,
+			next:     `{"type": "string"}`,
+			backward: []string{"$: type null is no longer accepted"},
+		},
+		{
+			name:     "enum value removed",
+			previous: `{"enum": ["a", "b"]}`,
+			next:     `{"enum": ["a"]}`,
+			backward: []string{"$: enum value b removed"},
+		},
+		{
+			name:     "minimum raised",
+			previous: `{"type": "number", "minimum": 0}`,
+			next:     `{"type": "number", "minimum": 5}`,
+			backward: []string{"$: minimum raised to 5"},
+		},
+		{
+			name:     "maxLength lowered in array items",
+			previous: `{"type": "array", "items": {"type": "string", "maxLength": 10}}`,
+			next:     `{"type": "array", "items": {"type": "string", "maxLength": 5}}`,
+			backward: []string{"$[]: maxLength lowered to 5"},
+		},
+		{
+			name:     "pattern changed",
+			previous: `{"type": "string", "pattern": "^a"}`,
+			next:     `{"type": "string", "pattern": "^b"}`,
+			backward: []string{"$: pattern changed to \"^b\""},
+			forward:  []string{"$: pattern changed to \"^a\""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previous, next := mustParse(t, tt.previous), mustParse(t, tt.next)
+			full := append(append([]string{}, tt.backward...), tt.forward...)
+
+			checkProblems(t, CompatibilityBackward, CheckCompatibility(CompatibilityBackward, previous, next), tt.backward)
+			checkProblems(t, CompatibilityForward, CheckCompatibility(CompatibilityForward, previous, next), tt.forward)
+			checkProblems(t, CompatibilityFull, CheckCompatibility(CompatibilityFull, previous, next), full)
+			checkProblems(t, CompatibilityNone, CheckCompatibility(CompatibilityNone, previous, next), nil)
+		})
+	}
+}
+
+// checkProblems compares problems with the expected ones, in order
+func checkProblems(t *testing.T, mode string, got, want []string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("%s: problems = %q, want %q", mode, got, want)
+		return
+	}
+	for i := range want {
+		if !strings.Contains(got[i], want[i]) {
+			t.Errorf("%s: problem %d = %q, want it to contain %q", mode, i, got[i], want[i])
+		}
+	}
+}
+
+func TestValidCompatibility(t *testing.T) {
+	for _, mode := range []string{"none", "backward", "forward", "full"} {
+		if !ValidCompatibility(mode) {
+			t.Errorf("ValidCompatibility(%q) = false", mode)
+		}
+	}
+	for _, mode := range []string{"", "BACKWARD", "transitive"} {
+		if ValidCompatibility(mode) {
+			t.Errorf("ValidCompatibility(%q) = true", mode)
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Version is one registered version of a subject's schema
+type Version struct {
+	Version   int             `json:"version"`
+	Schema    json.RawMessage `json:"schema"`
+	CreatedAt time.Time       `json:"created_at"`
+
+	parsed *Schema
+}
+
+// Subject is a named sequence of schema versions
+type Subject struct {
+	Name          string     `json:"name"`
+	Compatibility string     `json:"compatibility"`
+	Versions      []*Version `json:"versions"`
+}
+
+// latest returns the newest version
+func (s *Subject) latest() *Version {
+	return s.Versions[len(s.Versions)-1]
+}
+
+// IncompatibleError is returned when a new version breaks the subject's
+// compatibility mode
+type IncompatibleError struct {
+	Compatibility string
+	Problems      []string
+}
+
+func (e *IncompatibleError) Error() string {
+	return fmt.Sprintf("schema is not %s compatible with the latest version: %s", e.Compatibility, strings.Join(e.Problems, "; "))
+}
+
+// subjectName restricts subject names to characters that are safe in URLs
+// and file names
+var subjectName = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// Registry stores subjects in memory and, when opened with a path, in a JSON
+// file that is rewritten on every change
+type Registry struct {
+	mu            sync.RWMutex
+	path          string
+	compatibility string // Default for new subjects
+	subjects      map[string]*Subject
+}
+
+// registryFile is the on-disk form of a registry
+type registryFile struct {
+	Subjects []*Subject `json:"subjects"`
+}
+
+// Open creates a registry, loading it from path if the file exists. An empty
+// path keeps the registry in memory only.
+func Open(path, defaultCompatibility string) (*Registry, error) {
+	if !ValidCompatibility(defaultCompatibility) {
+		return nil, fmt.Errorf("unknown compatibility mode %q", defaultCompatibility)
+	}
+	r := &Registry{
+		path:          path,
+		compatibility: defaultCompatibility,
+		subjects:      make(map[string]*Subject),
+	}
+	if path == "" {
+		return r, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var file registryFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, subject := range file.Subjects {
+		for _, version := range subject.Versions {
+			if version.parsed, err = Parse(version.Schema); err != nil {
+				return nil, fmt.Errorf("%s: subject %s version %d: %w", path, subject.Name, version.Version, err)
+			}
+		}
+		if len(subject.Versions) > 0 {
+			r.subjects[subject.Name] = subject
+		}
+	}
+	return r, nil
+}
+
+// Register adds a schema as the next version of a subject, creating the
+// subject if needed. Registering the latest schema again returns the
+// existing version. Invalid schemas return an error and incompatible ones
+// an *IncompatibleError.
+func (r *Registry) Register(subject string, raw json.RawMessage) (*Version, bool, error) {
+	if !subjectName.MatchString(subject) {
+		return nil, false, fmt.Errorf("subject must consist of letters, digits, '.', '_' and '-'")
+	}
+	parsed, err := Parse(raw)
+	if err != nil {
+		return nil, false, err
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, raw); err != nil {
+		return nil, false, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, exists := r.subjects[subject]
+	if exists {
+		latest := s.latest()
+		if bytes.Equal(latest.Schema, compact.Bytes()) {
+			return latest, false, nil
+		}
+		if problems := CheckCompatibility(s.Compatibility, latest.parsed, parsed); len(problems) > 0 {
+			return nil, false, &IncompatibleError{Compatibility: s.Compatibility, Problems: problems}
+		}
+	} else {
+		s = &Subject{Name: subject, Compatibility: r.compatibility}
+	}
+
+	version := &Version{
+		Version:   1,
+		Schema:    compact.Bytes(),
+		CreatedAt: time.Now().UTC(),
+		parsed:    parsed,
+	}
+	if len(s.Versions) > 0 {
+		version.Version = s.latest().Version + 1
+	}
+	s.Versions = append(s.Versions, version)
+	r.subjects[subject] = s
+
+	if err := r.save(); err != nil {
+		s.Versions = s.Versions[:len(s.Versions)-1]
+		if len(s.Versions) == 0 {
+			delete(r.subjects, subject)
+		}
+		return nil, false, err
+	}
+	return version, true, nil
+}
+
+// Subjects lists every subject with its compatibility mode and latest
+// version number
+func (r *Registry) Subjects() []map[string]interface{} {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	result := make([]map[string]interface{}, 0, len(r.subjects))
+	for _, s := range r.subjects {
+		result = append(result, map[string]interface{}{
+			"name":           s.Name,
+			"compatibility":  s.Compatibility,
+			"latest_version": s.latest().Version,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i]["name"].(string) < result[j]["name"].(string) })
+	return result
+}
+
+// Subject returns a copy of a subject and its versions
+func (r *Registry) Subject(name string) (*Subject, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	s, exists := r.subjects[name]
+	if !exists {
+		return nil, false
+	}
+	copied := *s
+	copied.Versions = append([]*Version(nil), s.Versions...)
+	return &copied, true
+}
+
+// Version returns a version of a subject; version 0 means the latest
+func (r *Registry) Version(subject string, version int) (*Version, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	s, exists := r.subjects[subject]
+	if !exists {
+		return nil, false
+	}
+	if version == 0 {
+		return s.latest(), true
+	}
+	for _, v := range s.Versions {
+		if v.Version == version {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// SetCompatibility changes the mode checked for a subject's future versions
+func (r *Registry) SetCompatibility(subject, mode string) error {
+	if !ValidCompatibility(mode) {
+		return fmt.Errorf("unknown compatibility mode %q", mode)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, exists := r.subjects[subject]
+	if !exists {
+		return fmt.Errorf("subject not found")
+	}
+	previous := s.Compatibility
+	s.Compatibility = mode
+	if err := r.save(); err != nil {
+		s.Compatibility = previous
+		return err
+	}
+	return nil
+}
+
+// Delete removes a subject and all its versions
+func (r *Registry) Delete(subject string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, exists := r.subjects[subject]
+	if !exists {
+		return fmt.Errorf("subject not found")
+	}
+	delete(r.subjects, subject)
+	if err := r.save(); err != nil {
+		r.subjects[subject] = s
+		return err
+	}
+	return nil
+}
+
+// Validate checks a payload against a version of a subject; version 0 means
+// the latest. It returns a *Violation when the payload does not match.
+func (r *Registry) Validate(subject string, version int, payload interface{}) (int, error) {
+	v, exists := r.Version(subject, version)
+	if !exists {
+		return 0, fmt.Errorf("%s is not registered", Describe(subject, version))
+	}
+	if violation := v.parsed.Validate(payload); violation != nil {
+		return v.Version, violation
+	}
+	return v.Version, nil
+}
+
+// Describe names a subject version for messages; version 0 means the latest
+func Describe(subject string, version int) string {
+	if version == 0 {
+		return "schema " + subject
+	}
+	return fmt.Sprintf("schema %s version %d", subject, version)
+}
+
+// save atomically rewrites the registry file. Callers must hold r.mu.
+func (r *Registry) save() error {
+	if r.path == "" {
+		return nil
+	}
+
+	file := registryFile{Subjects: make([]*Subject, 0, len(r.subjects))}
+	for _, s := range r.subjects {
+		file.Subjects = append(file.Subjects, s)
+	}
+	sort.Slice(file.Subjects, func(i, j int) bool { return file.Subjects[i].Name < file.Subjects[j].Name })
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := r.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to save schema registry: %w", err)
+	}
+	if err := os.Rename(tmp, r.path); err != nil {
+		return fmt.Errorf("failed to save schema registry: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,312 @@
+// Package schema implements the schema registry: versioned JSON Schemas
+// that topics bind to so published payloads can be validated.
+//
+// The supported JSON Schema subset is type, properties, required,
+// additionalProperties (boolean), items, enum, minimum, maximum,
+// exclusiveMinimum, exclusiveMaximum (numbers), minLength, maxLength,
+// pattern, minItems and maxItems, plus the annotations $schema, $id, $comment,
+// title, description, default and examples. Schemas using other keywords are
+// rejected rather than silently under-validated.
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Schema is a parsed JSON Schema
+type Schema struct {
+	Type                 typeList           `json:"type,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	ExclusiveMinimum     *float64           `json:"exclusiveMinimum,omitempty"`
+	ExclusiveMaximum     *float64           `json:"exclusiveMaximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+
+	// Annotations, accepted and ignored
+	SchemaURI   string          `json:"$schema,omitempty"`
+	ID          string          `json:"$id,omitempty"`
+	Comment     string          `json:"$comment,omitempty"`
+	Title       string          `json:"title,omitempty"`
+	Description string          `json:"description,omitempty"`
+	Default     json.RawMessage `json:"default,omitempty"`
+	Examples    json.RawMessage `json:"examples,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// typeList is the "type" keyword, a single type name or a list of them
+type typeList []string
+
+func (t *typeList) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*t = typeList{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("type must be a string or an array of strings")
+	}
+	*t = list
+	return nil
+}
+
+func (t typeList) MarshalJSON() ([]byte, error) {
+	if len(t) == 1 {
+		return json.Marshal(t[0])
+	}
+	return json.Marshal([]string(t))
+}
+
+// validTypes are the JSON Schema type names
+var validTypes = map[string]bool{
+	"null": true, "boolean": true, "object": true, "array": true,
+	"number": true, "integer": true, "string": true,
+}
+
+// Parse decodes and checks a JSON Schema document
+func Parse(raw []byte) (*Schema, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+
+	var s Schema
+	if err := dec.Decode(&s); err != nil {
+		if field, found := strings.CutPrefix(err.Error(), "json: unknown field "); found {
+			return nil, fmt.Errorf("unsupported keyword %s", field)
+		}
+		return nil, err
+	}
+	if err := s.compile("$"); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// compile checks keyword values and prepares patterns
+func (s *Schema) compile(path string) error {
+	for _, name := range s.Type {
+		if !validTypes[name] {
+			return fmt.Errorf("%s: unknown type %q", path, name)
+		}
+	}
+	for _, n := range []*int{s.MinLength, s.MaxLength, s.MinItems, s.MaxItems} {
+		if n != nil && *n < 0 {
+			return fmt.Errorf("%s: length limits must not be negative", path)
+		}
+	}
+	if s.Pattern != "" {
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("%s: invalid pattern: %v", path, err)
+		}
+		s.pattern = re
+	}
+	for name, prop := range s.Properties {
+		if prop == nil {
+			return fmt.Errorf("%s: property %q has no schema", path, name)
+		}
+		if err := prop.compile(childPath(path, name)); err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		if err := s.Items.compile(path + "[]"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Violation describes why a value does not match a schema
+type Violation struct {
+	Path    string // Location of the failing value, such as $.items[2].price
+	Message string
+}
+
+func (v *Violation) Error() string {
+	return fmt.Sprintf("at %s: %s", v.Path, v.Message)
+}
+
+// Validate checks a decoded JSON value against the schema and returns the
+// first violation found, or nil
+func (s *Schema) Validate(value interface{}) *Violation {
+	return s.validate(normalize(value), "$")
+}
+
+func (s *Schema) validate(value interface{}, path string) *Violation {
+	if len(s.Type) > 0 && !s.Type.accepts(value) {
+		return &Violation{Path: path, Message: fmt.Sprintf("expected %s, got %s", strings.Join(s.Type, " or "), typeOf(value))}
+	}
+	if len(s.Enum) > 0 && !containsValue(s.Enum, value) {
+		return &Violation{Path: path, Message: "value is not one of the allowed values"}
+	}
+
+	switch v := value.(type) {
+	case float64:
+		return s.validateNumber(v, path)
+	case string:
+		return s.validateString(v, path)
+	case []interface{}:
+		if s.MinItems != nil && len(v) < *s.MinItems {
+			return &Violation{Path: path, Message: fmt.Sprintf("expected at least %d items", *s.MinItems)}
+		}
+		if s.MaxItems != nil && len(v) > *s.MaxItems {
+			return &Violation{Path: path, Message: fmt.Sprintf("expected at most %d items", *s.MaxItems)}
+		}
+		if s.Items != nil {
+			for i, item := range v {
+				if violation := s.Items.validate(item, fmt.Sprintf("%s[%d]", path, i)); violation != nil {
+					return violation
+				}
+			}
+		}
+	case map[string]interface{}:
+		return s.validateObject(v, path)
+	}
+	return nil
+}
+
+func (s *Schema) validateNumber(v float64, path string) *Violation {
+	switch {
+	case s.Minimum != nil && v < *s.Minimum:
+		return &Violation{Path: path, Message: fmt.Sprintf("must be at least %v", *s.Minimum)}
+	case s.Maximum != nil && v > *s.Maximum:
+		return &Violation{Path: path, Message: fmt.Sprintf("must be at most %v", *s.Maximum)}
+	case s.ExclusiveMinimum != nil && v <= *s.ExclusiveMinimum:
+		return &Violation{Path: path, Message: fmt.Sprintf("must be greater than %v", *s.ExclusiveMinimum)}
+	case s.ExclusiveMaximum != nil && v >= *s.ExclusiveMaximum:
+		return &Violation{Path: path, Message: fmt.Sprintf("must be less than %v", *s.ExclusiveMaximum)}
+	}
+	return nil
+}
+
+func (s *Schema) validateString(v string, path string) *Violation {
+	length := utf8.RuneCountInString(v)
+	switch {
+	case s.MinLength != nil && length < *s.MinLength:
+		return &Violation{Path: path, Message: fmt.Sprintf("must be at least %d characters", *s.MinLength)}
+	case s.MaxLength != nil && length > *s.MaxLength:
+		return &Violation{Path: path, Message: fmt.Sprintf("must be at most %d characters", *s.MaxLength)}
+	case s.pattern != nil && !s.pattern.MatchString(v):
+		return &Violation{Path: path, Message: fmt.Sprintf("does not match pattern %q", s.Pattern)}
+	}
+	return nil
+}
+
+func (s *Schema) validateObject(v map[string]interface{}, path string) *Violation {
+	for _, name := range s.Required {
+		if _, ok := v[name]; !ok {
+			return &Violation{Path: childPath(path, name), Message: "required property is missing"}
+		}
+	}
+
+	// Check properties in a stable order so the reported path is too
+	names := make([]string, 0, len(v))
+	for name := range v {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		prop, declared := s.Properties[name]
+		if !declared {
+			if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+				return &Violation{Path: childPath(path, name), Message: "property is not allowed"}
+			}
+			continue
+		}
+		if violation := prop.validate(v[name], childPath(path, name)); violation != nil {
+			return violation
+		}
+	}
+	return nil
+}
+
+// accepts reports whether a value has one of the listed types
+func (t typeList) accepts(value interface{}) bool {
+	actual := typeOf(value)
+	for _, name := range t {
+		if name == actual || (name == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// typeOf returns the most specific JSON Schema type of a decoded value
+func typeOf(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) && !math.IsInf(v, 0) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return "unknown"
+}
+
+// normalize converts a value that was not produced by decoding JSON into
+// the generic form Validate works on
+func normalize(value interface{}) interface{} {
+	switch value.(type) {
+	case nil, bool, float64, string, []interface{}, map[string]interface{}:
+		return value
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return value
+	}
+	var generic interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return value
+	}
+	return generic
+}
+
+// containsValue reports whether a list holds a value equal to v as JSON
+func containsValue(list []interface{}, v interface{}) bool {
+	for _, candidate := range list {
+		if jsonEqual(candidate, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonEqual compares two decoded JSON values
+func jsonEqual(a, b interface{}) bool {
+	encodedA, errA := json.Marshal(a)
+	encodedB, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(encodedA, encodedB)
+}
+
+// childPath appends a property name to a path
+func childPath(path, name string) string {
+	return path + "." + name
+}
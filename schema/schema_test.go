@@ -0,0 +1,154 @@
+package schema
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// mustParse parses a schema document or fails the test
+func mustParse(t *testing.T, raw string) *Schema {
+	t.Helper()
+	s, err := Parse([]byte(raw))
+	if err != nil {
+		t.Fatalf("Parse(%s) error = %v", raw, err)
+	}
+	return s
+}
+
+// decode decodes a JSON value the way payloads are decoded
+func decode(t *testing.T, raw string) interface{} {
+	t.Helper()
+	var value interface{}
+	if err := json.Unmarshal([]byte(raw), &value); err != nil {
+		t.Fatalf("json.Unmarshal(%s) error = %v", raw, err)
+	}
+	return value
+}
+
+const orderSchema = `{
+	"type": "object",
+	"properties": {
+		"id": {"type": "string", "minLength": 3, "maxLength": 8, "pattern": "^o-"},
+		"status": {"enum": ["new", "paid"]},
+		"total": {"type": "number", "exclusiveMinimum": 0},
+		"count": {"type": "integer", "minimum": 1, "maximum": 10},
+		"note": {"type": ["string", "null"]},
+		"items": {
+			"type": "array",
+			"minItems": 1,
+			"maxItems": 3,
+			"items": {
+				"type": "object",
+				"properties": {"sku": {"type": "string"}, "price": {"type": "number", "minimum": 0}},
+				"required": ["sku", "price"],
+				"additionalProperties": false
+			}
+		}
+	},
+	"required": ["id", "items"]
+}`
+
+func TestValidate(t *testing.T) {
+	s := mustParse(t, orderSchema)
+	valid := `{"id": "o-1", "items": [{"sku": "a", "price": 1}]`
+
+	tests := []struct {
+		name    string
+		payload string
+		path    string // Empty when the payload is valid
+		message string
+	}{
+		{"valid", valid + `}`, "", ""},
+		{"valid with optional fields", valid + `, "status": "paid", "total": 0.5, "count": 10, "note": null, "extra": true}`, "", ""},
+		{"wrong root type", `[1]`, "$", "expected object, got array"},
+		{"missing required", `{"items": []}`, "$.id", "required property is missing"},
+		{"string too short", `{"id": "o-", "items": [{"sku": "a", "price": 1}]}`, "$.id", "at least 3 characters"},
+		{"string too long", `{"id": "o-123456789", "items": [{"sku": "a", "price": 1}]}`, "$.id", "at most 8 characters"},
+		{"pattern", `{"id": "x-123", "items": [{"sku": "a", "price": 1}]}`, "$.id", "does not match pattern"},
+		{"enum", valid + `, "status": "lost"}`, "$.status", "not one of the allowed values"},
+		{"exclusive minimum", valid + `, "total": 0}`, "$.total", "greater than 0"},
+		{"integer", valid + `, "count": 1.5}`, "$.count", "expected integer, got number"},
+		{"minimum", valid + `, "count": 0}`, "$.count", "at least 1"},
+		{"maximum", valid + `, "count": 11}`, "$.count", "at most 10"},
+		{"type list", valid + `, "note": 5}`, "$.note", "expected string or null, got integer"},
+		{"too few items", `{"id": "o-1", "items": []}`, "$.items", "at least 1 items"},
+		{"too many items", `{"id": "o-1", "items": [{"sku": "a", "price": 1}, {"sku": "b", "price": 1}, {"sku": "c", "price": 1}, {"sku": "d", "price": 1}]}`, "$.items", "at most 3 items"},
+		{"nested item", `{"id": "o-1", "items": [{"sku": "a", "price": 1}, {"sku": "b", "price": 2}, {"sku": "c", "price": -1}]}`, "$.items[2].price", "at least 0"},
+		{"nested required", `{"id": "o-1", "items": [{"sku": "a"}]}`, "$.items[0].price", "required property is missing"},
+		{"additional property", `{"id": "o-1", "items": [{"sku": "a", "price": 1, "color": "red"}]}`, "$.items[0].color", "not allowed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			violation := s.Validate(decode(t, tt.payload))
+			if tt.path == "" {
+				if violation != nil {
+					t.Fatalf("Validate() = %v, want valid", violation)
+				}
+				return
+			}
+			if violation == nil {
+				t.Fatalf("Validate() = valid, want a violation at %s", tt.path)
+			}
+			if violation.Path != tt.path || !strings.Contains(violation.Message, tt.message) {
+				t.Errorf("Validate() = %s: %s, want %s: ...%s...", violation.Path, violation.Message, tt.path, tt.message)
+			}
+		})
+	}
+}
+
+func TestValidateNormalizesGoValues(t *testing.T) {
+	s := mustParse(t, `{"type": "object", "properties": {"n": {"type": "integer", "maximum": 5}}}`)
+	if violation := s.Validate(map[string]int{"n": 7}); violation == nil || violation.Path != "$.n" {
+		t.Errorf("Validate() = %v, want a violation at $.n", violation)
+	}
+	if violation := s.Validate(struct {
+		N int `json:"n"`
+	}{3}); violation != nil {
+		t.Errorf("Validate() = %v, want valid", violation)
+	}
+}
+
+func TestParseRejectsInvalidSchemas(t *testing.T) {
+	tests := []struct {
+		schema string
+		err    string
+	}{
+		{`{"type": "object", "oneOf": []}`, "unsupported keyword \"oneOf\""},
+		{`{"$ref": "#/definitions/x"}`, "unsupported keyword \"$ref\""},
+		{`{"properties": {"a": {"format": "email"}}}`, "unsupported keyword \"format\""},
+		{`{"additionalProperties": {"type": "string"}}`, "additionalProperties"},
+		{`{"type": "decimal"}`, "unknown type \"decimal\""},
+		{`{"type": 5}`, "type must be a string"},
+		{`{"properties": {"a": {"items": {"type": "bogus"}}}}`, "$.a[]: unknown type"},
+		{`{"minLength": -1}`, "must not be negative"},
+		{`{"pattern": "("}`, "invalid pattern"},
+		{`{"properties": {"a": null}}`, "property \"a\" has no schema"},
+		{`not json`, "invalid character"},
+	}
+
+	for _, tt := range tests {
+		_, err := Parse([]byte(tt.schema))
+		if err == nil {
+			t.Errorf("Parse(%s) succeeded, want an error containing %q", tt.schema, tt.err)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("Parse(%s) error = %q, want it to contain %q", tt.schema, err, tt.err)
+		}
+	}
+}
+
+func TestParseAcceptsAnnotations(t *testing.T) {
+	mustParse(t, `{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"$id": "order",
+		"$comment": "c",
+		"title": "Order",
+		"description": "An order",
+		"default": {},
+		"examples": [{}],
+		"type": "object"
+	}`)
+}
@@ -0,0 +1,502 @@
+package storage
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"pub-sub-system/models"
+)
+
+// FsyncPolicy controls when appended records are flushed to stable storage
+type FsyncPolicy string
+
+const (
+	// FsyncAlways syncs the active segment after every append
+	FsyncAlways FsyncPolicy = "always"
+	// FsyncInterval syncs dirty segments periodically in the background
+	FsyncInterval FsyncPolicy = "interval"
+	// FsyncNever leaves flushing to the operating system
+	FsyncNever FsyncPolicy = "never"
+)
+
+const (
+	topicDirPrefix = "t_"
+	segmentSuffix  = ".seg"
+	metaFile       = "meta.json"
+	recordHeader   = 8 // 4 byte length + 4 byte CRC32
+)
+
+// Options configures the write-ahead log
+type Options struct {
+	Dir           string
+	Fsync         FsyncPolicy
+	FsyncInterval time.Duration
+	SegmentBytes  int64
+}
+
+// Store manages the per-topic append-only logs under a data directory
+type Store struct {
+	opts Options
+	mu   sync.Mutex
+	logs map[string]*TopicLog
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Open opens (or creates) a store rooted at opts.Dir
+func Open(opts Options) (*Store, error) {
+	switch opts.Fsync {
+	case FsyncAlways, FsyncInterval, FsyncNever:
+	case "":
+		opts.Fsync = FsyncInterval
+	default:
+		return nil, fmt.Errorf("unknown fsync policy %q", opts.Fsync)
+	}
+	if opts.FsyncInterval <= 0 {
+		opts.FsyncInterval = time.Second
+	}
+	if opts.SegmentBytes <= 0 {
+		opts.SegmentBytes = 8 << 20
+	}
+
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	s := &Store{
+		opts: opts,
+		logs: make(map[string]*TopicLog),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+
+	if opts.Fsync == FsyncInterval {
+		go s.syncLoop()
+	} else {
+		close(s.done)
+	}
+	return s, nil
+}
+
+// Topics returns the names of all topics that have a log on disk
+func (s *Store) Topics() ([]string, error) {
+	entries, err := os.ReadDir(s.opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), topicDirPrefix) {
+			continue
+		}
+		name, err := url.QueryUnescape(strings.TrimPrefix(entry.Name(), topicDirPrefix))
+		if err != nil {
+			log.Printf("Skipping unrecognised log directory %s: %v", entry.Name(), err)
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// OpenTopic opens the log for a topic, creating it if needed. Any torn
+// record at the tail of the last segment is truncated away.
+func (s *Store) OpenTopic(name string) (*TopicLog, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if tl, exists := s.logs[name]; exists {
+		return tl, nil
+	}
+
+	dir := filepath.Join(s.opts.Dir, topicDirPrefix+url.QueryEscape(name))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	tl := &TopicLog{dir: dir, opts: s.opts}
+	if err := tl.load(); err != nil {
+		return nil, err
+	}
+
+	s.logs[name] = tl
+	return tl, nil
+}
+
+// DeleteTopic closes a topic's log and removes it from disk
+func (s *Store) DeleteTopic(name string) error {
+	s.mu.Lock()
+	tl, exists := s.logs[name]
+	delete(s.logs, name)
+	s.mu.Unlock()
+
+	if exists {
+		tl.Close()
+	}
+	return os.RemoveAll(filepath.Join(s.opts.Dir, topicDirPrefix+url.QueryEscape(name)))
+}
+
+// Close syncs and closes every open topic log
+func (s *Store) Close() error {
+	select {
+	case <-s.stop:
+		return nil
+	default:
+		close(s.stop)
+	}
+	<-s.done
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var firstErr error
+	for name, tl := range s.logs {
+		if err := tl.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(s.logs, name)
+	}
+	return firstErr
+}
+
+// syncLoop periodically flushes dirty logs for the interval fsync policy
+func (s *Store) syncLoop() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.opts.FsyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.mu.Lock()
+			logs := make([]*TopicLog, 0, len(s.logs))
+			for _, tl := range s.logs {
+				logs = append(logs, tl)
+			}
+			s.mu.Unlock()
+
+			for _, tl := range logs {
+				if err := tl.Sync(); err != nil {
+					log.Printf("WAL sync failed for %s: %v", tl.dir, err)
+				}
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// segment describes one file of a topic log
+type segment struct {
+	base uint64 // offset of the first record
+	path string
+}
+
+// TopicLog is a segmented append-only log of messages for a single topic
+type TopicLog struct {
+	dir      string
+	opts     Options
+	mu       sync.Mutex
+	segments []segment
+	active   *os.File
+	size     int64
+	dirty    bool
+	closed   bool
+}
+
+// load discovers existing segments and prepares the last one for appends
+func (tl *TopicLog) load() error {
+	entries, err := os.ReadDir(tl.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), segmentSuffix) {
+			continue
+		}
+		base, err := strconv.ParseUint(strings.TrimSuffix(entry.Name(), segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		tl.segments = append(tl.segments, segment{base: base, path: filepath.Join(tl.dir, entry.Name())})
+	}
+	sort.Slice(tl.segments, func(i, j int) bool { return tl.segments[i].base < tl.segments[j].base })
+
+	if len(tl.segments) == 0 {
+		return tl.roll(0)
+	}
+
+	// Only the tail of the last segment can be torn by a crash
+	last := tl.segments[len(tl.segments)-1]
+	_, validSize, err := scanSegment(last.path, nil)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(last.path, os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	if info, err := f.Stat(); err == nil && info.Size() > validSize {
+		log.Printf("WAL recovery: truncating %s from %d to %d bytes", last.path, info.Size(), validSize)
+		if err := f.Truncate(validSize); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if _, err := f.Seek(validSize, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+
+	tl.active = f
+	tl.size = validSize
+	return nil
+}
+
+// roll closes the active segment and starts a new one whose first record
+// will have the given offset
+func (tl *TopicLog) roll(base uint64) error {
+	if tl.active != nil {
+		if err := tl.active.Sync(); err != nil {
+			return err
+		}
+		if err := tl.active.Close(); err != nil {
+			return err
+		}
+	}
+
+	path := filepath.Join(tl.dir, fmt.Sprintf("%020d%s", base, segmentSuffix))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	tl.active = f
+	tl.size = 0
+	tl.dirty = false
+	tl.segments = append(tl.segments, segment{base: base, path: path})
+	return nil
+}
+
+// Append writes a message to the end of the log
+func (tl *TopicLog) Append(msg *models.Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+
+	if tl.closed {
+		return fmt.Errorf("log is closed")
+	}
+
+	if tl.size > 0 && tl.size+int64(recordHeader+len(data)) > tl.opts.SegmentBytes {
+		if err := tl.roll(uint64(msg.Offset)); err != nil {
+			return err
+		}
+	}
+
+	buf := make([]byte, recordHeader+len(data))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(data))
+	copy(buf[recordHeader:], data)
+
+	if _, err := tl.active.Write(buf); err != nil {
+		return err
+	}
+	tl.size += int64(len(buf))
+
+	if tl.opts.Fsync == FsyncAlways {
+		return tl.active.Sync()
+	}
+	tl.dirty = true
+	return nil
+}
+
+// TruncateBefore deletes whole segments whose records all have offsets below
+// the given offset. The active segment is never removed.
+func (tl *TopicLog) TruncateBefore(offset int64) error {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+
+	// A segment ends where the next one begins
+	for len(tl.segments) > 1 && int64(tl.segments[1].base) <= offset {
+		if err := os.Remove(tl.segments[0].path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		tl.segments = tl.segments[1:]
+	}
+	return nil
+}
+
+// WriteMeta atomically replaces the topic's metadata file with v as JSON
+func (tl *TopicLog) WriteMeta(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+
+	tmp := filepath.Join(tl.dir, metaFile+".tmp")
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(tl.dir, metaFile))
+}
+
+// ReadMeta decodes the topic's metadata file into v. It reports false if no
+// metadata has been written.
+func (tl *TopicLog) ReadMeta(v interface{}) (bool, error) {
+	data, err := os.ReadFile(filepath.Join(tl.dir, metaFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, json.Unmarshal(data, v)
+}
+
+// Replay calls fn for every message in the log, oldest first
+func (tl *TopicLog) Replay(fn func(msg *models.Message)) error {
+	tl.mu.Lock()
+	segments := make([]segment, len(tl.segments))
+	copy(segments, tl.segments)
+	tl.mu.Unlock()
+
+	for _, seg := range segments {
+		if _, _, err := scanSegment(seg.path, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sync flushes the active segment if it has unsynced writes
+func (tl *TopicLog) Sync() error {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+
+	if tl.closed || !tl.dirty {
+		return nil
+	}
+	tl.dirty = false
+	return tl.active.Sync()
+}
+
+// Close syncs and closes the active segment
+func (tl *TopicLog) Close() error {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+
+	if tl.closed {
+		return nil
+	}
+	tl.closed = true
+
+	if err := tl.active.Sync(); err != nil {
+		tl.active.Close()
+		return err
+	}
+	return tl.active.Close()
+}
+
+// scanSegment reads records from a segment file until EOF or the first
+// invalid record, returning the number of valid records and their total size
+func scanSegment(path string, fn func(msg *models.Message)) (uint64, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, 0, nil
+		}
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var (
+		count  uint64
+		offset int64
+		header [recordHeader]byte
+	)
+
+	for {
+		if _, err := io.ReadFull(f, header[:]); err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+				return count, offset, err
+			}
+			return count, offset, nil
+		}
+
+		length := binary.BigEndian.Uint32(header[0:4])
+		checksum := binary.BigEndian.Uint32(header[4:8])
+
+		// A length running past the end of the file means a torn header
+		if offset+int64(recordHeader)+int64(length) > info.Size() {
+			return count, offset, nil
+		}
+
+		data := make([]byte, length)
+		if _, err := io.ReadFull(f, data); err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+				return count, offset, err
+			}
+			return count, offset, nil
+		}
+
+		if crc32.ChecksumIEEE(data) != checksum {
+			log.Printf("WAL checksum mismatch in %s at offset %d", path, offset)
+			return count, offset, nil
+		}
+
+		if fn != nil {
+			var msg models.Message
+			if err := json.Unmarshal(data, &msg); err != nil {
+				log.Printf("WAL skipping undecodable record in %s at offset %d: %v", path, offset, err)
+			} else {
+				fn(&msg)
+			}
+		}
+
+		count++
+		offset += int64(recordHeader) + int64(length)
+	}
+}
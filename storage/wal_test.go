@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"pub-sub-system/models"
+)
+
+// openTestLog opens the log of topic "orders" in a store rooted at dir
+func openTestLog(t *testing.T, dir string, segmentBytes int64) (*Store, *TopicLog) {
+	t.Helper()
+	store, err := Open(Options{Dir: dir, Fsync: FsyncNever, SegmentBytes: segmentBytes})
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	tl, err := store.OpenTopic("orders")
+	if err != nil {
+		store.Close()
+		t.Fatalf("OpenTopic() error = %v", err)
+	}
+	return store, tl
+}
+
+// appendTest appends messages with the given offsets
+func appendTest(t *testing.T, tl *TopicLog, offsets ...int64) {
+	t.Helper()
+	for _, offset := range offsets {
+		msg := &models.Message{ID: "m", Offset: offset, Payload: "payload"}
+		if err := tl.Append(msg); err != nil {
+			t.Fatalf("Append(offset %d) error = %v", offset, err)
+		}
+	}
+}
+
+// replayOffsets returns the offsets of every message in the log
+func replayOffsets(t *testing.T, tl *TopicLog) []int64 {
+	t.Helper()
+	var offsets []int64
+	if err := tl.Replay(func(msg *models.Message) { offsets = append(offsets, msg.Offset) }); err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+	return offsets
+}
+
+func checkOffsets(t *testing.T, got []int64, want ...int64) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("offsets = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("offsets = %v, want %v", got, want)
+		}
+	}
+}
+
+// segmentFiles returns the segment file names of the "orders" log
+func segmentFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	matches, err := filepath.Glob(filepath.Join(dir, topicDirPrefix+"orders", "*"+segmentSuffix))
+	if err != nil {
+		t.Fatalf("Glob() error = %v", err)
+	}
+	return matches
+}
+
+func TestWriteAndReopen(t *testing.T) {
+	dir := t.TempDir()
+	store, tl := openTestLog(t, dir, 0)
+	appendTest(t, tl, 0, 1, 2)
+	if err := tl.WriteMeta(map[string]int{"max_messages": 5}); err != nil {
+		t.Fatalf("WriteMeta() error = %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	store, tl = openTestLog(t, dir, 0)
+	defer store.Close()
+
+	names, err := store.Topics()
+	if err != nil || len(names) != 1 || names[0] != "orders" {
+		t.Fatalf("Topics() = %v, %v, want [orders]", names, err)
+	}
+	checkOffsets(t, replayOffsets(t, tl), 0, 1, 2)
+
+	var meta map[string]int
+	if found, err := tl.ReadMeta(&meta); !found || err != nil || meta["max_messages"] != 5 {
+		t.Fatalf("ReadMeta() = %v, %v, %v, want max_messages 5", meta, found, err)
+	}
+
+	// Appends continue after the recovered records
+	appendTest(t, tl, 3)
+	checkOffsets(t, replayOffsets(t, tl), 0, 1, 2, 3)
+}
+
+func TestTornTailIsTruncated(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(data []byte) []byte
+		want    []int64 // Offsets that survive
+	}{
+		{"partial header", func(data []byte) []byte { return append(data, 0, 0, 0) }, []int64{0, 1}},
+		{"length past end", func(data []byte) []byte { return append(data, 0, 0, 1, 0, 0, 0, 0, 0, '{') }, []int64{0, 1}},
+		{"partial record", func(data []byte) []byte { return data[:len(data)-5] }, []int64{0}},
+		{"bad checksum", func(data []byte) []byte {
+			data[len(data)-2] ^= 0xff
+			return data
+		}, []int64{0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			store, tl := openTestLog(t, dir, 0)
+			appendTest(t, tl, 0, 1)
+			store.Close()
+
+			segments := segmentFiles(t, dir)
+			if len(segments) != 1 {
+				t.Fatalf("segments = %v, want one", segments)
+			}
+			data, err := os.ReadFile(segments[0])
+			if err != nil {
+				t.Fatalf("ReadFile() error = %v", err)
+			}
+			if err := os.WriteFile(segments[0], tt.corrupt(data), 0o644); err != nil {
+				t.Fatalf("WriteFile() error = %v", err)
+			}
+
+			store, tl = openTestLog(t, dir, 0)
+			defer store.Close()
+
+			checkOffsets(t, replayOffsets(t, tl), tt.want...)
+
+			// The torn bytes are gone, so a new record is readable after the
+			// surviving ones
+			appendTest(t, tl, 7)
+			checkOffsets(t, replayOffsets(t, tl), append(tt.want, 7)...)
+		})
+	}
+}
+
+func TestRollAndTruncateBefore(t *testing.T) {
+	dir := t.TempDir()
+
+	// Each segment holds a single record
+	store, tl := openTestLog(t, dir, 1)
+	appendTest(t, tl, 0, 1, 2, 3, 4)
+	if got := len(segmentFiles(t, dir)); got != 5 {
+		t.Fatalf("segments = %d, want 5", got)
+	}
+	checkOffsets(t, replayOffsets(t, tl), 0, 1, 2, 3, 4)
+
+	// Only segments whose records are all below the offset are deleted
+	if err := tl.TruncateBefore(3); err != nil {
+		t.Fatalf("TruncateBefore() error = %v", err)
+	}
+	checkOffsets(t, replayOffsets(t, tl), 3, 4)
+
+	// The active segment is kept even when all of it is below the offset
+	if err := tl.TruncateBefore(10); err != nil {
+		t.Fatalf("TruncateBefore() error = %v", err)
+	}
+	checkOffsets(t, replayOffsets(t, tl), 4)
+	store.Close()
+
+	// Segments are never deleted just for being many
+	store, tl = openTestLog(t, dir, 1)
+	defer store.Close()
+	appendTest(t, tl, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14)
+	checkOffsets(t, replayOffsets(t, tl), 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14)
+	if got := len(segmentFiles(t, dir)); got != 11 {
+		t.Fatalf("segments = %d, want 11", got)
+	}
+}
+
+func TestDeleteTopicRemovesLog(t *testing.T) {
+	dir := t.TempDir()
+	store, tl := openTestLog(t, dir, 0)
+	defer store.Close()
+	appendTest(t, tl, 0)
+
+	if err := store.DeleteTopic("orders"); err != nil {
+		t.Fatalf("DeleteTopic() error = %v", err)
+	}
+	if names, err := store.Topics(); err != nil || len(names) != 0 {
+		t.Fatalf("Topics() = %v, %v, want none", names, err)
+	}
+}